@@ -0,0 +1,107 @@
+// Copyright © 2018 NAME HERE <EMAIL ADDRESS>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"fmt"
+	"net"
+	"time"
+
+	"github.com/paulczar/gcp-lb-tags/pkg"
+	"github.com/paulczar/gcp-lb-tags/pkg/agent"
+	"github.com/paulczar/gcp-lb-tags/pkg/cloud"
+	"github.com/paulczar/gcp-lb-tags/pkg/metadata"
+	"github.com/paulczar/gcp-lb-tags/pkg/probe"
+	"github.com/spf13/cobra"
+)
+
+var agentReady probe.Config
+
+// agentCmd represents the agent command
+var agentCmd = &cobra.Command{
+	Use:   "agent",
+	Short: "registers the instance it runs on with a load balancer while it is ready",
+	Long: `
+agent runs on a member instance. It finds its own instance through the metadata
+server, adds it to the target pool of the --name load balancer once its local
+readiness probe passes and removes it again when the probe fails, when the
+instance is being preempted (instance/preempted), before a host maintenance
+event such as a live migration (instance/maintenance-event) and on SIGTERM or
+SIGINT, within --shutdown-grace.
+
+The target pool must already exist, created by create or apply. When those run
+in a loop, the instance must also match their --labels or they take it out
+again.`,
+	PreRunE: func(cmd *cobra.Command, args []string) error {
+		if err := util.CheckRequiredFlags(cmd, "name"); err != nil {
+			return err
+		}
+		switch agentReady.Mode {
+		case probe.ModeTCP, probe.ModeTLS, probe.ModeHTTP, probe.ModeHTTPS:
+		default:
+			return fmt.Errorf("--ready-probe must be tcp, tls, http or https")
+		}
+		return nil
+	},
+	RunE: func(cmd *cobra.Command, args []string) error {
+		i := instance
+		if i == nil {
+			var err error
+			if i, err = metadata.Load(); err != nil {
+				return err
+			}
+		}
+		if !cmd.Flags().Changed("project") {
+			config.ProjectID = i.Project
+		}
+		if !cmd.Flags().Changed("network") {
+			config.Network = i.Network
+		}
+		if !cmd.Flags().Changed("region") {
+			config.Region = i.Region
+		}
+		if agentReady.Port == "" {
+			agentReady.Port = config.Port
+		}
+		cmd.SilenceUsage = true
+		client, err := cloud.New(ctx, config.ProjectID, config.Network, config.Region, operationTimeout)
+		if err != nil {
+			return err
+		}
+		a := &agent.Agent{
+			Members:   client,
+			Config:    config,
+			Zone:      i.Zone,
+			Instance:  i.Name,
+			Ready:     agentReady,
+			ReadyAddr: net.JoinHostPort("127.0.0.1", agentReady.Port),
+			Grace:     shutdownGrace,
+		}
+		fmt.Printf("====> Registering %s in %s with %s once it is ready\n", i.Name, i.Zone, config.Name)
+		return a.Run(ctx)
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(agentCmd)
+	agentCmd.Flags().StringVar(&agentReady.Mode, "ready-probe", "tcp", "how the local service is probed for readiness, tcp, tls, http or https")
+	agentCmd.Flags().StringVar(&agentReady.Port, "ready-port", "", "local port probed (defaults to --port)")
+	agentCmd.Flags().StringVar(&agentReady.Path, "ready-path", "/", "path requested by http and https probes")
+	agentCmd.Flags().DurationVar(&agentReady.Timeout, "ready-timeout", 2*time.Second, "timeout of each probe")
+	agentCmd.Flags().DurationVar(&agentReady.Interval, "ready-interval", 5*time.Second, "how often the local service is probed")
+	agentCmd.Flags().IntVar(&agentReady.HealthyThreshold, "ready-healthy-threshold", 2, "consecutive passing probes before the instance is registered")
+	agentCmd.Flags().IntVar(&agentReady.UnhealthyThreshold, "ready-unhealthy-threshold", 3, "consecutive failing probes before the instance is deregistered")
+	agentCmd.Flags().DurationVar(&shutdownGrace, "shutdown-grace", 30*time.Second, "how long deregistering on SIGTERM or SIGINT may take")
+}
@@ -0,0 +1,643 @@
+// Copyright © 2018 NAME HERE <EMAIL ADDRESS>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"sync"
+	"time"
+
+	"github.com/paulczar/gcp-lb-tags/pkg"
+	"github.com/paulczar/gcp-lb-tags/pkg/cloud"
+	"github.com/paulczar/gcp-lb-tags/pkg/events"
+	"github.com/paulczar/gcp-lb-tags/pkg/metrics"
+	"github.com/paulczar/gcp-lb-tags/pkg/shard"
+	"github.com/paulczar/gcp-lb-tags/pkg/spec"
+	"github.com/paulczar/gcp-lb-tags/pkg/state"
+	"github.com/spf13/cobra"
+)
+
+var (
+	applyFile        string
+	applyConcurrency int
+	applyWatch       bool
+	applyWatchDir    string
+	applyOnRemove    string
+)
+
+// applyCmd represents the apply command
+var applyCmd = &cobra.Command{
+	Use:   "apply -f <file>",
+	Short: "reconciles every load balancer declared in a config file",
+	Long: `
+apply reads a YAML or JSON config file declaring any number of load balancers
+and reconciles them all, at most --concurrency at a time. The project, region and
+network of a load balancer default to the --project, --region and --network flags.
+
+  apiVersion: gcp-lb-tags/v1
+  loadBalancers:
+  - name: pks-demo
+    project: my-project
+    region: us-central1
+    network: default
+    mode: tcp
+    ports: ["8443"]
+    selector:
+      labels:
+        job: master
+    firewall:
+      tags: [master]
+    healthCheck:
+      port: "8080"
+      path: /healthz
+
+The result of each load balancer is reported separately. With --loop each load
+balancer is reconciled on its own schedule and backs off on its own failures.
+The config file is reloaded when it changes, when a file in --watch-dir changes
+or on SIGHUP: new load balancers are created, changed ones reconciled straight
+away and removed ones orphaned or destroyed according to --on-remove. A config
+file that fails to load or validate is reported and the current one kept. With
+--leader-elect only the replica holding the leader lease runs the loops. With
+--shard-peers the load balancers are spread over the replicas instead, each
+running the loops of its consistent-hash share.`,
+	PreRunE: func(cmd *cobra.Command, args []string) error {
+		if applyConcurrency < 1 {
+			return fmt.Errorf("--concurrency must be at least 1")
+		}
+		if applyOnRemove != removeOrphan && applyOnRemove != removeDestroy {
+			return fmt.Errorf("--on-remove must be %s or %s", removeOrphan, removeDestroy)
+		}
+		if err := checkLeaderFlags(loop); err != nil {
+			return err
+		}
+		if err := checkShardFlags(cmd); err != nil {
+			return err
+		}
+		return util.CheckRequiredFlags(cmd, "filename")
+	},
+	RunE: func(cmd *cobra.Command, args []string) error {
+		f, err := loadApplyFile()
+		if err != nil {
+			return err
+		}
+		st, err := state.Open(stateFile)
+		if err != nil {
+			return err
+		}
+		config.State = st
+		if metricsAddr != "" {
+			metrics.Serve(metricsAddr)
+		}
+		cmd.SilenceUsage = true
+		if loop {
+			leading := false
+			return lead(fileProject(f), config.Region, func(stop context.Context) error {
+				if leading {
+					// the file may have changed while another replica led
+					if nf, err := loadApplyFile(); err == nil {
+						f = nf
+					}
+				}
+				leading = true
+				return applyLoop(stop, f)
+			})
+		}
+		ef, expandErr := expand(f)
+		if ef == nil {
+			return expandErr
+		}
+		cfgs := []*cloud.Config{}
+		for _, lb := range ef.LoadBalancers {
+			cfgs = append(cfgs, lb.Config(config))
+		}
+		if err := applyOnce(cfgs); err != nil {
+			return err
+		}
+		return expandErr
+	},
+}
+
+// loadApplyFile loads and validates the config file.
+func loadApplyFile() (*spec.File, error) {
+	f, err := spec.Load(applyFile)
+	if err != nil {
+		return nil, err
+	}
+	if err = f.Validate(config); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+// fileProject is the project of the first load balancer of f, where its
+// Pub/Sub subscription and leader lock live.
+func fileProject(f *spec.File) string {
+	if len(f.LoadBalancers) > 0 {
+		return f.LoadBalancers[0].Config(config).ProjectID
+	}
+	return config.ProjectID
+}
+
+// applyResult is the outcome of reconciling one load balancer.
+type applyResult struct {
+	name     string
+	err      error
+	duration time.Duration
+}
+
+// applyOnce reconciles every load balancer once and reports each result.
+func applyOnce(cfgs []*cloud.Config) error {
+	sem := make(chan struct{}, applyConcurrency)
+	results := make([]*applyResult, len(cfgs))
+	var wg sync.WaitGroup
+	for i, cfg := range cfgs {
+		wg.Add(1)
+		go func(i int, cfg *cloud.Config) {
+			defer wg.Done()
+			sem <- struct{}{}
+			defer func() { <-sem }()
+			start := time.Now()
+			err := applyOne(cfg)
+			results[i] = &applyResult{name: cfg.Name, err: err, duration: time.Since(start).Round(time.Second)}
+		}(i, cfg)
+	}
+	wg.Wait()
+
+	failed := 0
+	w := newTable()
+	fmt.Fprintln(w, "LOAD BALANCER\tRESULT\tDURATION\tERROR")
+	for _, r := range results {
+		result, msg := "reconciled", ""
+		if r.err != nil {
+			failed++
+			result, msg = "failed ("+cloud.ErrorClass(r.err)+")", r.err.Error()
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", r.name, result, r.duration, msg)
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d of %d load balancers failed to reconcile", failed, len(cfgs))
+	}
+	return nil
+}
+
+func applyOne(cfg *cloud.Config) error {
+	client, err := cloud.New(ctx, cfg.ProjectID, cfg.Network, cfg.Region, operationTimeout)
+	if err != nil {
+		return err
+	}
+	rctx, cancel := reconcileContext(ctx)
+	defer cancel()
+	return client.CreateLoadBalancer(rctx, cfg)
+}
+
+// Policies for the load balancers removed from the config file.
+const (
+	removeOrphan  = "orphan"
+	removeDestroy = "destroy"
+)
+
+// applyLoop runs a reconcile loop per load balancer of f until stop is done.
+// A load balancer that gives up doesn't stop the others.
+// The config file is reloaded when it changes or on SIGHUP, and the groups of
+// discovery load balancers are looked up again every --seconds.
+func applyLoop(stop context.Context, f *spec.File) error {
+	reloads, err := watchConfig(stop, applyFile, applyWatch, applyWatchDir)
+	if err != nil {
+		return err
+	}
+	a := &applier{stop: stop, loops: map[string]*lbLoop{}, sem: make(chan struct{}, applyConcurrency)}
+	var shardUpdates <-chan struct{}
+	if shardPeers != "" {
+		if a.shard, err = newSharder(stop); err != nil {
+			return err
+		}
+		defer a.shard.Leave()
+		shardUpdates = a.shard.Updates()
+	}
+	if err := subscribe(stop, fileProject(f), a.dispatch); err != nil {
+		return err
+	}
+	// discovery load balancers look for new and gone groups every loop
+	rediscover := time.NewTicker(time.Duration(seconds) * time.Second)
+	defer rediscover.Stop()
+	ef, gone := expandLoop(f)
+	if ef != nil {
+		a.update(f, ef, gone)
+	}
+	for {
+		select {
+		case <-stop.Done():
+			a.wg.Wait()
+			return a.result()
+		case <-shardUpdates:
+			// load balancers may have moved between replicas, or be
+			// handed over by now
+			if ef != nil {
+				a.update(f, ef, gone)
+			}
+		case <-rediscover.C:
+			if !f.Discovers() {
+				continue
+			}
+			if nef, ngone := expandLoop(f); nef != nil {
+				ef, gone = nef, ngone
+				a.update(f, ef, gone)
+			}
+		case reason := <-reloads:
+			fmt.Printf("====> Reloading %s after %s\n", applyFile, reason)
+			nf, err := loadApplyFile()
+			if err != nil {
+				fmt.Printf("====> Keeping the current config: %s\n", err)
+				continue
+			}
+			if nef, ngone := expandLoop(nf); nef != nil {
+				f, ef, gone = nf, nef, ngone
+				a.update(f, ef, gone)
+			}
+		}
+	}
+}
+
+// expandLoop expands f and looks for the leftover load balancers of its
+// discovery templates, whose group is gone. It returns nil when either
+// fails, so the current loops are kept.
+func expandLoop(f *spec.File) (*spec.File, []*spec.LoadBalancer) {
+	ef, _ := expand(f)
+	if ef == nil {
+		return nil, nil
+	}
+	gone, err := f.Leftovers(stampedGroups, ef)
+	if err != nil {
+		fmt.Printf("====> Keeping the current load balancers: %s\n", err)
+		return nil, nil
+	}
+	return ef, gone
+}
+
+// expand stamps out the discovery load balancers of f. It returns nil when
+// discovery fails; groups that can't be stamped out are reported and left
+// out, and returned as the error.
+func expand(f *spec.File) (*spec.File, error) {
+	ef, err := f.Expand(discoverGroups, config)
+	if ef == nil {
+		fmt.Printf("====> Keeping the current load balancers: %s\n", err)
+		return nil, err
+	}
+	if err != nil {
+		fmt.Printf("====> Leaving out discovered load balancers: %s\n", err)
+	}
+	return ef, err
+}
+
+// discoverGroups lists the groups of the discovery load balancer lb.
+func discoverGroups(lb *spec.LoadBalancer) ([]string, error) {
+	cfg := lb.Config(config)
+	// the tags are templates, and only shape the firewall anyway
+	cfg.Tags = nil
+	client, err := cloud.New(ctx, cfg.ProjectID, cfg.Network, cfg.Region, operationTimeout)
+	if err != nil {
+		return nil, err
+	}
+	return client.ListLabelValues(ctx, cfg, lb.Discover.GroupLabel)
+}
+
+// stampedGroups lists the groups of the discovery load balancer lb that still
+// have a forwarding rule.
+func stampedGroups(lb *spec.LoadBalancer) ([]string, error) {
+	cfg := lb.Config(config)
+	client, err := cloud.New(ctx, cfg.ProjectID, cfg.Network, cfg.Region, operationTimeout)
+	if err != nil {
+		return nil, err
+	}
+	return client.ListDiscoveredGroups(ctx, cfg)
+}
+
+// applier keeps a reconcile loop running for every load balancer of the
+// config file, until stop is done. With shard set, it only runs the load
+// balancers this replica owns.
+type applier struct {
+	stop  context.Context
+	shard *shard.Sharder
+	mu    sync.Mutex
+	loops map[string]*lbLoop
+	sem   chan struct{}
+	wg    sync.WaitGroup
+}
+
+// lbLoop is the reconcile loop of one load balancer.
+type lbLoop struct {
+	lb       *spec.LoadBalancer
+	cfg      *cloud.Config
+	triggers chan *events.Event
+	updates  chan *cloud.Config
+	stop     context.CancelFunc
+	done     chan struct{}
+	// cleanup is set on the loop of a discovered load balancer whose group
+	// is gone, which only runs its cleanup and ends once that has removed
+	// it.
+	cleanup bool
+	// err is why the loop gave up, read once done is closed.
+	err error
+}
+
+func (l *lbLoop) stopped() bool {
+	select {
+	case <-l.done:
+		return true
+	default:
+		return false
+	}
+}
+
+// update brings the running loops in line with ef, f expanded: new load
+// balancers get a loop, changed ones are reconciled with their new config
+// straight away and removed ones are orphaned or destroyed according to
+// --on-remove. A load balancer moved to another project, region or network is
+// handled as removed from where it was and added where it is now. A
+// discovered load balancer whose group is gone, in gone or still running,
+// gets a cleanup loop instead when it has a cleanup period, which ends once
+// its cleanup has removed it. When sharding, a load balancer that now belongs
+// to another replica is stopped and handed over, and a new one is started
+// once its previous owner has handed it over.
+func (a *applier) update(f, ef *spec.File, gone []*spec.LoadBalancer) {
+	wanted := map[string]*spec.LoadBalancer{}
+	for _, lb := range ef.LoadBalancers {
+		wanted[lb.Name] = lb
+	}
+	leftovers := map[string]*spec.LoadBalancer{}
+	for _, lb := range gone {
+		leftovers[lb.Name] = lb
+	}
+	templates := map[string]bool{}
+	for _, lb := range f.LoadBalancers {
+		if lb.Discover != nil {
+			templates[lb.Name] = true
+		}
+	}
+	a.mu.Lock()
+	// a loop started before its forwarding rule was stamped isn't found by
+	// the stamp
+	for name, l := range a.loops {
+		if _, ok := wanted[name]; !ok && leftovers[name] == nil && templates[l.lb.Template] && l.cfg.Cleanup.After > 0 && !(l.cleanup && l.stopped()) {
+			leftovers[name] = l.lb
+		}
+	}
+	removed, handedOver, replaced, finished := []*lbLoop{}, []*lbLoop{}, []*lbLoop{}, []*lbLoop{}
+	for name, l := range a.loops {
+		lb, ok := wanted[name]
+		leftover, isLeftover := leftovers[name]
+		cleaned := l.cleanup && l.stopped() && l.err == nil
+		switch {
+		case isLeftover && !a.owns(name):
+			delete(a.loops, name)
+			handedOver = append(handedOver, l)
+		case isLeftover && cleaned:
+			// kept so it isn't cleaned up again while a kept forwarding
+			// rule still carries its stamp
+		case isLeftover && (!l.cleanup || l.stopped() || !reflect.DeepEqual(l.lb, leftover)):
+			delete(a.loops, name)
+			replaced = append(replaced, l)
+		case isLeftover:
+			// still cleaning up
+		case !ok && cleaned:
+			delete(a.loops, name)
+			finished = append(finished, l)
+		case !ok || moved(l.cfg, lb.Config(config)):
+			// this replica still claims it, so it is the one removing it
+			delete(a.loops, name)
+			removed = append(removed, l)
+		case !a.owns(name):
+			delete(a.loops, name)
+			handedOver = append(handedOver, l)
+		case l.cleanup || l.stopped():
+			// the group is back, or the loop gave up and a reload starts
+			// it again
+			delete(a.loops, name)
+			replaced = append(replaced, l)
+		}
+	}
+	a.mu.Unlock()
+	for _, l := range append(handedOver, replaced...) {
+		l.stop()
+	}
+	for _, l := range replaced {
+		<-l.done
+	}
+	released := []string{}
+	for _, l := range handedOver {
+		<-l.done
+		fmt.Printf("====> Handed %s over to another replica\n", l.cfg.Name)
+		released = append(released, l.cfg.Name)
+	}
+	for _, l := range removed {
+		a.remove(l)
+		released = append(released, l.cfg.Name)
+	}
+	for _, l := range finished {
+		released = append(released, l.cfg.Name)
+	}
+	if a.shard != nil {
+		a.shard.Release(released)
+	}
+
+	lbs := append([]*spec.LoadBalancer{}, ef.LoadBalancers...)
+	for _, lb := range leftovers {
+		lbs = append(lbs, lb)
+	}
+	starting := []string{}
+	a.mu.Lock()
+	for _, lb := range lbs {
+		if _, ok := a.loops[lb.Name]; !ok && a.owns(lb.Name) {
+			starting = append(starting, lb.Name)
+		}
+	}
+	a.mu.Unlock()
+	if a.shard != nil {
+		starting = a.shard.Acquire(a.stop, starting)
+	}
+	acquired := map[string]bool{}
+	for _, name := range starting {
+		acquired[name] = true
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	for _, lb := range lbs {
+		cfg := lb.Config(config)
+		_, cleanup := leftovers[lb.Name]
+		l, ok := a.loops[lb.Name]
+		switch {
+		case !ok:
+			if acquired[lb.Name] {
+				a.start(lb, cfg, cleanup)
+			}
+		case !cleanup && !reflect.DeepEqual(l.lb, lb):
+			l.lb, l.cfg = lb, cfg
+			// a pending update is stale, replace it
+			select {
+			case <-l.updates:
+			default:
+			}
+			l.updates <- cfg
+		}
+	}
+}
+
+// owns tells whether this replica reconciles the load balancer name.
+func (a *applier) owns(name string) bool {
+	return a.shard == nil || a.shard.Owns(name)
+}
+
+// moved tells whether cfg now lives in another project, region or network
+// than current.
+func moved(current, cfg *cloud.Config) bool {
+	return current.ProjectID != cfg.ProjectID || current.Region != cfg.Region || current.Network != cfg.Network
+}
+
+// start runs the reconcile loop of lb, or its cleanup loop when cleanup is
+// set, with a.mu held.
+func (a *applier) start(lb *spec.LoadBalancer, cfg *cloud.Config, cleanup bool) {
+	lctx, stop := context.WithCancel(a.stop)
+	l := &lbLoop{
+		lb:       lb,
+		cfg:      cfg,
+		triggers: make(chan *events.Event, 1),
+		updates:  make(chan *cloud.Config, 1),
+		stop:     stop,
+		done:     make(chan struct{}),
+		cleanup:  cleanup,
+	}
+	a.loops[lb.Name] = l
+	a.wg.Add(1)
+	go func() {
+		defer a.wg.Done()
+		defer close(l.done)
+		client, err := cloud.New(lctx, cfg.ProjectID, cfg.Network, cfg.Region, operationTimeout)
+		switch {
+		case err == nil && cleanup:
+			err = cleanupLoop(lctx, client, cfg, a.sem)
+		case err == nil:
+			err = reconcileLoop(lctx, client, cfg, l.triggers, l.updates, a.sem)
+		}
+		if err != nil {
+			fmt.Printf("====> Stopped reconciling %s: %s\n", cfg.Name, err)
+			l.err = err
+		}
+	}()
+}
+
+// cleanupLoop runs the cleanup of a discovered load balancer whose group is
+// gone every --seconds, backing off on failures like reconcileLoop, until it
+// has removed the load balancer or stop is done.
+func cleanupLoop(stop context.Context, client cloud.Cloud, cfg *cloud.Config, sem chan struct{}) error {
+	fmt.Printf("====> The group of %s is gone, waiting for its cleanup\n", cfg.Name)
+	failures := cloud.NewFailures(cfg.Name, cfg.Backoff)
+	for {
+		sem <- struct{}{}
+		rctx, cancel := reconcileContext(stop)
+		removed, err := client.CleanupLoadBalancer(rctx, cfg)
+		cancel()
+		<-sem
+		if stop.Err() != nil {
+			fmt.Printf("====> Stopping %s\n", cfg.Name)
+			return nil
+		}
+		if removed && err == nil {
+			fmt.Printf("====> Removed %s, its group is gone\n", cfg.Name)
+			return nil
+		}
+		wait, err := failures.Record(err, time.Duration(seconds)*time.Second)
+		if err != nil {
+			return err
+		}
+		select {
+		case <-stop.Done():
+			fmt.Printf("====> Stopping %s\n", cfg.Name)
+			return nil
+		case <-time.After(wait):
+		}
+	}
+}
+
+// remove stops the loop of a load balancer no longer in the config and
+// destroys its resources when --on-remove is destroy. The external IP is
+// kept either way.
+func (a *applier) remove(l *lbLoop) {
+	l.stop()
+	<-l.done
+	if applyOnRemove != removeDestroy {
+		fmt.Printf("====> Orphaning %s, its resources are left in place\n", l.cfg.Name)
+		return
+	}
+	fmt.Printf("====> Destroying %s\n", l.cfg.Name)
+	a.sem <- struct{}{}
+	defer func() { <-a.sem }()
+	client, err := cloud.New(ctx, l.cfg.ProjectID, l.cfg.Network, l.cfg.Region, operationTimeout)
+	if err == nil {
+		rctx, cancel := reconcileContext(a.stop)
+		err = client.RemoveLoadBalancer(rctx, l.cfg, false)
+		cancel()
+	}
+	if err != nil {
+		fmt.Printf("====> Failed to destroy %s: %s\n", l.cfg.Name, err)
+	}
+}
+
+// dispatch triggers the loops of the load balancers e affects.
+func (a *applier) dispatch(e *events.Event) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	for _, l := range a.loops {
+		if e.Affects(l.cfg.Region, l.cfg.Labels) {
+			trigger(l.triggers, e)
+		}
+	}
+}
+
+// result reports the load balancers whose loop gave up, once every loop
+// has stopped.
+func (a *applier) result() error {
+	failed := 0
+	for _, l := range a.loops {
+		if l.err != nil {
+			failed++
+		}
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d of %d load balancers stopped on errors", failed, len(a.loops))
+	}
+	return nil
+}
+
+func init() {
+	rootCmd.AddCommand(applyCmd)
+	applyCmd.Flags().StringVarP(&applyFile, "filename", "f", "", "config file declaring the load balancers")
+	applyCmd.Flags().IntVar(&applyConcurrency, "concurrency", 4, "most load balancers reconciled at the same time")
+	applyCmd.Flags().BoolVar(&loop, "loop", false, "run in a continuous [seconds] loop")
+	applyCmd.Flags().IntVar(&seconds, "seconds", 120, "how long between each loop in seconds")
+	applyCmd.Flags().DurationVar(&reconcileTimeout, "reconcile-timeout", 0, "abandon a loop that takes longer than this (no limit when 0)")
+	applyCmd.Flags().DurationVar(&shutdownGrace, "shutdown-grace", 30*time.Second, "how long a loop in flight on SIGTERM or SIGINT gets to finish")
+	applyCmd.Flags().BoolVar(&applyWatch, "watch", true, "reload the config file when it changes (with --loop)")
+	applyCmd.Flags().StringVar(&applyWatchDir, "watch-dir", "", "also reload when a file in this directory changes, such as a mounted ConfigMap (with --loop)")
+	applyCmd.Flags().StringVar(&applyOnRemove, "on-remove", removeOrphan, "what happens to a load balancer removed from the config file, orphan leaves its resources in place and destroy deletes them but the external IP (with --loop)")
+	leaderFlags(applyCmd.Flags())
+	shardFlags(applyCmd)
+	applyCmd.Flags().StringVar(&pubsubSubscription, "pubsub-subscription", "", "Pub/Sub subscription of instance audit log or asset feed events that trigger an immediate loop")
+}
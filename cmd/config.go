@@ -0,0 +1,177 @@
+// Copyright © 2018 NAME HERE <EMAIL ADDRESS>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+	"github.com/spf13/viper"
+)
+
+// envPrefix prefixes the environment variable of every flag, --health-check-port
+// is read from GCPLBTAGS_HEALTH_CHECK_PORT.
+const envPrefix = "GCPLBTAGS"
+
+// Sources of a setting, from the highest precedence to the lowest.
+const (
+	sourceFlag    = "flag"
+	sourceEnv     = "env"
+	sourceFile    = "file"
+	sourceDefault = "default"
+)
+
+var configViewOutput string
+
+// settingSources records where the value of each bound flag came from. A
+// flag set from the environment or the config file counts as changed
+// afterwards, so its source is only worked out once.
+var settingSources = map[*pflag.Flag]string{}
+
+// envName returns the environment variable a flag is read from.
+func envName(flag string) string {
+	return envPrefix + "_" + strings.ToUpper(strings.Replace(flag, "-", "_", -1))
+}
+
+// bindFlags binds every flag of cmd to viper and sets the ones not given on
+// the command line from the environment or the config file, in that order,
+// so the rest of the command only has to look at its flags.
+func bindFlags(cmd *cobra.Command) error {
+	var err error
+	cmd.Flags().VisitAll(func(f *pflag.Flag) {
+		if err != nil || f.Name == "config" || f.Name == "help" {
+			return
+		}
+		if _, ok := settingSources[f]; ok {
+			return
+		}
+		if err = viper.BindPFlag(f.Name, f); err != nil {
+			return
+		}
+		source := sourceDefault
+		switch {
+		case f.Changed:
+			source = sourceFlag
+		case os.Getenv(envName(f.Name)) != "":
+			source = sourceEnv
+		case viper.InConfig(f.Name):
+			source = sourceFile
+		}
+		settingSources[f] = source
+		if source != sourceEnv && source != sourceFile {
+			return
+		}
+		value := fmt.Sprint(viper.Get(f.Name))
+		if strings.HasSuffix(f.Value.Type(), "Slice") {
+			value = strings.Join(viper.GetStringSlice(f.Name), ",")
+		}
+		if serr := cmd.Flags().Set(f.Name, value); serr != nil {
+			err = fmt.Errorf("invalid %s from %s: %s", f.Name, source, serr)
+		}
+	})
+	return err
+}
+
+// configCmd groups the commands about the tool's own configuration.
+var configCmd = &cobra.Command{
+	Use:   "config",
+	Short: "inspects the configuration of gcp-lb-tags",
+}
+
+// configViewCmd represents the config view command
+var configViewCmd = &cobra.Command{
+	Use:   "view [command]",
+	Short: "shows the effective configuration and where each value came from",
+	Long: `
+config view prints the value every setting resolves to and its source. A flag
+given on the command line wins over its GCPLBTAGS_* environment variable, which
+wins over the config file (--config, $HOME/.gcp-lb-tags.yaml by default), which
+wins over the flag's default. The environment variable of --health-check-port is
+GCPLBTAGS_HEALTH_CHECK_PORT, its config file key health-check-port.
+
+Without a command every setting of every command is shown, with one only the
+settings of that command.`,
+	Args: cobra.MaximumNArgs(1),
+	PreRunE: func(cmd *cobra.Command, args []string) error {
+		return checkOutputFormat(configViewOutput)
+	},
+	RunE: func(cmd *cobra.Command, args []string) error {
+		cmds := append([]*cobra.Command{rootCmd}, rootCmd.Commands()...)
+		if len(args) == 1 {
+			c, _, err := rootCmd.Find(args)
+			if err != nil || c == rootCmd {
+				return fmt.Errorf("unknown command %q", args[0])
+			}
+			cmds = []*cobra.Command{rootCmd, c}
+		}
+		settings := map[string]*setting{}
+		for _, c := range cmds {
+			if err := bindFlags(c); err != nil {
+				return err
+			}
+			c.LocalFlags().VisitAll(func(f *pflag.Flag) {
+				source, ok := settingSources[f]
+				if !ok {
+					return
+				}
+				if _, ok := settings[f.Name]; !ok {
+					settings[f.Name] = &setting{Key: f.Name, Value: f.Value.String(), Source: source, Env: envName(f.Name)}
+				}
+			})
+		}
+		list := []*setting{}
+		for _, s := range settings {
+			list = append(list, s)
+		}
+		sort.Slice(list, func(i, j int) bool { return list[i].Key < list[j].Key })
+
+		if configViewOutput == "json" {
+			return printJSON(&configView{File: viper.ConfigFileUsed(), Settings: list})
+		}
+		if f := viper.ConfigFileUsed(); f != "" {
+			fmt.Printf("config file: %s\n\n", f)
+		}
+		w := newTable()
+		fmt.Fprintln(w, "KEY\tVALUE\tSOURCE\tENV")
+		for _, s := range list {
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", s.Key, s.Value, s.Source, s.Env)
+		}
+		return w.Flush()
+	},
+}
+
+// configView is the JSON output of config view.
+type configView struct {
+	File     string     `json:"file,omitempty"`
+	Settings []*setting `json:"settings"`
+}
+
+// setting is the effective value of one flag.
+type setting struct {
+	Key    string `json:"key"`
+	Value  string `json:"value"`
+	Source string `json:"source"`
+	Env    string `json:"env"`
+}
+
+func init() {
+	rootCmd.AddCommand(configCmd)
+	configCmd.AddCommand(configViewCmd)
+	configViewCmd.Flags().StringVarP(&configViewOutput, "output", "o", "table", "output format, table or json")
+}
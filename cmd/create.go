@@ -15,17 +15,24 @@
 package cmd
 
 import (
+	"context"
 	"fmt"
 	"time"
 
 	"github.com/paulczar/gcp-lb-tags/pkg"
 	"github.com/paulczar/gcp-lb-tags/pkg/cloud"
+	"github.com/paulczar/gcp-lb-tags/pkg/events"
+	"github.com/paulczar/gcp-lb-tags/pkg/metrics"
+	"github.com/paulczar/gcp-lb-tags/pkg/state"
 	"github.com/spf13/cobra"
 )
 
 var (
-	loop    bool
-	seconds int
+	loop               bool
+	seconds            int
+	pubsubSubscription string
+	reconcileTimeout   time.Duration
+	shutdownGrace      time.Duration
 )
 
 // createCmd represents the run command
@@ -35,39 +42,200 @@ var createCmd = &cobra.Command{
 	Long: `
 gcp-lb-tags accepts a list of tags and will monitor a named load balancer's target
 pool and will modify that target pool to match the list of compute instances that
-match that those tags.`,
+match that those tags.
+
+With --loop and --leader-elect several replicas can run, only the one holding
+the leader lease reconciles and another takes over when it goes away.`,
 	PreRunE: func(cmd *cobra.Command, args []string) error {
-		return nil
+		if err := util.CheckRequiredFlags(cmd, requiredFlags...); err != nil {
+			return err
+		}
+		if err := checkLeaderFlags(loop); err != nil {
+			return err
+		}
+		return config.Cleanup.Keep(util.GetFlagStringSlice(cmd, "cleanup-keep"))
 	},
 	RunE: func(cmd *cobra.Command, args []string) error {
-		config.Tags = util.GetFlagStringSlice(cmd, "tags")
-		config.Labels = util.GetFlagStringSlice(cmd, "labels")
-		if config.Address == "" {
-			config.Address = config.Name
+		cfg, err := configFromFlags(cmd)
+		if err != nil {
+			return err
+		}
+		if err = cfg.Validate(); err != nil {
+			return err
 		}
-		client, err := cloud.New(config.ProjectID, config.Network, config.Region)
+		st, err := state.Open(stateFile)
 		if err != nil {
-			panic(err)
+			return err
+		}
+		config.State = st
+		if metricsAddr != "" {
+			metrics.Serve(metricsAddr)
+		}
+		client, err := cloud.New(ctx, config.ProjectID, config.Network, config.Region, operationTimeout)
+		if err != nil {
+			return err
 		}
 		config.Zones = nil
 		if loop {
-			for {
-				err := client.CreateLoadBalancer(config)
+			return lead(config.ProjectID, config.Region, func(stop context.Context) error {
+				triggers := make(chan *events.Event, 1)
+				err := subscribe(stop, config.ProjectID, func(e *events.Event) {
+					if e.Affects(config.Region, config.Labels) {
+						trigger(triggers, e)
+					}
+				})
 				if err != nil {
-					fmt.Printf("An error Occured: %s\n\n\n", err)
+					return err
 				}
-				time.Sleep(time.Duration(seconds) * time.Second)
-			}
+				return reconcileLoop(stop, client, config, triggers, nil, nil)
+			})
 		} else {
 			//fmt.Printf("zones: %v", config.Zones)
-			return client.CreateLoadBalancer(config)
+			rctx, cancel := reconcileContext(ctx)
+			defer cancel()
+			return client.CreateLoadBalancer(rctx, config)
 		}
 	},
 }
 
+// configFromFlags completes config with the flags that aren't bound to it.
+func configFromFlags(cmd *cobra.Command) (*cloud.Config, error) {
+	var err error
+	if config.Tags, err = renderFlags("tags", util.GetFlagStringSlice(cmd, "tags")); err != nil {
+		return nil, err
+	}
+	if config.Labels, err = renderFlags("labels", util.GetFlagStringSlice(cmd, "labels")); err != nil {
+		return nil, err
+	}
+	if config.Address == "" {
+		config.Address = config.Name
+	}
+	return config, nil
+}
+
+// reconcileLoop reconciles cfg every --seconds, or straight away on a
+// trigger, backing off on failures until stop is done. A config received on
+// updates replaces cfg from the next reconcile, which starts straight away.
+// When sem is set a slot is held for the length of each reconcile.
+func reconcileLoop(stop context.Context, client cloud.Cloud, cfg *cloud.Config, triggers <-chan *events.Event, updates <-chan *cloud.Config, sem chan struct{}) error {
+	failures := cloud.NewFailures(cfg.Name, cfg.Backoff)
+	for {
+		if sem != nil {
+			sem <- struct{}{}
+		}
+		rctx, cancel := reconcileContext(stop)
+		err := client.CreateLoadBalancer(rctx, cfg)
+		cancel()
+		if sem != nil {
+			<-sem
+		}
+		if stop.Err() != nil {
+			if err != nil {
+				fmt.Printf("====> Abandoned the reconcile of %s: %s\n", cfg.Name, err)
+			}
+			fmt.Printf("====> Stopping %s\n", cfg.Name)
+			return nil
+		}
+		wait, err := failures.Record(err, time.Duration(seconds)*time.Second)
+		if err != nil {
+			return err
+		}
+		select {
+		case <-stop.Done():
+			fmt.Printf("====> Stopping %s\n", cfg.Name)
+			return nil
+		case e := <-triggers:
+			fmt.Printf("====> Reconciling %s after %s\n", cfg.Name, e)
+		case cfg = <-updates:
+			fmt.Printf("====> Reconciling %s after a config change\n", cfg.Name)
+			failures = cloud.NewFailures(cfg.Name, cfg.Backoff)
+		case <-time.After(wait):
+		}
+	}
+}
+
+// reconcileContext returns the context of one reconcile. It is cancelled
+// after --reconcile-timeout, or --shutdown-grace after stop is done, so a
+// reconcile in flight gets the chance to finish its operations rather than
+// leave them half observed.
+func reconcileContext(stop context.Context) (context.Context, context.CancelFunc) {
+	rctx, cancel := context.WithCancel(context.Background())
+	if reconcileTimeout > 0 {
+		cancel()
+		rctx, cancel = context.WithTimeout(context.Background(), reconcileTimeout)
+	}
+	go func() {
+		select {
+		case <-stop.Done():
+			fmt.Printf("====> Stopping, giving the current reconcile %s to finish\n", shutdownGrace)
+			select {
+			case <-time.After(shutdownGrace):
+				cancel()
+			case <-rctx.Done():
+			}
+		case <-rctx.Done():
+		}
+	}()
+	return rctx, cancel
+}
+
+// subscribe starts pulling instance events from --pubsub-subscription of
+// project and hands each of them to handle until stop is done. It does
+// nothing when no subscription is set, so loops only run on their timer.
+func subscribe(stop context.Context, project string, handle func(*events.Event)) error {
+	if pubsubSubscription == "" {
+		return nil
+	}
+	sub, err := events.NewSubscriber(stop, project, pubsubSubscription)
+	if err != nil {
+		return err
+	}
+	go sub.Run(stop, handle)
+	return nil
+}
+
+// trigger queues e on triggers unless a trigger is already pending. A single
+// pending trigger is enough, the reconcile looks at every instance anyway.
+func trigger(triggers chan *events.Event, e *events.Event) {
+	select {
+	case triggers <- e:
+	default:
+	}
+}
+
 func init() {
 	rootCmd.AddCommand(createCmd)
 	// Here you will define your flags and configuration settings.
 	createCmd.Flags().BoolVar(&loop, "loop", false, "run in a continuous [seconds] loop")
 	createCmd.Flags().IntVar(&seconds, "seconds", 120, "how long between each loop in seconds")
+	createCmd.Flags().DurationVar(&config.Backoff.Initial, "backoff-initial", 5*time.Second, "delay before retrying a loop that failed with a retryable error, doubled on every further failure")
+	createCmd.Flags().DurationVar(&config.Backoff.Max, "backoff-max", 0, "longest delay between retries (defaults to --seconds)")
+	createCmd.Flags().IntVar(&config.Backoff.MaxFailures, "max-failures", 0, "exit after this many loops in a row have failed (0 retries forever)")
+	createCmd.Flags().DurationVar(&reconcileTimeout, "reconcile-timeout", 0, "abandon a loop that takes longer than this (no limit when 0)")
+	createCmd.Flags().DurationVar(&shutdownGrace, "shutdown-grace", 30*time.Second, "how long a loop in flight on SIGTERM or SIGINT gets to finish")
+	leaderFlags(createCmd.Flags())
+	createCmd.Flags().StringVar(&pubsubSubscription, "pubsub-subscription", "", "Pub/Sub subscription of instance audit log or asset feed events that trigger an immediate loop")
+	createCmd.Flags().IntVar(&config.HealthGate.UnhealthyCycles, "unhealthy-cycles", 0, "remove members reported UNHEALTHY for this many consecutive loops (0 disables)")
+	createCmd.Flags().IntVar(&config.HealthGate.RecheckCycles, "unhealthy-recheck-cycles", 5, "loops an unhealthy member is kept out before it is re-admitted on probation")
+	createCmd.Flags().IntVar(&config.HealthGate.MinMembers, "min-healthy-members", 1, "never remove unhealthy members below this many members")
+	createCmd.Flags().IntVar(&config.Safety.MinMembers, "min-members", 0, "never remove members below this many members")
+	createCmd.Flags().StringVar(&config.Safety.MaxRemove, "max-remove", "", "most members removed per loop, a number or a percentage such as 25% (rounded up)")
+	createCmd.Flags().BoolVar(&config.Safety.AllowEmpty, "allow-empty", false, "allow a loop to remove every member of the target pool")
+	createCmd.Flags().BoolVar(&config.Safety.Force, "force", false, "ignore --min-members, --max-remove and --allow-empty for an intentional large change")
+	createCmd.Flags().IntVar(&config.Hysteresis.AddAfter, "add-after", 1, "consecutive loops an instance must be selected before it is added")
+	createCmd.Flags().IntVar(&config.Hysteresis.RemoveAfter, "remove-after", 1, "consecutive loops a member must be missing before it is removed")
+	createCmd.Flags().DurationVar(&config.Hysteresis.AddDelay, "add-delay", 0, "how long an instance must be selected before it is added")
+	createCmd.Flags().DurationVar(&config.Hysteresis.RemoveDelay, "remove-delay", 0, "how long a member must be missing before it is removed")
+	createCmd.Flags().StringVar(&config.Drain.Label, "drain-label", "lb-drain:true", "label that drains an instance out of the target pool even though it matches --labels")
+	createCmd.Flags().DurationVar(&config.Drain.Grace, "drain-grace", 0, "how long a leaving member stays in the target pool before it is removed")
+	createCmd.Flags().BoolVar(&config.Drain.FailHealthCheck, "drain-fail-health-check", false, "set the gcp-lb-tags-drain metadata key on draining members so their health check can start failing (needs --health-check-port)")
+	createCmd.Flags().DurationVar(&config.Cleanup.After, "cleanup-after", 0, "remove the load balancer once --labels have matched no instance for this long, and recreate it when they match again (disabled when 0)")
+	createCmd.Flags().StringSlice("cleanup-keep", []string{"address"}, "resources --cleanup-after keeps: forwarding-rule, target-pool, health-check, firewall, address")
+	createCmd.Flags().StringVar(&config.Probe.Mode, "probe", "", "only admit instances passing a tcp, tls, http or https probe of their internal IP (disabled when empty)")
+	createCmd.Flags().StringVar(&config.Probe.Port, "probe-port", "", "port to probe (defaults to --port)")
+	createCmd.Flags().StringVar(&config.Probe.Path, "probe-path", "/", "path requested by http and https probes")
+	createCmd.Flags().DurationVar(&config.Probe.Timeout, "probe-timeout", 2*time.Second, "timeout of each probe")
+	createCmd.Flags().DurationVar(&config.Probe.Interval, "probe-interval", 10*time.Second, "how often instances are probed")
+	createCmd.Flags().IntVar(&config.Probe.HealthyThreshold, "probe-healthy-threshold", 2, "consecutive passing probes before an instance is admitted")
+	createCmd.Flags().IntVar(&config.Probe.UnhealthyThreshold, "probe-unhealthy-threshold", 3, "consecutive failing probes before an instance is removed")
 }
@@ -15,7 +15,9 @@
 package cmd
 
 import (
+	"github.com/paulczar/gcp-lb-tags/pkg"
 	"github.com/paulczar/gcp-lb-tags/pkg/cloud"
+	"github.com/paulczar/gcp-lb-tags/pkg/state"
 	"github.com/spf13/cobra"
 )
 
@@ -32,7 +34,7 @@ gcp-lb-tags accepts a list of tags and will monitor a named load balancer's targ
 pool and will modify that target pool to match the list of compute instances that
 match that those tags.`,
 	PreRunE: func(cmd *cobra.Command, args []string) error {
-		return nil
+		return util.CheckRequiredFlags(cmd, requiredFlags...)
 	},
 	RunE: func(cmd *cobra.Command, args []string) error {
 		//		config.Tags = util.GetFlagStringSlice(cmd, "tags")
@@ -40,11 +42,16 @@ match that those tags.`,
 		if config.Address == "" {
 			config.Address = config.Name
 		}
-		client, err := cloud.New(config.ProjectID, config.Network, config.Region)
+		st, err := state.Open(stateFile)
 		if err != nil {
 			return err
 		}
-		return client.RemoveLoadBalancer(config, force)
+		config.State = st
+		client, err := cloud.New(ctx, config.ProjectID, config.Network, config.Region, operationTimeout)
+		if err != nil {
+			return err
+		}
+		return client.RemoveLoadBalancer(ctx, config, force)
 	},
 }
 
@@ -0,0 +1,83 @@
+// Copyright © 2018 NAME HERE <EMAIL ADDRESS>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"fmt"
+
+	"github.com/paulczar/gcp-lb-tags/pkg"
+	"github.com/paulczar/gcp-lb-tags/pkg/cloud"
+	"github.com/spf13/cobra"
+)
+
+var doctorOutput string
+
+// doctorCmd represents the doctor command
+var doctorCmd = &cobra.Command{
+	Use:   "doctor <name>",
+	Short: "diagnoses why a GCP load balancer isn't serving",
+	Long: `
+doctor walks a load balancer the way you would by hand: forwarding rule, target
+pool, instances, network tags, firewall source ranges and ports, then health. Each
+broken link is reported with a hint on how to fix it. It exits non-zero when any
+error is found.`,
+	Args: cobra.ExactArgs(1),
+	PreRunE: func(cmd *cobra.Command, args []string) error {
+		if err := checkOutputFormat(doctorOutput); err != nil {
+			return err
+		}
+		return util.CheckRequiredFlags(cmd, "project")
+	},
+	RunE: func(cmd *cobra.Command, args []string) error {
+		config.Name = args[0]
+		config.Labels = util.GetFlagStringSlice(cmd, "labels")
+		client, err := cloud.New(ctx, config.ProjectID, config.Network, config.Region, operationTimeout)
+		if err != nil {
+			return err
+		}
+		findings, err := client.Diagnose(ctx, config)
+		if err != nil {
+			return err
+		}
+		errors := 0
+		for _, f := range findings {
+			if f.Severity == cloud.SeverityError {
+				errors++
+			}
+		}
+		if doctorOutput == "json" {
+			if err = printJSON(findings); err != nil {
+				return err
+			}
+		} else if len(findings) == 0 {
+			fmt.Printf("No problems found with %s\n", config.Name)
+		} else {
+			for _, f := range findings {
+				fmt.Printf("[%s] %s: %s\n", f.Severity, f.Resource, f.Problem)
+				fmt.Printf("    hint: %s\n", f.Hint)
+			}
+		}
+		if errors > 0 {
+			cmd.SilenceUsage = true
+			return fmt.Errorf("found %d problem(s) with %s", errors, config.Name)
+		}
+		return nil
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(doctorCmd)
+	doctorCmd.Flags().StringVarP(&doctorOutput, "output", "o", "table", "output format, table or json")
+}
@@ -0,0 +1,137 @@
+// Copyright © 2018 NAME HERE <EMAIL ADDRESS>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+
+	"github.com/paulczar/gcp-lb-tags/pkg"
+	"github.com/paulczar/gcp-lb-tags/pkg/cloud"
+	"github.com/paulczar/gcp-lb-tags/pkg/cloud/gce"
+	"github.com/paulczar/gcp-lb-tags/pkg/spec"
+	"github.com/spf13/cobra"
+	yaml "gopkg.in/yaml.v2"
+)
+
+var (
+	importForwardingRule string
+	importOutput         string
+)
+
+// importCmd represents the import command
+var importCmd = &cobra.Command{
+	Use:   "import",
+	Short: "takes over an existing GCP load balancer",
+	Long: `
+import inspects a hand-built forwarding rule, its target pool, external address
+and firewall rule, works out the equivalent gcp-lb-tags config and stamps the
+resources with an ownership marker so that later create runs reconcile them.
+The config is written as a config file apply reads, to stdout unless --output
+is given; progress goes to stderr then.
+
+The forwarding rule is found by --name or by its --forwarding-rule self link.
+Labels are inferred from the target pool members unless --labels is given.`,
+	PreRunE: func(cmd *cobra.Command, args []string) error {
+		if importForwardingRule == "" {
+			return util.CheckRequiredFlags(cmd, "name", "project")
+		}
+		return util.CheckRequiredFlags(cmd, "project")
+	},
+	RunE: func(cmd *cobra.Command, args []string) error {
+		stdout := os.Stdout
+		toStdout := importOutput == "" || importOutput == "-"
+		if toStdout {
+			// only the config goes to stdout, so it can be redirected to
+			// a file apply reads; progress and hints go to stderr
+			os.Stdout = os.Stderr
+			defer func() { os.Stdout = stdout }()
+		}
+		config.Tags = util.GetFlagStringSlice(cmd, "tags")
+		config.Labels = util.GetFlagStringSlice(cmd, "labels")
+		if importForwardingRule != "" {
+			config.Region, _ = gce.ParseSelfLink(importForwardingRule)
+		}
+		client, err := cloud.New(ctx, config.ProjectID, config.Network, config.Region, operationTimeout)
+		if err != nil {
+			return err
+		}
+		imported, err := client.ImportLoadBalancer(ctx, config, importForwardingRule)
+		if err != nil {
+			return err
+		}
+		out, err := yaml.Marshal(&spec.File{
+			APIVersion:    spec.APIVersion,
+			LoadBalancers: []*spec.LoadBalancer{spec.FromConfig(imported)},
+		})
+		if err != nil {
+			return err
+		}
+		if toStdout {
+			if _, err = stdout.Write(out); err != nil {
+				return err
+			}
+		} else {
+			if err = ioutil.WriteFile(importOutput, out, 0644); err != nil {
+				return err
+			}
+			fmt.Printf("Wrote config to %s\n", importOutput)
+		}
+		if len(imported.Ports) > 0 || len(imported.SourceRanges) > 0 {
+			// create has no flags for the firewall's ports and source ranges
+			file := importOutput
+			if toStdout {
+				file = "<file>"
+			}
+			fmt.Printf("Reconcile it with:\n  gcp-lb-tags apply --loop -f %s\n", file)
+			return nil
+		}
+		fmt.Printf("Reconcile it with:\n  gcp-lb-tags create --loop %s\n", strings.Join(createArgs(imported), " "))
+		return nil
+	},
+}
+
+// createArgs renders the create flags equivalent to cfg.
+func createArgs(cfg *cloud.Config) []string {
+	args := []string{
+		"--name=" + cfg.Name,
+		"--project=" + cfg.ProjectID,
+		"--region=" + cfg.Region,
+		"--network=" + cfg.Network,
+		"--port=" + cfg.Port,
+		"--address=" + cfg.Address,
+	}
+	for _, l := range cfg.Labels {
+		args = append(args, "--labels="+l)
+	}
+	if len(cfg.Tags) > 0 {
+		args = append(args, "--tags="+strings.Join(cfg.Tags, ","))
+	}
+	if cfg.Mode != cloud.ModeTCP {
+		args = append(args, "--mode="+cfg.Mode)
+	}
+	if cfg.HealthCheckPort != "" {
+		args = append(args, "--health-check-port="+cfg.HealthCheckPort, "--health-check-path="+cfg.HealthCheckPath)
+	}
+	return args
+}
+
+func init() {
+	rootCmd.AddCommand(importCmd)
+	importCmd.Flags().StringVar(&importForwardingRule, "forwarding-rule", "", "self link of the forwarding rule to import (defaults to the one named --name)")
+	importCmd.Flags().StringVarP(&importOutput, "output", "o", "", "file to write the imported config to (default stdout, with progress on stderr)")
+}
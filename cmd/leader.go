@@ -0,0 +1,138 @@
+// Copyright © 2018 NAME HERE <EMAIL ADDRESS>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/paulczar/gcp-lb-tags/pkg/cloud/gce"
+	"github.com/paulczar/gcp-lb-tags/pkg/leader"
+	"github.com/spf13/pflag"
+)
+
+// Backends of --leader-elect.
+const (
+	electKubernetes = "kubernetes"
+	electGCE        = "gce"
+)
+
+var (
+	leaderElect     string
+	leaderLock      string
+	leaderNamespace string
+	leaderIdentity  string
+	leaderLease     time.Duration
+	leaderRenew     time.Duration
+)
+
+// leaderFlags adds the leader election flags to fs.
+func leaderFlags(fs *pflag.FlagSet) {
+	fs.StringVar(&leaderElect, "leader-elect", "", "only reconcile while holding a leader lease kept in a kubernetes Lease or the labels of a gce address, a billed static IP, so several replicas can run (disabled when empty)")
+	fs.StringVar(&leaderLock, "leader-lock", "gcp-lb-tags-leader", "name of the Lease or the address holding the leader lease")
+	fs.StringVar(&leaderNamespace, "leader-namespace", "", "namespace of the Lease (defaults to the pod's)")
+	fs.StringVar(&leaderIdentity, "leader-identity", "", "identity of this replica in the leader lease (defaults to the host name)")
+	fs.DurationVar(&leaderLease, "leader-lease", 60*time.Second, "how long the leader lease lasts without being renewed, longer than --leader-renew and --shutdown-grace together")
+	fs.DurationVar(&leaderRenew, "leader-renew", 10*time.Second, "how often the leader renews its lease and the other replicas try to take it")
+}
+
+// checkLeaderFlags checks the leader election flags. When leading, a leader
+// that fails to renew its lease must have time to stop its reconcile in
+// flight before the lease runs out.
+func checkLeaderFlags(leading bool) error {
+	switch leaderElect {
+	case "":
+		return nil
+	case electKubernetes, electGCE:
+	default:
+		return fmt.Errorf("--leader-elect must be %s or %s", electKubernetes, electGCE)
+	}
+	if leaderRenew <= 0 {
+		return fmt.Errorf("--leader-renew must be positive")
+	}
+	if leading && leaderLease <= leaderRenew+shutdownGrace {
+		return fmt.Errorf("--leader-lease must be longer than --leader-renew and --shutdown-grace together")
+	}
+	return nil
+}
+
+// newLeaderLock returns the lock of --leader-elect. A gce lock is an address
+// in project and region.
+func newLeaderLock(project, region string) (leader.Lock, error) {
+	if leaderElect == electKubernetes {
+		return leader.NewKubernetesLock(leaderNamespace, leaderLock)
+	}
+	if project == "" {
+		return nil, fmt.Errorf("--leader-elect %s needs --project", electGCE)
+	}
+	client, err := gce.CreateGCECloud(ctx, project, "", operationTimeout)
+	if err != nil {
+		return nil, err
+	}
+	return &leader.GCELock{Client: client, Region: region, Name: leaderLock}, nil
+}
+
+// lead runs run until the process is told to stop. With --leader-elect run
+// only runs while this replica holds the leader lease, kept in project and
+// region for a gce lock; its context is done when the lease is lost and run
+// is called again once the lease is won back.
+func lead(project, region string, run func(stop context.Context) error) error {
+	if leaderElect == "" {
+		return run(ctx)
+	}
+	lock, err := newLeaderLock(project, region)
+	if err != nil {
+		return err
+	}
+	id := leaderIdentity
+	if id == "" {
+		if id, err = os.Hostname(); err != nil {
+			return err
+		}
+	}
+	e := &leader.Elector{
+		Lock:     lock,
+		Identity: leader.Identity(id),
+		Lease:    leaderLease,
+		Renew:    leaderRenew,
+		Grace:    shutdownGrace,
+	}
+	fmt.Printf("====> Competing for the leader lease on %s as %s\n", lock, e.Identity)
+	return e.Run(ctx, run)
+}
+
+// leaderStatus is the holder of the leader lease as status reports it.
+type leaderStatus struct {
+	Holder  string    `json:"holder"`
+	Since   time.Time `json:"since"`
+	Renewed time.Time `json:"renewed"`
+	Expires time.Time `json:"expires"`
+}
+
+// currentLeader returns the holder of the leader lease, nil when there is
+// none.
+func currentLeader(project, region string) (*leaderStatus, error) {
+	lock, err := newLeaderLock(project, region)
+	if err != nil {
+		return nil, err
+	}
+	r, err := leader.Current(ctx, lock)
+	if err != nil || r == nil {
+		return nil, err
+	}
+	return &leaderStatus{Holder: r.Holder, Since: r.Acquired, Renewed: r.Renewed, Expires: r.Renewed.Add(r.Lease)}, nil
+}
@@ -0,0 +1,64 @@
+// Copyright © 2018 NAME HERE <EMAIL ADDRESS>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"fmt"
+
+	"github.com/paulczar/gcp-lb-tags/pkg"
+	"github.com/paulczar/gcp-lb-tags/pkg/cloud"
+	"github.com/spf13/cobra"
+)
+
+var listOutput string
+
+// listCmd represents the list command
+var listCmd = &cobra.Command{
+	Use:   "list",
+	Short: "lists the GCP load balancers managed by gcp-lb-tags",
+	Long: `
+list finds every forwarding rule, target pool, external address and firewall rule
+in the project and region that carries a gcp-lb-tags ownership marker.`,
+	PreRunE: func(cmd *cobra.Command, args []string) error {
+		if err := checkOutputFormat(listOutput); err != nil {
+			return err
+		}
+		return util.CheckRequiredFlags(cmd, "project")
+	},
+	RunE: func(cmd *cobra.Command, args []string) error {
+		client, err := cloud.New(ctx, config.ProjectID, config.Network, config.Region, operationTimeout)
+		if err != nil {
+			return err
+		}
+		managed, err := client.ListLoadBalancers(ctx, config)
+		if err != nil {
+			return err
+		}
+		if listOutput == "json" {
+			return printJSON(managed)
+		}
+		w := newTable()
+		fmt.Fprintln(w, "LB\tKIND\tNAME\tHASH")
+		for _, r := range managed {
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", r.LB, r.Kind, r.Name, r.Hash)
+		}
+		return w.Flush()
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(listCmd)
+	listCmd.Flags().StringVarP(&listOutput, "output", "o", "table", "output format, table or json")
+}
@@ -0,0 +1,87 @@
+// Copyright © 2018 NAME HERE <EMAIL ADDRESS>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"fmt"
+
+	"github.com/paulczar/gcp-lb-tags/pkg/metadata"
+	"github.com/spf13/cobra"
+)
+
+var (
+	fromMetadata bool
+	// instance is the instance read from the metadata server with --from-metadata
+	instance *metadata.Instance
+)
+
+// sourceMetadata is the source of a setting filled in by --from-metadata,
+// it comes after the config file and before the default.
+const sourceMetadata = "metadata"
+
+// useMetadata reads the instance from the metadata server, fills in the
+// project, network and region flags not set otherwise and renders the
+// templates of --name and --address. The templates of --tags and --labels are
+// rendered by configFromFlags.
+func useMetadata(cmd *cobra.Command) error {
+	var err error
+	if instance, err = metadata.Load(); err != nil {
+		return err
+	}
+	fill := []struct{ flag, value string }{
+		{"project", instance.Project},
+		{"network", instance.Network},
+		{"region", instance.Region},
+	}
+	for _, f := range fill {
+		flag := cmd.Flags().Lookup(f.flag)
+		if flag == nil || flag.Changed {
+			continue
+		}
+		if err := cmd.Flags().Set(f.flag, f.value); err != nil {
+			return err
+		}
+		settingSources[flag] = sourceMetadata
+	}
+	for _, name := range []string{"name", "address"} {
+		flag := cmd.Flags().Lookup(name)
+		if flag == nil {
+			continue
+		}
+		v, err := instance.Render(flag.Value.String())
+		if err != nil {
+			return fmt.Errorf("invalid --%s: %s", name, err)
+		}
+		if v != flag.Value.String() {
+			if err := cmd.Flags().Set(name, v); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// renderFlags renders the metadata templates of a list flag's values, they
+// are returned as is without --from-metadata.
+func renderFlags(name string, values []string) ([]string, error) {
+	if instance == nil {
+		return values, nil
+	}
+	out, err := instance.RenderAll(values)
+	if err != nil {
+		return nil, fmt.Errorf("invalid --%s: %s", name, err)
+	}
+	return out, nil
+}
@@ -0,0 +1,83 @@
+// Copyright © 2018 NAME HERE <EMAIL ADDRESS>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"fmt"
+
+	"github.com/paulczar/gcp-lb-tags/pkg"
+	"github.com/paulczar/gcp-lb-tags/pkg/cloud"
+	"github.com/paulczar/gcp-lb-tags/pkg/state"
+	"github.com/spf13/cobra"
+)
+
+var planOutput string
+
+// planCmd represents the plan command
+var planCmd = &cobra.Command{
+	Use:   "plan",
+	Short: "shows the target pool changes the next create would make",
+	Long: `
+plan selects instances the way create does and shows, for each of them, whether
+the next reconcile would add, remove or keep it and why, without changing
+anything. Instances labelled gcp-lb-tags-exclude or gcp-lb-tags-pin are shown
+with the override that applies.`,
+	PreRunE: func(cmd *cobra.Command, args []string) error {
+		if err := checkOutputFormat(planOutput); err != nil {
+			return err
+		}
+		if err := util.CheckRequiredFlags(cmd, "name", "project", "labels"); err != nil {
+			return err
+		}
+		st, err := state.Open(stateFile)
+		if err != nil {
+			return err
+		}
+		config.State = st
+		return nil
+	},
+	RunE: func(cmd *cobra.Command, args []string) error {
+		cfg, err := configFromFlags(cmd)
+		if err != nil {
+			return err
+		}
+		if err = cfg.Validate(); err != nil {
+			return err
+		}
+		client, err := cloud.New(ctx, config.ProjectID, config.Network, config.Region, operationTimeout)
+		if err != nil {
+			return err
+		}
+		p, err := client.PlanLoadBalancer(ctx, config)
+		if err != nil {
+			return err
+		}
+		if planOutput == "json" {
+			return printJSON(p)
+		}
+		w := newTable()
+		fmt.Fprintln(w, "INSTANCE\tZONE\tACTION\tNOTE")
+		for _, e := range p.Instances {
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", e.Name, e.Zone, e.Action, e.Note)
+		}
+		return w.Flush()
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(planCmd)
+	planCmd.Flags().StringVarP(&planOutput, "output", "o", "table", "output format, table or json")
+	planCmd.Flags().StringVar(&config.Drain.Label, "drain-label", "lb-drain:true", "label that drains an instance out of the target pool even though it matches --labels")
+}
@@ -0,0 +1,112 @@
+// Copyright © 2018 NAME HERE <EMAIL ADDRESS>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"syscall"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+// reloadDebounce coalesces the burst of events an editor or a ConfigMap
+// update makes into a single reload.
+const reloadDebounce = time.Second
+
+// configMapData is the symlink kubelet swaps to update a mounted ConfigMap
+// atomically, the files themselves are never written.
+const configMapData = "..data"
+
+// watchConfig returns a channel receiving the reason of a reload whenever
+// the config file at path changes (when watch is set), a file in dir changes
+// (when dir is set) or the process receives SIGHUP, until stop is done. The directory of path is
+// watched rather than the file so editors replacing the file and ConfigMap
+// updates are both seen.
+func watchConfig(stop context.Context, path string, watch bool, dir string) (<-chan string, error) {
+	w, err := fsnotify.NewWatcher()
+	if err != nil {
+		return nil, err
+	}
+	fileDir, fileName := filepath.Dir(path), filepath.Base(path)
+	if watch {
+		if err := w.Add(fileDir); err != nil {
+			w.Close()
+			return nil, fmt.Errorf("watching %s: %s", fileDir, err)
+		}
+	}
+	if dir != "" {
+		dir = filepath.Clean(dir)
+		if err := w.Add(dir); err != nil {
+			w.Close()
+			return nil, fmt.Errorf("watching %s: %s", dir, err)
+		}
+	}
+	relevant := func(e fsnotify.Event) bool {
+		if e.Op == fsnotify.Chmod {
+			return false
+		}
+		d, name := filepath.Dir(e.Name), filepath.Base(e.Name)
+		if dir != "" && d == dir {
+			return true
+		}
+		return watch && d == fileDir && (name == fileName || name == configMapData)
+	}
+
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	reloads := make(chan string, 1)
+	send := func(reason string) {
+		select {
+		case reloads <- reason:
+		default:
+		}
+	}
+	go func() {
+		defer w.Close()
+		defer signal.Stop(hup)
+		var pending <-chan time.Time
+		reason := ""
+		for {
+			select {
+			case <-stop.Done():
+				return
+			case <-hup:
+				send("SIGHUP")
+			case e, ok := <-w.Events:
+				if !ok {
+					return
+				}
+				if relevant(e) {
+					reason = e.Name + " changed"
+					pending = time.After(reloadDebounce)
+				}
+			case err, ok := <-w.Errors:
+				if !ok {
+					return
+				}
+				fmt.Printf("====> Error watching the config: %s\n", err)
+			case <-pending:
+				pending = nil
+				send(reason)
+			}
+		}
+	}()
+	return reloads, nil
+}
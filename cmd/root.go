@@ -15,8 +15,13 @@
 package cmd
 
 import (
+	"context"
 	"fmt"
 	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
 
 	homedir "github.com/mitchellh/go-homedir"
 	"github.com/paulczar/gcp-lb-tags/pkg/cloud"
@@ -25,7 +30,13 @@ import (
 )
 
 var (
-	cfgFile       string
+	cfgFile     string
+	stateFile   string
+	metricsAddr string
+	// operationTimeout bounds the wait for each GCE operation
+	operationTimeout time.Duration
+	// ctx is cancelled on SIGTERM or SIGINT so commands can stop cleanly
+	ctx           = context.Background()
 	requiredFlags = []string{"name", "project", "network", "labels"}
 	config        = &cloud.Config{}
 )
@@ -41,11 +52,27 @@ var rootCmd = &cobra.Command{
 	// Uncomment the following line if your bare application
 	// has an action associated with it:
 	//	Run: func(cmd *cobra.Command, args []string) { },
+	PersistentPreRunE: func(cmd *cobra.Command, args []string) error {
+		if err := bindFlags(cmd); err != nil {
+			return err
+		}
+		if fromMetadata {
+			return useMetadata(cmd)
+		}
+		return nil
+	},
 }
 
 // Execute adds all child commands to the root command and sets flags appropriately.
 // This is called by main.main(). It only needs to happen once to the rootCmd.
 func Execute() {
+	var stop context.CancelFunc
+	ctx, stop = signal.NotifyContext(context.Background(), syscall.SIGTERM, syscall.SIGINT)
+	go func() {
+		// a second signal stops the process straight away
+		<-ctx.Done()
+		stop()
+	}()
 	if err := rootCmd.Execute(); err != nil {
 		fmt.Println(err)
 		os.Exit(1)
@@ -58,7 +85,11 @@ func init() {
 	// Cobra supports persistent flags, which, if defined here,
 	// will be global for your application.
 	rootCmd.PersistentFlags().StringVar(&cfgFile, "config", "", "config file (default is $HOME/.gcp-lb-tags.yaml)")
+	rootCmd.PersistentFlags().StringVar(&metricsAddr, "metrics-addr", "", "address to serve Prometheus metrics on at /metrics, e.g. :9090 (disabled when empty)")
+	rootCmd.PersistentFlags().DurationVar(&operationTimeout, "operation-timeout", 30*time.Minute, "how long to wait for each GCE operation to finish")
+	rootCmd.PersistentFlags().StringVar(&stateFile, "state-file", "", "local file to keep state in between restarts (kept in memory when empty)")
 
+	rootCmd.PersistentFlags().BoolVar(&fromMetadata, "from-metadata", false, "fill in --project, --network and --region from the metadata server of the instance running gcp-lb-tags, and render templates of its tags and attributes in --name, --address, --tags and --labels")
 	rootCmd.PersistentFlags().StringVarP(&config.Region, "region", "r", "us-central1", "GCP region")
 	rootCmd.PersistentFlags().StringVarP(&config.ProjectID, "project", "p", "", "Project ID")
 	rootCmd.PersistentFlags().StringVarP(&config.Name, "name", "n", "", "Name of loadbalancer")
@@ -67,12 +98,11 @@ func init() {
 	rootCmd.PersistentFlags().StringSliceP("labels", "l", []string{}, "Labels to Load Balance for")
 	rootCmd.PersistentFlags().StringVar(&config.Address, "address", "", "Name of the external IP address to attach to LB if different to LB name")
 	rootCmd.PersistentFlags().StringVar(&config.Port, "port", "8443", "Port to load balance for")
-	config.Ports = []string{config.Port}
+	rootCmd.PersistentFlags().StringVar(&config.Mode, "mode", "tcp", "Protocol to load balance, tcp or udp")
 	rootCmd.PersistentFlags().StringSliceP("zones", "z", []string{"a", "b", "c"}, "zones your compute instances are in (will be appended to value of --region")
-	for _, f := range requiredFlags {
-		rootCmd.MarkPersistentFlagRequired(f)
-	}
-
+	rootCmd.PersistentFlags().StringVar(&config.HealthCheckPort, "health-check-port", "", "Port of an HTTP health check for the target pool (disabled when empty)")
+	rootCmd.PersistentFlags().StringVar(&config.HealthCheckPath, "health-check-path", "/", "Request path of the HTTP health check")
+	rootCmd.PersistentFlags().BoolVar(&config.Adopt, "adopt", false, "Take over and modify existing resources that are not marked as managed by gcp-lb-tags")
 }
 
 // initConfig reads in config file and ENV variables if set.
@@ -93,7 +123,11 @@ func initConfig() {
 		viper.SetConfigName(".gcp-lb-tags")
 	}
 
-	viper.AutomaticEnv() // read in environment variables that match
+	// read in environment variables that match, GCPLBTAGS_HEALTH_CHECK_PORT
+	// for --health-check-port
+	viper.SetEnvPrefix(envPrefix)
+	viper.SetEnvKeyReplacer(strings.NewReplacer("-", "_"))
+	viper.AutomaticEnv()
 
 	// If a config file is found, read it in.
 	if err := viper.ReadInConfig(); err == nil {
@@ -0,0 +1,127 @@
+// Copyright © 2018 NAME HERE <EMAIL ADDRESS>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/paulczar/gcp-lb-tags/pkg"
+	"github.com/paulczar/gcp-lb-tags/pkg/leader"
+	"github.com/paulczar/gcp-lb-tags/pkg/shard"
+	"github.com/spf13/cobra"
+)
+
+// Backends of --shard-peers.
+const (
+	peersKubernetes = "kubernetes"
+	peersStatic     = "static"
+)
+
+var (
+	shardPeers     string
+	shardMembers   []string
+	shardGroup     string
+	shardNamespace string
+	shardIdentity  string
+	shardLease     time.Duration
+	shardRenew     time.Duration
+)
+
+// shardFlags adds the sharding flags to cmd.
+func shardFlags(cmd *cobra.Command) {
+	cmd.Flags().StringVar(&shardPeers, "shard-peers", "", "share the load balancers with the other replicas found through a Lease per replica (kubernetes) or --shard-members (static), each reconciling its own consistent-hash share (disabled when empty, with --loop)")
+	cmd.Flags().StringSlice("shard-members", []string{}, "identities of every replica with --shard-peers static")
+	cmd.Flags().StringVar(&shardGroup, "shard-group", "gcp-lb-tags", "name the replicas sharing the load balancers have in common, prefixing the name of their Leases")
+	cmd.Flags().StringVar(&shardNamespace, "shard-namespace", "", "namespace of the Leases (defaults to the pod's)")
+	cmd.Flags().StringVar(&shardIdentity, "shard-identity", "", "identity of this replica (defaults to the host name)")
+	cmd.Flags().DurationVar(&shardLease, "shard-lease", 60*time.Second, "how long the membership of a replica lasts without being renewed, longer than --shard-renew and --shutdown-grace together")
+	cmd.Flags().DurationVar(&shardRenew, "shard-renew", 10*time.Second, "how often a replica renews its membership and looks for replicas that came or went")
+}
+
+// checkShardFlags checks the sharding flags and reads --shard-members. A
+// replica that fails to renew its membership must have time to stop its loops
+// before it runs out.
+func checkShardFlags(cmd *cobra.Command) error {
+	switch shardPeers {
+	case "":
+		return nil
+	case peersKubernetes, peersStatic:
+	default:
+		return fmt.Errorf("--shard-peers must be %s or %s", peersKubernetes, peersStatic)
+	}
+	if !loop {
+		return fmt.Errorf("--shard-peers needs --loop")
+	}
+	if leaderElect != "" {
+		return fmt.Errorf("--shard-peers and --leader-elect can't be used together")
+	}
+	if shardRenew <= 0 {
+		return fmt.Errorf("--shard-renew must be positive")
+	}
+	if shardLease <= shardRenew+shutdownGrace {
+		return fmt.Errorf("--shard-lease must be longer than --shard-renew and --shutdown-grace together")
+	}
+	shardMembers = util.GetFlagStringSlice(cmd, "shard-members")
+	if shardPeers == peersStatic && len(shardMembers) == 0 {
+		return fmt.Errorf("--shard-peers %s needs --shard-members", peersStatic)
+	}
+	return nil
+}
+
+// newSharder joins the replicas of --shard-peers until stop is done.
+func newSharder(stop context.Context) (*shard.Sharder, error) {
+	id := shardIdentity
+	if id == "" {
+		var err error
+		if id, err = os.Hostname(); err != nil {
+			return nil, err
+		}
+	}
+	id = leader.Identity(id)
+	var peers shard.Peers
+	if shardPeers == peersStatic {
+		static := &shard.StaticPeers{}
+		found := false
+		for _, m := range shardMembers {
+			m = leader.Identity(m)
+			static.Members = append(static.Members, m)
+			found = found || m == id
+		}
+		if !found {
+			return nil, fmt.Errorf("--shard-members must include this replica, %s", id)
+		}
+		peers = static
+	} else {
+		var err error
+		if peers, err = shard.NewKubernetesPeers(shardNamespace, shardGroup, id); err != nil {
+			return nil, err
+		}
+	}
+	s := &shard.Sharder{
+		Peers:    peers,
+		Identity: id,
+		Lease:    shardLease,
+		Renew:    shardRenew,
+		Grace:    shutdownGrace,
+	}
+	fmt.Printf("====> Sharing the load balancers on %s as %s\n", peers, id)
+	if err := s.Start(stop); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
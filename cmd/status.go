@@ -0,0 +1,104 @@
+// Copyright © 2018 NAME HERE <EMAIL ADDRESS>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/paulczar/gcp-lb-tags/pkg"
+	"github.com/paulczar/gcp-lb-tags/pkg/cloud"
+	"github.com/spf13/cobra"
+)
+
+var statusOutput string
+
+// statusCmd represents the status command
+var statusCmd = &cobra.Command{
+	Use:   "status <name>",
+	Short: "shows the state of a managed GCP load balancer",
+	Long: `
+status shows the VIP, ports and protocol of a load balancer, the members of its
+target pool with their zone and health, and any instances matching --labels that
+are missing from the target pool or kept out by the gcp-lb-tags-exclude label.
+With --leader-elect it also shows which replica holds the leader lease.`,
+	Args: cobra.ExactArgs(1),
+	PreRunE: func(cmd *cobra.Command, args []string) error {
+		if err := checkOutputFormat(statusOutput); err != nil {
+			return err
+		}
+		if err := checkLeaderFlags(false); err != nil {
+			return err
+		}
+		return util.CheckRequiredFlags(cmd, "project")
+	},
+	RunE: func(cmd *cobra.Command, args []string) error {
+		config.Name = args[0]
+		config.Labels = util.GetFlagStringSlice(cmd, "labels")
+		client, err := cloud.New(ctx, config.ProjectID, config.Network, config.Region, operationTimeout)
+		if err != nil {
+			return err
+		}
+		s, err := client.LoadBalancerStatus(ctx, config)
+		if err != nil {
+			return err
+		}
+		var l *leaderStatus
+		if leaderElect != "" {
+			if l, err = currentLeader(config.ProjectID, config.Region); err != nil {
+				return err
+			}
+		}
+		if statusOutput == "json" {
+			if leaderElect != "" {
+				return printJSON(struct {
+					*cloud.Status
+					Leader *leaderStatus `json:"leader"`
+				}{s, l})
+			}
+			return printJSON(s)
+		}
+		fmt.Printf("Name:      %s\n", s.Name)
+		fmt.Printf("VIP:       %s\n", s.VIP)
+		fmt.Printf("Ports:     %s\n", s.Ports)
+		fmt.Printf("Protocol:  %s\n", s.Protocol)
+		switch {
+		case leaderElect == "":
+		case l == nil:
+			fmt.Printf("Leader:    none\n")
+		default:
+			fmt.Printf("Leader:    %s, since %s, renewed %s ago\n", l.Holder, l.Since.Format(time.RFC3339), time.Since(l.Renewed).Round(time.Second))
+		}
+		fmt.Println()
+		w := newTable()
+		fmt.Fprintln(w, "INSTANCE\tZONE\tHEALTH\tNOTE")
+		for _, m := range s.Members {
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", m.Name, m.Zone, m.Health, m.Override)
+		}
+		for _, m := range s.Missing {
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", m.Name, m.Zone, "MISSING", m.Override)
+		}
+		for _, m := range s.Excluded {
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", m.Name, m.Zone, "EXCLUDED", m.Override)
+		}
+		return w.Flush()
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(statusCmd)
+	statusCmd.Flags().StringVarP(&statusOutput, "output", "o", "table", "output format, table or json")
+	leaderFlags(statusCmd.Flags())
+}
@@ -0,0 +1,86 @@
+// Copyright © 2018 NAME HERE <EMAIL ADDRESS>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/paulczar/gcp-lb-tags/pkg/cloud"
+	"github.com/paulczar/gcp-lb-tags/pkg/spec"
+	"github.com/paulczar/gcp-lb-tags/pkg/validate"
+	"github.com/spf13/cobra"
+)
+
+var (
+	validateFile   string
+	validateOutput string
+)
+
+// validateCmd represents the validate command
+var validateCmd = &cobra.Command{
+	Use:   "validate [-f <file>]",
+	Short: "checks load balancer definitions without calling GCP",
+	Long: `
+validate checks the load balancers declared in a config file, or the one given
+by flags when no file is given, and reports every problem found with the path of
+the field at fault: resource names, ports and port ranges, CIDRs, label syntax,
+zones outside the region and settings a mode requires. It exits non-zero when
+anything is wrong. create, plan and apply run the same checks before any API call.`,
+	PreRunE: func(cmd *cobra.Command, args []string) error {
+		return checkOutputFormat(validateOutput)
+	},
+	RunE: func(cmd *cobra.Command, args []string) error {
+		var err error
+		if validateFile != "" {
+			var f *spec.File
+			if f, err = spec.Load(validateFile); err != nil {
+				return err
+			}
+			err = f.Validate(config)
+		} else {
+			var cfg *cloud.Config
+			if cfg, err = configFromFlags(cmd); err != nil {
+				return err
+			}
+			err = cfg.Validate()
+		}
+
+		var errs validate.Errors
+		if err != nil && !errors.As(err, &errs) {
+			return err
+		}
+		if validateOutput == "json" {
+			if errs == nil {
+				errs = validate.Errors{}
+			}
+			if perr := printJSON(errs); perr != nil {
+				return perr
+			}
+		} else if err == nil {
+			fmt.Println("valid")
+		}
+		if err != nil {
+			cmd.SilenceUsage = true
+		}
+		return err
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(validateCmd)
+	validateCmd.Flags().StringVarP(&validateFile, "filename", "f", "", "config file declaring the load balancers (defaults to the one given by flags)")
+	validateCmd.Flags().StringVarP(&validateOutput, "output", "o", "table", "output format, table or json")
+}
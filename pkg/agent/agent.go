@@ -0,0 +1,150 @@
+// Package agent keeps the instance it runs on registered with a load
+// balancer while it is ready, and takes it out ahead of preemption, host
+// maintenance and shutdown.
+package agent
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	gcemd "cloud.google.com/go/compute/metadata"
+	"github.com/paulczar/gcp-lb-tags/pkg/cloud"
+	"github.com/paulczar/gcp-lb-tags/pkg/probe"
+)
+
+// Metadata keys the agent watches.
+const (
+	// PreemptedKey turns TRUE when a preemptible instance is being preempted.
+	PreemptedKey = "instance/preempted"
+	// MaintenanceKey is NONE, or the action about to be taken for a host
+	// maintenance event such as MIGRATE_ON_HOST_MAINTENANCE.
+	MaintenanceKey = "instance/maintenance-event"
+)
+
+// Members adds and removes one instance of a load balancer's target pool,
+// cloud.Cloud implements it.
+type Members interface {
+	AddMember(ctx context.Context, cfg *cloud.Config, zone, instance string) error
+	RemoveMember(ctx context.Context, cfg *cloud.Config, zone, instance string) error
+}
+
+// Agent registers Instance with the load balancer of Config.
+type Agent struct {
+	Members  Members
+	Config   *cloud.Config
+	Zone     string
+	Instance string
+	// Ready probes the local service, on ReadyAddr, the instance is
+	// registered once it passes.
+	Ready     probe.Config
+	ReadyAddr string
+	// Grace bounds the deregistration on shutdown.
+	Grace time.Duration
+}
+
+// watched is a change of a watched metadata key.
+type watched struct {
+	key, value string
+}
+
+// Run keeps the instance registered while it is ready and neither being
+// preempted nor going through host maintenance, until ctx is done. The
+// instance is then deregistered.
+func (a *Agent) Run(ctx context.Context) error {
+	prober := probe.New(a.Ready)
+	prober.SetTargets([]string{a.ReadyAddr}, nil)
+	prober.Start()
+	defer prober.Stop()
+
+	changes := make(chan watched)
+	for _, key := range []string{PreemptedKey, MaintenanceKey} {
+		go watch(ctx, key, changes)
+	}
+
+	// registered is nil until the agent has registered or deregistered
+	// the instance, it doesn't know its membership before that
+	var registered *bool
+	preempted, maintenance := false, ""
+	interval := a.Ready.Interval
+	if interval <= 0 {
+		interval = 10 * time.Second
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			gctx, cancel := context.WithTimeout(context.Background(), a.Grace)
+			defer cancel()
+			fmt.Printf("====> Stopping, deregistering %s from %s\n", a.Instance, a.Config.Name)
+			return a.Members.RemoveMember(gctx, a.Config, a.Zone, a.Instance)
+		case c := <-changes:
+			switch c.key {
+			case PreemptedKey:
+				preempted = c.value == "TRUE"
+			case MaintenanceKey:
+				maintenance = c.value
+				if maintenance == "NONE" {
+					maintenance = ""
+				}
+			}
+		case <-ticker.C:
+		}
+
+		ready, probeErr := prober.Healthy(a.ReadyAddr)
+		want, why := ready, "ready"
+		switch {
+		case preempted:
+			want, why = false, "being preempted"
+		case maintenance != "":
+			want, why = false, "host maintenance "+maintenance
+		case !ready:
+			why = "not ready"
+			if probeErr != nil {
+				why += ": " + probeErr.Error()
+			}
+		}
+		if registered != nil && *registered == want {
+			continue
+		}
+		var err error
+		if want {
+			fmt.Printf("====> Registering %s with %s, %s\n", a.Instance, a.Config.Name, why)
+			err = a.Members.AddMember(ctx, a.Config, a.Zone, a.Instance)
+		} else {
+			fmt.Printf("====> Deregistering %s from %s, %s\n", a.Instance, a.Config.Name, why)
+			err = a.Members.RemoveMember(ctx, a.Config, a.Zone, a.Instance)
+		}
+		if err != nil {
+			// tried again on the next tick
+			fmt.Printf("====> Failed: %s\n", err)
+			registered = nil
+			continue
+		}
+		registered = &want
+	}
+}
+
+// watch sends every value of the metadata key to changes until ctx is done,
+// starting with the current one.
+func watch(ctx context.Context, key string, changes chan<- watched) {
+	for ctx.Err() == nil {
+		err := gcemd.Subscribe(key, func(v string, ok bool) error {
+			select {
+			case changes <- watched{key, v}:
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+			return nil
+		})
+		if ctx.Err() != nil {
+			return
+		}
+		fmt.Printf("====> Watching %s failed, retrying: %s\n", key, err)
+		select {
+		case <-ctx.Done():
+		case <-time.After(5 * time.Second):
+		}
+	}
+}
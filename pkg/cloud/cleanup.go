@@ -0,0 +1,188 @@
+package cloud
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/paulczar/gcp-lb-tags/pkg/state"
+	"github.com/paulczar/gcp-lb-tags/pkg/validate"
+)
+
+// Cleanup removes a load balancer once its selector has matched no instance
+// for a while, such as after its cluster was deleted. Each resource is
+// released (deleted) or kept; by default everything but the external address
+// is released, so a recreated cluster gets the same IP.
+type Cleanup struct {
+	// After is how long the selector must match nothing, disabled when 0.
+	After time.Duration `yaml:"after,omitempty"`
+	// ForwardingRule, TargetPool, HealthCheck, Firewall and Address are each
+	// CleanupKeep or CleanupRelease.
+	ForwardingRule string `yaml:"forwardingRule,omitempty"`
+	TargetPool     string `yaml:"targetPool,omitempty"`
+	HealthCheck    string `yaml:"healthCheck,omitempty"`
+	Firewall       string `yaml:"firewall,omitempty"`
+	Address        string `yaml:"address,omitempty"`
+}
+
+// What Cleanup does with a resource.
+const (
+	CleanupKeep    = "keep"
+	CleanupRelease = "release"
+)
+
+// CleanupResources are the resources Cleanup decides about, as written in
+// --cleanup-keep.
+var CleanupResources = []string{"forwarding-rule", "target-pool", "health-check", "firewall", "address"}
+
+// Keep sets every resource named in keep to CleanupKeep and the others to
+// CleanupRelease.
+func (c *Cleanup) Keep(keep []string) error {
+	kept := map[string]bool{}
+	for _, k := range keep {
+		kept[k] = true
+	}
+	for _, r := range CleanupResources {
+		*c.field(r) = CleanupRelease
+		if kept[r] {
+			*c.field(r) = CleanupKeep
+			delete(kept, r)
+		}
+	}
+	for k := range kept {
+		return fmt.Errorf("unknown resource %q, use %s", k, strings.Join(CleanupResources, ", "))
+	}
+	return nil
+}
+
+func (c *Cleanup) field(resource string) *string {
+	switch resource {
+	case "forwarding-rule":
+		return &c.ForwardingRule
+	case "target-pool":
+		return &c.TargetPool
+	case "health-check":
+		return &c.HealthCheck
+	case "firewall":
+		return &c.Firewall
+	}
+	return &c.Address
+}
+
+// releases returns the resources Cleanup deletes.
+func (c Cleanup) releases() release {
+	keep := func(v, def string) bool {
+		if v == "" {
+			v = def
+		}
+		return v == CleanupKeep
+	}
+	return release{
+		forwardingRule: !keep(c.ForwardingRule, CleanupRelease),
+		targetPool:     !keep(c.TargetPool, CleanupRelease),
+		healthCheck:    !keep(c.HealthCheck, CleanupRelease),
+		firewall:       !keep(c.Firewall, CleanupRelease),
+		address:        !keep(c.Address, CleanupKeep),
+	}
+}
+
+func (c Cleanup) validateInto(errs *validate.Errors, path func(field string) string) {
+	if c.After < 0 {
+		errs.Add(path("cleanup.after"), "must not be negative")
+	}
+	fields := map[string]string{
+		"forwardingRule": c.ForwardingRule,
+		"targetPool":     c.TargetPool,
+		"healthCheck":    c.HealthCheck,
+		"firewall":       c.Firewall,
+		"address":        c.Address,
+	}
+	for _, f := range []string{"forwardingRule", "targetPool", "healthCheck", "firewall", "address"} {
+		if v := fields[f]; v != "" && v != CleanupKeep && v != CleanupRelease {
+			errs.Add(path("cleanup."+f), "%q must be %s or %s", v, CleanupKeep, CleanupRelease)
+		}
+	}
+	// a resource in use by a kept one can't be deleted
+	r := c.releases()
+	if !r.forwardingRule && r.targetPool {
+		errs.Add(path("cleanup.targetPool"), "must be kept with the forwarding rule using it")
+	}
+	if !r.forwardingRule && r.address {
+		errs.Add(path("cleanup.address"), "must be kept with the forwarding rule using it")
+	}
+	if !r.targetPool && r.healthCheck {
+		errs.Add(path("cleanup.healthCheck"), "must be kept with the target pool using it")
+	}
+}
+
+// release picks the resources removeResources deletes.
+type release struct {
+	forwardingRule, targetPool, healthCheck, firewall, address bool
+}
+
+func (r release) String() string {
+	kept := []string{}
+	for i, released := range []bool{r.forwardingRule, r.targetPool, r.healthCheck, r.firewall, r.address} {
+		if !released {
+			kept = append(kept, CleanupResources[i])
+		}
+	}
+	if len(kept) == 0 {
+		return "keeping nothing"
+	}
+	return "keeping the " + strings.Join(kept, ", ")
+}
+
+// cleanupEmpty records how long cfg's selector has matched no instance and,
+// once that is longer than cfg.Cleanup.After, removes the resources the
+// cleanup releases. It returns true while the load balancer is empty past
+// that point, so the reconcile doesn't create it again; as soon as an
+// instance is selected again the load balancer is recreated as usual.
+func (c *gceCloud) cleanupEmpty(ctx context.Context, cfg *Config) (bool, error) {
+	if cfg.Cleanup.After <= 0 {
+		return false, nil
+	}
+	if err := c.listInstancesPerZone(ctx, cfg); err != nil {
+		return false, err
+	}
+	empty := len(c.selectedInstances()) == 0
+	var since time.Time
+	st := c.stateFile(cfg)
+	st.Update(cfg.Name, func(lb *state.LoadBalancer) {
+		switch {
+		case !empty:
+			lb.EmptySince = nil
+		case lb.EmptySince == nil:
+			now := time.Now()
+			lb.EmptySince = &now
+		}
+		if lb.EmptySince != nil {
+			since = *lb.EmptySince
+		}
+	})
+	if err := st.Save(); err != nil {
+		return false, err
+	}
+	if !empty {
+		return false, nil
+	}
+	if left := cfg.Cleanup.After - time.Since(since); left > 0 {
+		fmt.Printf("====> No instance selected since %s, removing the load balancer in %s\n", since.Format(time.RFC3339), left.Round(time.Second))
+		return false, nil
+	}
+	r := cfg.Cleanup.releases()
+	fmt.Printf("--> No instance selected since %s, removing the load balancer, %s\n", since.Format(time.RFC3339), r)
+	return true, c.removeResources(ctx, cfg, r)
+}
+
+// CleanupLoadBalancer runs the cleanup of cfg on its own, for a discovered
+// load balancer whose group is gone: it never creates anything and returns
+// true once the load balancer has been removed as its cleanup says.
+func (c *gceCloud) CleanupLoadBalancer(ctx context.Context, cfg *Config) (bool, error) {
+	if err := c.resumeOperations(ctx, cfg); err != nil {
+		return false, err
+	}
+	ctx = c.trackOperations(ctx, cfg)
+	return c.cleanupEmpty(ctx, cfg)
+}
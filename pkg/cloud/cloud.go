@@ -1,24 +1,99 @@
 package cloud
 
 import (
+	"context"
+	"crypto/sha256"
 	"fmt"
+	"sort"
 	"strings"
+	"time"
 
 	"github.com/paulczar/gcp-lb-tags/pkg/cloud/gce"
+	"github.com/paulczar/gcp-lb-tags/pkg/probe"
+	"github.com/paulczar/gcp-lb-tags/pkg/state"
 	compute "google.golang.org/api/compute/v1"
 )
 
 type Config struct {
-	Name      string
-	Tags      []string
-	Labels    []string
-	Region    string
-	ProjectID string
-	Network   string
-	Ports     []string
-	Port      string
-	Address   string
-	Zones     []string
+	Name      string   `yaml:"name"`
+	Tags      []string `yaml:"tags,omitempty"`
+	Labels    []string `yaml:"labels,omitempty"`
+	Region    string   `yaml:"region"`
+	ProjectID string   `yaml:"project"`
+	Network   string   `yaml:"network"`
+	// Mode is the protocol load balanced, ModeTCP (the default) or ModeUDP.
+	Mode string `yaml:"mode,omitempty"`
+	// Port is the port or port range of the forwarding rule.
+	Port string `yaml:"port"`
+	// Ports are the ports the firewall admits, Port when empty.
+	Ports []string `yaml:"ports,omitempty"`
+	// SourceRanges the firewall admits clients from, anywhere when empty.
+	SourceRanges []string `yaml:"sourceRanges,omitempty"`
+	Address      string   `yaml:"address,omitempty"`
+	Zones        []string `yaml:"zones,omitempty"`
+	// Probe actively checks selected instances before admitting them.
+	Probe      probe.Config `yaml:"probe,omitempty"`
+	Hysteresis Hysteresis   `yaml:"hysteresis,omitempty"`
+	Safety     SafetyLimits `yaml:"safety,omitempty"`
+	Drain      Drain        `yaml:"drain,omitempty"`
+	Backoff    Backoff      `yaml:"backoff,omitempty"`
+	Cleanup    Cleanup      `yaml:"cleanup,omitempty"`
+	// HealthCheckPort enables a legacy HTTP health check on the target pool.
+	HealthCheckPort string     `yaml:"healthCheckPort,omitempty"`
+	HealthCheckPath string     `yaml:"healthCheckPath,omitempty"`
+	HealthGate      HealthGate `yaml:"healthGate,omitempty"`
+	// Template and Group are set on a discovered load balancer: the ID of
+	// the template it was rendered from and its group, stamped on its
+	// forwarding rule so it is found again once the group is gone.
+	Template string `yaml:"-"`
+	Group    string `yaml:"-"`
+	// Adopt allows mutating and destroying resources that lack an ownership marker.
+	Adopt bool `yaml:"-"`
+	// State is shared by every load balancer of a process, state is kept in
+	// memory per load balancer when it is nil.
+	State *state.File `yaml:"-"`
+}
+
+// Load balancing modes.
+const (
+	ModeTCP = "tcp"
+	ModeUDP = "udp"
+)
+
+func (cfg *Config) mode() string {
+	if cfg.Mode == "" {
+		return ModeTCP
+	}
+	return cfg.Mode
+}
+
+// healthCheckFirewall names the firewall rule admitting the health check probes.
+func (cfg *Config) healthCheckFirewall() string {
+	return cfg.Name + "-hc"
+}
+
+func (cfg *Config) firewallPorts() []string {
+	if len(cfg.Ports) > 0 {
+		return cfg.Ports
+	}
+	return []string{cfg.Port}
+}
+
+// Hash returns a short digest of the settings that shape the load balancer's resources.
+func (cfg *Config) Hash() string {
+	labels := append([]string{}, cfg.Labels...)
+	tags := append([]string{}, cfg.Tags...)
+	sort.Strings(labels)
+	sort.Strings(tags)
+	h := sha256.New()
+	fmt.Fprintf(h, "%s|%s|%s|%s|%s|%s|%s|%s|%s|%s",
+		cfg.Name, cfg.ProjectID, cfg.Region, cfg.Network, cfg.Port, cfg.Address,
+		strings.Join(labels, ","), strings.Join(tags, ","), cfg.HealthCheckPort, cfg.HealthCheckPath)
+	// only hashed when set so the hashes of existing TCP load balancers hold
+	if cfg.mode() != ModeTCP || len(cfg.Ports) > 0 || len(cfg.SourceRanges) > 0 {
+		fmt.Fprintf(h, "|%s|%s|%s", cfg.mode(), strings.Join(cfg.Ports, ","), strings.Join(cfg.SourceRanges, ","))
+	}
+	return fmt.Sprintf("%x", h.Sum(nil))[:12]
 }
 
 type gceCloud struct {
@@ -32,6 +107,13 @@ type gceCloud struct {
 	instanceGroups        map[string][]*compute.InstanceWithNamedPorts
 	instancesInTargetPool []string
 	externalAddress       *compute.Address
+	// health tracks member health between reconciles
+	health *healthGate
+	// prober probes selected instances in the background once enabled
+	prober       *probe.Prober
+	proberConfig probe.Config
+	// memState is used when the config doesn't carry a state file
+	memState *state.File
 }
 
 type loadBalancer struct {
@@ -40,58 +122,100 @@ type loadBalancer struct {
 
 // Cloud interface
 type Cloud interface {
-	CreateLoadBalancer(cfg *Config) error
-	RemoveLoadBalancer(cfg *Config, force bool) error
+	CreateLoadBalancer(ctx context.Context, cfg *Config) error
+	CleanupLoadBalancer(ctx context.Context, cfg *Config) (bool, error)
+	RemoveLoadBalancer(ctx context.Context, cfg *Config, force bool) error
+	ImportLoadBalancer(ctx context.Context, cfg *Config, forwardingRule string) (*Config, error)
+	ListLoadBalancers(ctx context.Context, cfg *Config) ([]*ManagedResource, error)
+	PlanLoadBalancer(ctx context.Context, cfg *Config) (*Plan, error)
+	LoadBalancerStatus(ctx context.Context, cfg *Config) (*Status, error)
+	Diagnose(ctx context.Context, cfg *Config) ([]*Finding, error)
+	ListLabelValues(ctx context.Context, cfg *Config, key string) ([]string, error)
+	ListDiscoveredGroups(ctx context.Context, cfg *Config) ([]string, error)
+	AddMember(ctx context.Context, cfg *Config, zone, instance string) error
+	RemoveMember(ctx context.Context, cfg *Config, zone, instance string) error
 }
 
-func (c *gceCloud) CreateLoadBalancer(cfg *Config) error {
+func (c *gceCloud) CreateLoadBalancer(ctx context.Context, cfg *Config) error {
 	var err error
+	if err = c.resumeOperations(ctx, cfg); err != nil {
+		return err
+	}
+	ctx = c.trackOperations(ctx, cfg)
 	fmt.Printf("Creating a Loadbalancer for instances with labels:\n - %s\n", strings.Join(cfg.Labels, "\n - "))
 
+	if removed, err := c.cleanupEmpty(ctx, cfg); removed || err != nil {
+		return err
+	}
+
+	healthCheck, err := c.configureHealthCheck(ctx, cfg)
+	if err != nil {
+		return err
+	}
+
 	fmt.Printf("--> Updating Target Pool %s\n", cfg.Name)
-	err = c.configureInstanceGroups(cfg)
+	err = c.configureInstanceGroups(ctx, cfg, healthCheck)
 	if err != nil {
 		return err
 	}
 
 	fmt.Println("--> Creating External Address:")
-	c.externalAddress, err = c.client.GetExternalIP(cfg.Region, cfg.Name)
+	c.externalAddress, err = c.client.GetExternalIP(ctx, cfg.Region, cfg.Address)
 	if err != nil {
 		return err
 	}
 	if c.externalAddress == nil {
-		c.externalAddress, err = c.client.CreateExternalIP(cfg.Region, cfg.Name)
+		c.externalAddress, err = c.client.CreateExternalIP(ctx, cfg.Region, cfg.Address, c.owner(cfg).Description())
 		fmt.Printf("====> Created External Address.")
 		if err != nil {
 			return err
 		}
+		if err = c.stampAddress(ctx, cfg, cfg.Address); err != nil {
+			return err
+		}
 	} else {
+		// an existing address is only attached, so it only gets stamped
+		// when it's already ours or is being adopted
+		if c.checkAddress(ctx, cfg, c.externalAddress) == nil {
+			if err = c.stampAddress(ctx, cfg, cfg.Address); err != nil {
+				return err
+			}
+		}
 		fmt.Printf("====> Used Existing External Address:")
 	}
-	fmt.Printf(" %s - %s\n", cfg.Name, c.externalAddress.Address)
+	fmt.Printf(" %s - %s\n", cfg.Address, c.externalAddress.Address)
 
 	// create or update firewall rule
-	// try to update first
 	fmt.Println("--> Creating Firewall Rule:")
-	if err := c.client.UpdateFirewall(cfg.Name, cfg.Network, cfg.Port, cfg.Tags); err != nil {
-		// couldn't update most probably because firewall didn't exist
-		if err := c.client.CreateFirewall(cfg.Name, cfg.Network, cfg.Port, cfg.Tags); err != nil {
-			// couldn't update or create
+	fw, err := c.client.GetFirewall(ctx, cfg.Name)
+	if err != nil {
+		return err
+	}
+	if fw == nil {
+		if err := c.client.CreateFirewall(ctx, cfg.Name, cfg.Network, cfg.mode(), cfg.firewallPorts(), cfg.Tags, cfg.SourceRanges, c.owner(cfg).Description()); err != nil {
 			return err
-		} else {
-			fmt.Printf("====> Created Firewall Rule: %s\n", cfg.Name)
 		}
+		fmt.Printf("====> Created Firewall Rule: %s\n", cfg.Name)
 	} else {
+		if err := c.checkFirewall(cfg, fw); err != nil {
+			return err
+		}
+		if err := c.client.UpdateFirewall(ctx, cfg.Name, cfg.Network, cfg.mode(), cfg.firewallPorts(), cfg.Tags, cfg.SourceRanges, c.owner(cfg).Description()); err != nil {
+			return err
+		}
 		fmt.Printf("====> Updated Firewall Rule: %s\n", cfg.Name)
 	}
+	if err = c.configureHealthCheckFirewall(ctx, cfg); err != nil {
+		return err
+	}
 
 	/** No health checks for tcp based LB
 		// ensure health checks are set up
 		fmt.Println("--> Updating Health Check")
 		for _, port := range cfg.Ports {
-			if err := c.client.UpdateHealthCheck(cfg.Name, port); err != nil {
+			if err := c.client.UpdateHealthCheck(ctx, cfg.Name, port); err != nil {
 				// couldn't update most probably because health-check didn't exist
-				if err := c.client.CreateHealthCheck(cfg.Name, port); err != nil {
+				if err := c.client.CreateHealthCheck(ctx, cfg.Name, port); err != nil {
 					// couldn't update or create
 					return err
 				} else {
@@ -106,10 +230,10 @@ func (c *gceCloud) CreateLoadBalancer(cfg *Config) error {
 		fmt.Println("--> Updating Backend Services")
 		// create or update backend service, only for allowed zones
 		// try to update first
-		if err := c.client.UpdateBackendService(cfg.Name, cfg.Port, c.zones); err != nil {
+		if err := c.client.UpdateBackendService(ctx, cfg.Name, cfg.Port, c.zones); err != nil {
 			// couldn't update most probably because backend service didn't exist
 			//return err
-			if err := c.client.CreateBackendService(cfg.Name, cfg.Port, c.zones); err != nil {
+			if err := c.client.CreateBackendService(ctx, cfg.Name, cfg.Port, c.zones); err != nil {
 				// couldn't update or create
 				return err
 			}
@@ -117,130 +241,284 @@ func (c *gceCloud) CreateLoadBalancer(cfg *Config) error {
 		fmt.Println("====> Created/updated backend service with success.")
 	  **/
 	fmt.Println("--> Creating Forwarding Rule:")
-	fr, err := c.client.GetForwardingRule(cfg.Region, cfg.Name)
+	fr, err := c.client.GetForwardingRule(ctx, cfg.Region, cfg.Name)
 	if err != nil {
 		return err
 	}
 	if fr == nil {
 		fmt.Printf("====> Creating Forwarding Rule: %s\n", cfg.Name)
-		fr, err = c.client.CreateForwardingRule(cfg.Region, cfg.Name, c.externalAddress.Address, cfg.Port)
-		return err
+		fr, err = c.client.CreateForwardingRule(ctx, cfg.Region, cfg.Name, c.externalAddress.Address, cfg.mode(), cfg.Port, c.owner(cfg).Description())
+		if err != nil {
+			return err
+		}
+		if err = c.stampForwardingRule(ctx, cfg, cfg.Name); err != nil {
+			return err
+		}
 	} else {
+		if err = c.checkForwardingRule(ctx, cfg, fr); err != nil {
+			return err
+		}
+		if err = c.stampForwardingRule(ctx, cfg, cfg.Name); err != nil {
+			return err
+		}
 		fmt.Printf("====> Using Existing Forwarding Rule: %s\n", cfg.Name)
 	}
 	fmt.Println("--> Done.")
 	return nil
 }
 
-func (c *gceCloud) RemoveLoadBalancer(cfg *Config, force bool) error {
+func (c *gceCloud) RemoveLoadBalancer(ctx context.Context, cfg *Config, force bool) error {
 	fmt.Printf("Deleting a Loadbalancer for instances with labels %s\n", strings.Join(cfg.Labels, ", "))
 	var err error
-
-	fmt.Println("--> Deleting Forwarding Rule")
-	if err = c.client.RemoveForwardingRule(cfg.Name, cfg.Region); err != nil {
+	if err = c.resumeOperations(ctx, cfg); err != nil {
 		return err
 	}
+	ctx = c.trackOperations(ctx, cfg)
+	return c.removeResources(ctx, cfg, release{forwardingRule: true, targetPool: true, healthCheck: true, firewall: true, address: force})
+}
 
-	fmt.Printf("--> Delete Target Pool %s\n", cfg.Name)
-	if err = c.client.RemoveTargetPool(cfg.Name, cfg.Region); err != nil {
+// removeResources deletes the resources of the load balancer r releases.
+func (c *gceCloud) removeResources(ctx context.Context, cfg *Config, r release) error {
+	var err error
+	// refuse before deleting anything so a foreign resource never leaves us half destroyed
+	fmt.Println("--> Checking ownership")
+	if err = c.checkRemoval(ctx, cfg, r.address); err != nil {
 		return err
 	}
 
-	fmt.Println("--> Deleting Firewall Rule")
-	if err = c.client.RemoveFirewall(cfg.Name); err != nil {
+	if r.forwardingRule {
+		fmt.Println("--> Deleting Forwarding Rule")
+		if err = c.client.RemoveForwardingRule(ctx, cfg.Name, cfg.Region); err != nil {
+			return err
+		}
+	}
+
+	if r.targetPool {
+		fmt.Printf("--> Delete Target Pool %s\n", cfg.Name)
+		if err = c.client.RemoveTargetPool(ctx, cfg.Name, cfg.Region); err != nil {
+			return err
+		}
+	}
+
+	if r.healthCheck {
+		fmt.Println("--> Deleting Health Check")
+		if err = c.client.RemoveHTTPHealthCheck(ctx, cfg.Name); err != nil {
+			return err
+		}
+	}
+
+	if r.healthCheck {
+		fmt.Println("--> Deleting Health Check Firewall Rule")
+		if err = c.client.RemoveFirewall(ctx, cfg.healthCheckFirewall()); err != nil {
+			return err
+		}
+	}
+
+	if r.firewall {
+		fmt.Println("--> Deleting Firewall Rule")
+		if err = c.client.RemoveFirewall(ctx, cfg.Name); err != nil {
+			return err
+		}
+	}
+	if r.address {
+		fmt.Println("--> Deleting External IP")
+		if err = c.client.RemoveExternalIP(ctx, cfg.Address, cfg.Region); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// checkRemoval verifies every resource RemoveLoadBalancer would delete belongs to this load balancer.
+func (c *gceCloud) checkRemoval(ctx context.Context, cfg *Config, force bool) error {
+	fr, err := c.client.GetForwardingRule(ctx, cfg.Region, cfg.Name)
+	if err != nil {
 		return err
 	}
+	if fr != nil {
+		if err = c.checkForwardingRule(ctx, cfg, fr); err != nil {
+			return err
+		}
+	}
+	tp, err := c.client.GetTargetPool(ctx, cfg.Region, cfg.Name)
+	if err != nil {
+		return err
+	}
+	if tp != nil {
+		if err = c.checkTargetPool(ctx, cfg, tp); err != nil {
+			return err
+		}
+	}
+	hc, err := c.client.GetHTTPHealthCheck(ctx, cfg.Name)
+	if err != nil {
+		return err
+	}
+	if hc != nil {
+		if err = c.checkHealthCheck(cfg, hc); err != nil {
+			return err
+		}
+	}
+	for _, name := range []string{cfg.Name, cfg.healthCheckFirewall()} {
+		fw, err := c.client.GetFirewall(ctx, name)
+		if err != nil {
+			return err
+		}
+		if fw != nil {
+			if err = c.checkFirewall(cfg, fw); err != nil {
+				return err
+			}
+		}
+	}
 	if force {
-		fmt.Println("--> Deleting External IP")
-		if err = c.client.RemoveExternalIP(cfg.Address, cfg.Region); err != nil {
+		a, err := c.client.GetExternalIP(ctx, cfg.Region, cfg.Address)
+		if err != nil {
 			return err
 		}
+		if a != nil {
+			if err = c.checkAddress(ctx, cfg, a); err != nil {
+				return err
+			}
+		}
 	}
 	return nil
 }
 
-func (c *gceCloud) configureInstanceGroups(cfg *Config) error {
+func (c *gceCloud) configureInstanceGroups(ctx context.Context, cfg *Config, healthCheck string) error {
 	var err error
 
 	// get list of instances in the zone
-	err = c.listInstancesPerZone(cfg)
+	err = c.listInstancesPerZone(ctx, cfg)
 	if err != nil {
 		return err
 	}
 
 	// get all the instances across all the zones
-	instancesInZones := []string{}
-	for _, z := range c.zones {
-		for _, i := range c.instancesInZone[z] {
-			instancesInZones = append(instancesInZones, i.SelfLink)
+	instancesInZones := c.selectedInstances()
+
+	// get list of instances in targetpool
+	tp, err := c.client.GetTargetPool(ctx, cfg.Region, cfg.Name)
+	if err != nil {
+		return err
+	}
+	members := []string{}
+	if tp != nil {
+		if err = c.checkTargetPool(ctx, cfg, tp); err != nil {
+			return err
 		}
+		members = tp.Instances
 	}
 
-	// get list of instances in targetpool
-	tp, err := c.client.GetTargetPool(cfg.Region, cfg.Name)
+	plan, err := c.planMembership(ctx, cfg, members, instancesInZones, false)
 	if err != nil {
 		return err
 	}
 	if tp == nil {
-		tp, err = c.client.CreateTargetPool(cfg.Region, cfg.Name, instancesInZones)
+		tp, err = c.client.CreateTargetPool(ctx, cfg.Region, cfg.Name, plan.toAdd(), c.owner(cfg).Description())
 		if err != nil {
 			return err
 		}
-	} else {
-		instancesInTargetPool := tp.Instances
-		toAdd := []*compute.InstanceReference{}
-		toDel := []*compute.InstanceReference{}
-		// create list of Instances in Zone, but not in IG
-		for _, i := range instancesInZones {
-			found := false
-			for _, c := range instancesInTargetPool {
-				if i == c {
-					found = true
-					continue
-				}
-			}
-			if !found {
-				fmt.Printf("Need to add %s to TargetPool\n", i)
-				toAdd = append(toAdd, &compute.InstanceReference{Instance: i})
-			}
-		}
-		// create list of Instances in IG but not in Zone
-		for _, i := range instancesInTargetPool {
-			found := false
-			for _, c := range instancesInZones {
-				if i == c {
-					found = true
-					continue
-				}
-			}
-			if !found {
-				fmt.Printf("Need to remove %s from TargetPool\n", i)
-				toDel = append(toDel, &compute.InstanceReference{Instance: i})
+	} else if err = c.applyMembership(ctx, cfg, plan); err != nil {
+		return err
+	}
+
+	// attach the health check members are judged by
+	if healthCheck != "" {
+		for _, hc := range tp.HealthChecks {
+			if hc == healthCheck {
+				return nil
 			}
 		}
+		fmt.Printf("====> Attaching Health Check %s\n", cfg.Name)
+		return c.client.AddHealthCheckToTargetPool(ctx, cfg.Region, cfg.Name, healthCheck)
+	}
+	return nil
+}
 
-		// Add and Delete Instances in TargetPool
-		if len(toAdd) > 0 {
-			err = c.client.AddInstanceToTargetPool(cfg.Region, cfg.Name, toAdd)
-			if err != nil {
-				return err
-			}
+// configureHealthCheckFirewall admits the legacy health check probes to
+// HealthCheckPort with a firewall rule of their own, as the load balancer's
+// rule only admits its ports from its source ranges. The rule is removed
+// again once the health check is no longer configured.
+func (c *gceCloud) configureHealthCheckFirewall(ctx context.Context, cfg *Config) error {
+	name := cfg.healthCheckFirewall()
+	fw, err := c.client.GetFirewall(ctx, name)
+	if err != nil {
+		return err
+	}
+	if cfg.HealthCheckPort == "" {
+		if fw == nil {
+			return nil
 		}
-		if len(toDel) > 0 {
-			err = c.client.DeleteInstanceFromTargetPool(cfg.Region, cfg.Name, toDel)
-			if err != nil {
-				return err
-			}
+		if o, ok := gce.OwnerFromDescription(fw.Description); !ok || o.LB != cfg.Name {
+			// not ours, it is left alone
+			return nil
+		}
+		fmt.Printf("--> Deleting Health Check Firewall Rule: %s\n", name)
+		return c.client.RemoveFirewall(ctx, name)
+	}
+	ports := []string{cfg.HealthCheckPort}
+	if fw == nil {
+		if err = c.client.CreateFirewall(ctx, name, cfg.Network, ModeTCP, ports, cfg.Tags, healthCheckRanges, c.owner(cfg).Description()); err != nil {
+			return err
 		}
+		fmt.Printf("====> Created Health Check Firewall Rule: %s\n", name)
+		return nil
+	}
+	if err = c.checkFirewall(cfg, fw); err != nil {
+		return err
+	}
+	if err = c.client.UpdateFirewall(ctx, name, cfg.Network, ModeTCP, ports, cfg.Tags, healthCheckRanges, c.owner(cfg).Description()); err != nil {
+		return err
 	}
+	fmt.Printf("====> Updated Health Check Firewall Rule: %s\n", name)
 	return nil
 }
 
-func (c *gceCloud) listInstancesPerZone(cfg *Config) error {
+// configureHealthCheck creates or updates the HTTP health check for the
+// target pool and returns its self link, or "" when none is configured.
+func (c *gceCloud) configureHealthCheck(ctx context.Context, cfg *Config) (string, error) {
+	if cfg.HealthCheckPort == "" {
+		return "", nil
+	}
+	fmt.Println("--> Updating Health Check:")
+	hc, err := c.client.GetHTTPHealthCheck(ctx, cfg.Name)
+	if err != nil {
+		return "", err
+	}
+	if hc == nil {
+		hc, err = c.client.CreateHTTPHealthCheck(ctx, cfg.Name, cfg.HealthCheckPort, cfg.HealthCheckPath, c.owner(cfg).Description())
+		if err != nil {
+			return "", err
+		}
+		fmt.Printf("====> Created Health Check for port %s\n", cfg.HealthCheckPort)
+		return hc.SelfLink, nil
+	}
+	if err = c.checkHealthCheck(cfg, hc); err != nil {
+		return "", err
+	}
+	if err = c.client.UpdateHTTPHealthCheck(ctx, cfg.Name, cfg.HealthCheckPort, cfg.HealthCheckPath, c.owner(cfg).Description()); err != nil {
+		return "", err
+	}
+	fmt.Printf("====> Updated Health Check for port %s\n", cfg.HealthCheckPort)
+	return hc.SelfLink, nil
+}
+
+// selectedInstances returns the self links of the instances found by the
+// last listInstancesPerZone across all the zones.
+func (c *gceCloud) selectedInstances() []string {
+	selected := []string{}
+	for _, z := range c.zones {
+		for _, i := range c.instancesInZone[z] {
+			selected = append(selected, i.SelfLink)
+		}
+	}
+	return selected
+}
+
+func (c *gceCloud) listInstancesPerZone(ctx context.Context, cfg *Config) error {
 	// First we need to make sure that an instance group exists
 	for _, z := range c.zones {
 		// Get a List of instances that match tags
-		zi, err := c.client.ListInstancesInZone(z, cfg.Tags, cfg.Labels)
+		zi, err := c.client.ListInstancesInZone(ctx, z, cfg.Tags, cfg.Labels)
 		if err != nil {
 			return err
 		}
@@ -249,10 +527,10 @@ func (c *gceCloud) listInstancesPerZone(cfg *Config) error {
 	return nil
 }
 
-func (c *gceCloud) listInstancesInInstanceGroups(cfg *Config) error {
+func (c *gceCloud) listInstancesInInstanceGroups(ctx context.Context, cfg *Config) error {
 	for _, z := range c.zones {
 		// fetch instance group for the zone
-		ig, err := c.client.GetInstanceGroup(cfg.ProjectID, z, cfg.Name)
+		ig, err := c.client.GetInstanceGroup(ctx, cfg.ProjectID, z, cfg.Name)
 		if err != nil {
 			return err
 		}
@@ -264,7 +542,7 @@ func (c *gceCloud) listInstancesInInstanceGroups(cfg *Config) error {
 		}
 
 		// Fetch list of instances in instance group for the zone
-		igl, err := c.client.ListInstancesInInstanceGroupForZone(cfg.Name, z)
+		igl, err := c.client.ListInstancesInInstanceGroupForZone(ctx, cfg.Name, z)
 		if igl != nil {
 			c.instanceGroups[z] = igl.Items
 			if err != nil {
@@ -275,16 +553,16 @@ func (c *gceCloud) listInstancesInInstanceGroups(cfg *Config) error {
 	return nil
 }
 
-func (c *gceCloud) ListZonesInRegion(cfg *Config) ([]string, error) {
-	return c.client.ListZonesInRegion(cfg.ProjectID, cfg.Region)
+func (c *gceCloud) ListZonesInRegion(ctx context.Context, cfg *Config) ([]string, error) {
+	return c.client.ListZonesInRegion(ctx, cfg.ProjectID, cfg.Region)
 }
 
-func (c *gceCloud) AddInstanceToTargetPool(region, name string, toAdd []*compute.InstanceReference) error {
-	return c.client.AddInstanceToTargetPool(region, name, toAdd)
+func (c *gceCloud) AddInstanceToTargetPool(ctx context.Context, region, name string, toAdd []*compute.InstanceReference) error {
+	return c.client.AddInstanceToTargetPool(ctx, region, name, toAdd)
 }
 
-func (c *gceCloud) DeleteInstanceFromTargetPool(region, name string, toAdd []*compute.InstanceReference) error {
-	return c.client.DeleteInstanceFromTargetPool(region, name, toAdd)
+func (c *gceCloud) DeleteInstanceFromTargetPool(ctx context.Context, region, name string, toAdd []*compute.InstanceReference) error {
+	return c.client.DeleteInstanceFromTargetPool(ctx, region, name, toAdd)
 }
 
 func (c *gceCloud) CreateForwardingRule(region, name, address string, port string) error {
@@ -292,8 +570,8 @@ func (c *gceCloud) CreateForwardingRule(region, name, address string, port strin
 	return nil
 }
 
-func (c *gceCloud) ListInstances(z string) (*compute.InstanceList, error) {
-	zoneInstances, err := c.client.ListInstancesInZone(z, []string{}, []string{})
+func (c *gceCloud) ListInstances(ctx context.Context, z string) (*compute.InstanceList, error) {
+	zoneInstances, err := c.client.ListInstancesInZone(ctx, z, []string{}, []string{})
 	if err != nil {
 		fmt.Printf("err: %v", err)
 		return nil, err
@@ -302,8 +580,8 @@ func (c *gceCloud) ListInstances(z string) (*compute.InstanceList, error) {
 	return zoneInstances, nil
 }
 
-func (c *gceCloud) GetTargetPool(region, name string) (*compute.TargetPool, error) {
-	tp, err := c.client.GetTargetPool(region, name)
+func (c *gceCloud) GetTargetPool(ctx context.Context, region, name string) (*compute.TargetPool, error) {
+	tp, err := c.client.GetTargetPool(ctx, region, name)
 	if err != nil {
 		fmt.Printf("err: %v", err)
 		return nil, err
@@ -311,14 +589,25 @@ func (c *gceCloud) GetTargetPool(region, name string) (*compute.TargetPool, erro
 	return tp, nil
 }
 
+// stateFile returns the state shared through cfg, or this load balancer's in-memory state.
+func (c *gceCloud) stateFile(cfg *Config) *state.File {
+	if cfg.State != nil {
+		return cfg.State
+	}
+	if c.memState == nil {
+		c.memState, _ = state.Open("")
+	}
+	return c.memState
+}
+
 // New cloud interface
-func New(projectID string, network string, region string) (Cloud, error) {
+func New(ctx context.Context, projectID string, network string, region string, operationTimeout time.Duration) (Cloud, error) {
 	// try and provision GCE client
-	c, err := gce.CreateGCECloud(projectID, network)
+	c, err := gce.CreateGCECloud(ctx, projectID, network, operationTimeout)
 	if err != nil {
 		return nil, err
 	}
-	zones, err := c.ListZonesInRegion(projectID, region)
+	zones, err := c.ListZonesInRegion(ctx, projectID, region)
 	if err != nil {
 		return nil, err
 	}
@@ -328,5 +617,6 @@ func New(projectID string, network string, region string) (Cloud, error) {
 		zones:           zones,
 		instancesInZone: make(map[string][]*compute.Instance),
 		instanceGroups:  make(map[string][]*compute.InstanceWithNamedPorts),
+		health:          newHealthGate(),
 	}, nil
 }
@@ -0,0 +1,49 @@
+package cloud
+
+import (
+	"context"
+	"sort"
+
+	"github.com/paulczar/gcp-lb-tags/pkg/cloud/gce"
+)
+
+// ListLabelValues returns the distinct values of the label key among the
+// instances cfg selects, sorted. Instances without the label are ignored.
+func (c *gceCloud) ListLabelValues(ctx context.Context, cfg *Config, key string) ([]string, error) {
+	seen := map[string]bool{}
+	for _, z := range c.zones {
+		zi, err := c.client.ListInstancesInZone(ctx, z, cfg.Tags, cfg.Labels)
+		if err != nil {
+			return nil, err
+		}
+		for _, i := range zi.Items {
+			if v, ok := i.Labels[key]; ok {
+				seen[v] = true
+			}
+		}
+	}
+	values := []string{}
+	for v := range seen {
+		values = append(values, v)
+	}
+	sort.Strings(values)
+	return values, nil
+}
+
+// ListDiscoveredGroups returns the groups of the load balancers rendered from
+// the template cfg.Template that still have a forwarding rule in cfg's region,
+// sorted, whether or not any instance is left in them.
+func (c *gceCloud) ListDiscoveredGroups(ctx context.Context, cfg *Config) ([]string, error) {
+	labels, err := c.client.ListForwardingRuleLabels(ctx, cfg.Region)
+	if err != nil {
+		return nil, err
+	}
+	groups := []string{}
+	for _, l := range labels {
+		if o, ok := gce.OwnerFromLabels(l); ok && o.Template == cfg.Template && o.Group != "" {
+			groups = append(groups, o.Group)
+		}
+	}
+	sort.Strings(groups)
+	return groups, nil
+}
@@ -0,0 +1,294 @@
+package cloud
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"path"
+	"strconv"
+	"strings"
+
+	"github.com/paulczar/gcp-lb-tags/pkg/cloud/gce"
+	compute "google.golang.org/api/compute/v1"
+)
+
+const (
+	// SeverityError marks a broken link that stops the load balancer serving.
+	SeverityError = "error"
+	// SeverityWarning marks something that may stop some traffic being served.
+	SeverityWarning = "warning"
+)
+
+// healthCheckRanges are the source ranges of the legacy health checks used by target pools.
+var healthCheckRanges = []string{"35.191.0.0/16", "209.85.152.0/22", "209.85.204.0/22"}
+
+// Finding is one problem found while diagnosing a load balancer.
+type Finding struct {
+	Severity string `json:"severity"`
+	Resource string `json:"resource"`
+	Problem  string `json:"problem"`
+	Hint     string `json:"hint"`
+}
+
+type diagnosis struct {
+	cfg      *Config
+	findings []*Finding
+}
+
+func (d *diagnosis) add(severity, resource, hint, format string, args ...interface{}) {
+	d.findings = append(d.findings, &Finding{
+		Severity: severity,
+		Resource: resource,
+		Problem:  fmt.Sprintf(format, args...),
+		Hint:     hint,
+	})
+}
+
+// Diagnose walks the chain from forwarding rule to target pool, instances,
+// network tags, firewall and health, and reports every broken link it finds.
+func (c *gceCloud) Diagnose(ctx context.Context, cfg *Config) ([]*Finding, error) {
+	d := &diagnosis{cfg: cfg, findings: []*Finding{}}
+
+	fr, err := c.client.GetForwardingRule(ctx, cfg.Region, cfg.Name)
+	if err != nil {
+		return nil, err
+	}
+	if fr == nil {
+		d.add(SeverityError, "forwarding-rule/"+cfg.Name,
+			"run `gcp-lb-tags create` to recreate it",
+			"forwarding rule %s does not exist in %s", cfg.Name, cfg.Region)
+		return d.findings, nil
+	}
+	if !strings.Contains(fr.Target, "/targetPools/") {
+		d.add(SeverityError, "forwarding-rule/"+fr.Name,
+			"point the forwarding rule at a target pool with `gcloud compute forwarding-rules set-target`",
+			"forwarding rule targets %s rather than a target pool", fr.Target)
+		return d.findings, nil
+	}
+
+	tpRegion, tpName := gce.ParseSelfLink(fr.Target)
+	tp, err := c.client.GetTargetPool(ctx, tpRegion, tpName)
+	if err != nil {
+		return nil, err
+	}
+	if tp == nil {
+		d.add(SeverityError, "target-pool/"+tpName,
+			"run `gcp-lb-tags create` to recreate it",
+			"target pool %s referenced by the forwarding rule does not exist", tpName)
+		return d.findings, nil
+	}
+	if len(tp.Instances) == 0 {
+		hint := "check that `create --loop` is running and the instances carry the load balancer's labels"
+		if len(cfg.Labels) > 0 {
+			hint = "check that `create --loop` is running and the instances carry the labels " + strings.Join(cfg.Labels, ", ")
+		}
+		d.add(SeverityError, "target-pool/"+tp.Name, hint, "target pool is empty")
+		return d.findings, nil
+	}
+
+	members := []*compute.Instance{}
+	for _, link := range tp.Instances {
+		zone, name := gce.ParseSelfLink(link)
+		i, err := c.client.GetInstance(ctx, zone, name)
+		if err != nil {
+			return nil, err
+		}
+		if i == nil {
+			d.add(SeverityError, "instance/"+name,
+				"run `gcp-lb-tags create` to drop it from the target pool",
+				"target pool member no longer exists")
+			continue
+		}
+		if i.Status != "RUNNING" {
+			d.add(SeverityWarning, "instance/"+name,
+				"start the instance or let `create --loop` replace it",
+				"target pool member is %s", i.Status)
+		}
+		members = append(members, i)
+	}
+
+	c.diagnoseFirewall(ctx, d, fr, tp, members)
+	c.diagnoseHealth(ctx, d, tp, tpRegion)
+	return d.findings, nil
+}
+
+func (c *gceCloud) diagnoseFirewall(ctx context.Context, d *diagnosis, fr *compute.ForwardingRule, tp *compute.TargetPool, members []*compute.Instance) {
+	fw, err := c.client.GetFirewall(ctx, d.cfg.Name)
+	if err != nil {
+		d.add(SeverityWarning, "firewall/"+d.cfg.Name,
+			"check the permissions of the credentials and run `gcp-lb-tags doctor` again",
+			"firewall rule could not be read: %s", err)
+		return
+	}
+	if fw == nil {
+		d.add(SeverityError, "firewall/"+d.cfg.Name,
+			"run `gcp-lb-tags create` to recreate it",
+			"firewall rule %s does not exist", d.cfg.Name)
+		return
+	}
+	resource := "firewall/" + fw.Name
+	if fw.Direction == "EGRESS" {
+		d.add(SeverityError, resource,
+			"delete the rule and run `gcp-lb-tags create` to recreate it as an ingress rule",
+			"firewall rule is an egress rule")
+	}
+
+	if !firewallAllows(fw, fr.IPProtocol, fr.PortRange) {
+		d.add(SeverityError, resource,
+			fmt.Sprintf("allow %s:%s on the rule, or run `gcp-lb-tags create --port %s`", strings.ToLower(fr.IPProtocol), portFromRange(fr.PortRange), portFromRange(fr.PortRange)),
+			"firewall rule does not allow %s port %s used by the forwarding rule", fr.IPProtocol, fr.PortRange)
+	}
+
+	if !rangesCover(fw.SourceRanges, "0.0.0.0/0") {
+		d.add(SeverityWarning, resource,
+			"add 0.0.0.0/0 to the source ranges if the VIP should be reachable from anywhere",
+			"firewall rule only admits clients from %s", strings.Join(fw.SourceRanges, ", "))
+	}
+	if len(tp.HealthChecks) > 0 {
+		c.diagnoseHealthCheckFirewall(ctx, d, fw, tp)
+	}
+
+	for _, i := range members {
+		if len(i.NetworkInterfaces) > 0 && path.Base(i.NetworkInterfaces[0].Network) != path.Base(fw.Network) {
+			d.add(SeverityError, "instance/"+i.Name,
+				"run `gcp-lb-tags create --network "+path.Base(i.NetworkInterfaces[0].Network)+"`",
+				"instance is on network %s but the firewall rule is on %s", path.Base(i.NetworkInterfaces[0].Network), path.Base(fw.Network))
+		}
+		if len(fw.TargetTags) > 0 && !hasAnyTag(i, fw.TargetTags) {
+			zone := path.Base(i.Zone)
+			d.add(SeverityError, "instance/"+i.Name,
+				fmt.Sprintf("gcloud compute instances add-tags %s --zone %s --tags %s", i.Name, zone, fw.TargetTags[0]),
+				"instance is missing the firewall tag (one of %s)", strings.Join(fw.TargetTags, ", "))
+		}
+	}
+}
+
+// diagnoseHealthCheckFirewall checks that the health check probes reach the
+// health check port, through the load balancer's rule or its health check rule.
+func (c *gceCloud) diagnoseHealthCheckFirewall(ctx context.Context, d *diagnosis, fw *compute.Firewall, tp *compute.TargetPool) {
+	port := d.cfg.HealthCheckPort
+	if hc, err := c.client.GetHTTPHealthCheck(ctx, path.Base(tp.HealthChecks[0])); err == nil && hc != nil {
+		port = strconv.FormatInt(hc.Port, 10)
+		if hc.Port == 0 {
+			port = "80"
+		}
+	}
+	rules := []*compute.Firewall{fw}
+	if hcfw, err := c.client.GetFirewall(ctx, d.cfg.healthCheckFirewall()); err == nil && hcfw != nil && hcfw.Direction != "EGRESS" {
+		rules = append(rules, hcfw)
+	}
+	admits := func(r *compute.Firewall, cidr string) bool {
+		return rangesCover(r.SourceRanges, cidr) && (port == "" || firewallAllows(r, "tcp", port))
+	}
+
+	resource := "firewall/" + fw.Name
+	if port != "" {
+		allowed := false
+		for _, r := range rules {
+			allowed = allowed || firewallAllows(r, "tcp", port)
+		}
+		if !allowed {
+			d.add(SeverityError, resource,
+				"run `gcp-lb-tags create --health-check-port "+port+"` to add the health check firewall rule",
+				"no firewall rule allows tcp port %s used by the health check", port)
+			return
+		}
+	}
+	for _, cidr := range healthCheckRanges {
+		covered := false
+		for _, r := range rules {
+			covered = covered || admits(r, cidr)
+		}
+		if !covered {
+			d.add(SeverityError, resource,
+				"run `gcp-lb-tags create` to add the health check firewall rule, or add "+strings.Join(healthCheckRanges, ", ")+" to the source ranges",
+				"firewall rules block health check probes from %s", cidr)
+		}
+	}
+}
+
+func (c *gceCloud) diagnoseHealth(ctx context.Context, d *diagnosis, tp *compute.TargetPool, region string) {
+	unhealthy := 0
+	for _, link := range tp.Instances {
+		_, name := gce.ParseSelfLink(link)
+		hs, err := c.client.GetTargetPoolHealth(ctx, region, tp.Name, link)
+		if err != nil || len(hs) == 0 {
+			continue
+		}
+		if hs[0].HealthState != "HEALTHY" {
+			unhealthy++
+			d.add(SeverityWarning, "instance/"+name,
+				"check the service is listening and the health check port and path are right",
+				"target pool reports the instance %s", hs[0].HealthState)
+		}
+	}
+	if unhealthy > 0 && unhealthy == len(tp.Instances) {
+		d.add(SeverityError, "target-pool/"+tp.Name,
+			"check the health check configuration and that the firewall admits health check probes",
+			"every member of the target pool is unhealthy")
+	}
+}
+
+// firewallAllows reports whether any allow entry admits protocol on every port of portRange.
+func firewallAllows(fw *compute.Firewall, protocol, portRange string) bool {
+	lo, hi := parsePortRange(portRange)
+	for _, a := range fw.Allowed {
+		if a.IPProtocol != "all" && !strings.EqualFold(a.IPProtocol, protocol) {
+			continue
+		}
+		if len(a.Ports) == 0 {
+			return true
+		}
+		for _, p := range a.Ports {
+			plo, phi := parsePortRange(p)
+			if plo <= lo && hi <= phi {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func parsePortRange(r string) (int, int) {
+	p := strings.SplitN(r, "-", 2)
+	lo, _ := strconv.Atoi(p[0])
+	hi := lo
+	if len(p) == 2 {
+		hi, _ = strconv.Atoi(p[1])
+	}
+	return lo, hi
+}
+
+// rangesCover reports whether one of ranges contains the whole of cidr.
+func rangesCover(ranges []string, cidr string) bool {
+	_, want, err := net.ParseCIDR(cidr)
+	if err != nil {
+		return false
+	}
+	wantOnes, _ := want.Mask.Size()
+	for _, r := range ranges {
+		_, n, err := net.ParseCIDR(r)
+		if err != nil {
+			continue
+		}
+		ones, _ := n.Mask.Size()
+		if ones <= wantOnes && n.Contains(want.IP) {
+			return true
+		}
+	}
+	return false
+}
+
+func hasAnyTag(i *compute.Instance, tags []string) bool {
+	if i.Tags == nil {
+		return false
+	}
+	for _, t := range i.Tags.Items {
+		for _, want := range tags {
+			if t == want {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,115 @@
+package cloud
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/paulczar/gcp-lb-tags/pkg/cloud/gce"
+	"github.com/paulczar/gcp-lb-tags/pkg/state"
+)
+
+// DrainMetadataKey is set on an instance's metadata, to the load balancer's
+// name, while it drains so a health endpoint on the instance can start
+// failing the target pool health check.
+const DrainMetadataKey = "gcp-lb-tags-drain"
+
+// Drain keeps members that are leaving in the target pool for a grace period
+// so their connections can finish.
+type Drain struct {
+	// Label marks an instance to drain even though it still matches the
+	// labels, as key:value or key=value.
+	Label string `yaml:"label,omitempty"`
+	// Grace is how long a leaving member stays in the target pool.
+	Grace time.Duration `yaml:"grace,omitempty"`
+	// FailHealthCheck sets DrainMetadataKey on draining members, it needs
+	// an HTTP health check on the target pool.
+	FailHealthCheck bool `yaml:"failHealthCheck,omitempty"`
+}
+
+// splitLabel splits a key:value or key=value label.
+func splitLabel(l string) (string, string, bool) {
+	i := strings.IndexAny(l, ":=")
+	if i < 0 {
+		return "", "", false
+	}
+	return l[:i], l[i+1:], true
+}
+
+// applyDrainLabel stops wanting selected instances that carry the drain label.
+func (c *gceCloud) applyDrainLabel(cfg *Config, plan *membershipPlan) {
+	k, v, ok := splitLabel(cfg.Drain.Label)
+	if !ok {
+		return
+	}
+	for _, z := range c.zones {
+		for _, i := range c.instancesInZone[z] {
+			if plan.desired[i.SelfLink] && i.Labels[k] == v {
+				plan.desired[i.SelfLink] = false
+				plan.notes[i.SelfLink] = "drain label " + cfg.Drain.Label
+			}
+		}
+	}
+}
+
+// applyDrainGrace keeps leaving members in the plan until they have drained
+// for cfg.Drain.Grace, optionally telling them to fail their health check
+// first.
+func (c *gceCloud) applyDrainGrace(ctx context.Context, cfg *Config, plan *membershipPlan) error {
+	d := cfg.Drain
+	now := time.Now()
+	signal := d.FailHealthCheck && cfg.HealthCheckPort != ""
+	toSignal := []string{}
+	toClear := []string{}
+	c.stateFile(cfg).Update(cfg.Name, func(lb *state.LoadBalancer) {
+		leaving := map[string]bool{}
+		for _, i := range plan.toDel() {
+			leaving[i] = true
+			dr, ok := lb.Draining[i]
+			if !ok {
+				dr = &state.Drain{Since: now}
+				lb.Draining[i] = dr
+			}
+			if signal && !dr.Signalled {
+				dr.Signalled = true
+				toSignal = append(toSignal, i)
+			}
+			if left := d.Grace - now.Sub(dr.Since); left > 0 {
+				plan.desired[i] = true
+				plan.notes[i] = fmt.Sprintf("draining, removing in %s", left.Round(time.Second))
+			}
+		}
+		// members that were removed on an earlier reconcile, or are wanted again
+		for i, dr := range lb.Draining {
+			if !leaving[i] {
+				if dr.Signalled {
+					toClear = append(toClear, i)
+				}
+				delete(lb.Draining, i)
+			}
+		}
+	})
+	if plan.dryRun {
+		return nil
+	}
+	if err := c.stateFile(cfg).Save(); err != nil {
+		return err
+	}
+
+	name := cfg.Name
+	for _, i := range toSignal {
+		zone, instance := gce.ParseSelfLink(i)
+		fmt.Printf("====> Telling %s to fail its health check\n", instance)
+		if err := c.client.SetInstanceMetadata(ctx, zone, instance, DrainMetadataKey, &name); err != nil {
+			return err
+		}
+	}
+	for _, i := range toClear {
+		zone, instance := gce.ParseSelfLink(i)
+		if err := c.client.SetInstanceMetadata(ctx, zone, instance, DrainMetadataKey, nil); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,94 @@
+package gce
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+
+	"google.golang.org/api/googleapi"
+)
+
+// Error is a failed GCE API call.
+type Error struct {
+	// Op is what was being done, such as "get address".
+	Op   string
+	Name string
+	Err  error
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("%s %s: %v", e.Op, e.Name, e.Err)
+}
+
+// Unwrap returns the underlying API error.
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// OperationError is the error an operation finished with.
+type OperationError struct {
+	Name string
+	Err  *googleapi.Error
+}
+
+func (e *OperationError) Error() string {
+	return fmt.Sprintf("GCE operation failed: %v", e.Err)
+}
+
+// Unwrap returns the operation's error.
+func (e *OperationError) Unwrap() error {
+	return e.Err
+}
+
+// IsOperationError reports whether err is an operation that finished with
+// an error, rather than a failure to find out how it finished.
+func IsOperationError(err error) bool {
+	var opErr *OperationError
+	return errors.As(err, &opErr)
+}
+
+// rateLimitReasons are the error reasons GCE returns, sometimes with a 403,
+// when a request was throttled rather than refused, and the error code of an
+// operation that was.
+var rateLimitReasons = map[string]bool{
+	"rateLimitExceeded":     true,
+	"userRateLimitExceeded": true,
+	"RATE_LIMIT_EXCEEDED":   true,
+}
+
+// IsRetryable reports whether err is likely to go away on its own: rate
+// limits, 429 and 5xx responses or operations finishing with them, network
+// errors and calls or operation waits that ran past their deadline. A
+// cancelled context is not retryable.
+func IsRetryable(err error) bool {
+	if errors.Is(err, context.Canceled) {
+		return false
+	}
+	var opErr *OperationError
+	if errors.As(err, &opErr) {
+		return retryableAPIError(opErr.Err)
+	}
+	var apiErr *googleapi.Error
+	if errors.As(err, &apiErr) {
+		return retryableAPIError(apiErr)
+	}
+	if errors.Is(err, context.DeadlineExceeded) {
+		return true
+	}
+	var netErr net.Error
+	return errors.As(err, &netErr)
+}
+
+func retryableAPIError(err *googleapi.Error) bool {
+	if err.Code == http.StatusTooManyRequests || err.Code >= http.StatusInternalServerError {
+		return true
+	}
+	for _, e := range err.Errors {
+		if rateLimitReasons[e.Reason] {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,51 @@
+package gce
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"testing"
+
+	compute "google.golang.org/api/compute/v1"
+	"google.golang.org/api/googleapi"
+)
+
+func failedOp(status int64, code string) *compute.Operation {
+	return &compute.Operation{
+		Name:                "op",
+		Status:              "DONE",
+		HttpErrorStatusCode: status,
+		Error: &compute.OperationError{
+			Errors: []*compute.OperationErrorErrors{{Code: code, Message: "failed"}},
+		},
+	}
+}
+
+func TestIsRetryable(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{"not found", &googleapi.Error{Code: 404}, false},
+		{"conflict", &googleapi.Error{Code: 409}, false},
+		{"too many requests", &googleapi.Error{Code: 429}, true},
+		{"server error", &googleapi.Error{Code: 503}, true},
+		{"rate limited with a 403", &googleapi.Error{Code: 403, Errors: []googleapi.ErrorItem{{Reason: "rateLimitExceeded"}}}, true},
+		{"forbidden", &googleapi.Error{Code: 403, Errors: []googleapi.ErrorItem{{Reason: "forbidden"}}}, false},
+		{"wrapped server error", &Error{Op: "get address", Name: "lb", Err: &googleapi.Error{Code: 500}}, true},
+		{"operation refused", getErrorFromOp(failedOp(400, "INVALID_FIELD_VALUE")), false},
+		{"operation server error", getErrorFromOp(failedOp(503, "INTERNAL_ERROR")), true},
+		{"operation rate limited", getErrorFromOp(failedOp(403, "RATE_LIMIT_EXCEEDED")), true},
+		{"operation wait past its deadline", fmt.Errorf("waiting for operation op: %w", context.DeadlineExceeded), true},
+		{"cancelled", fmt.Errorf("waiting for operation op: %w", context.Canceled), false},
+		{"network error", &net.OpError{Op: "dial", Err: errors.New("connection refused")}, true},
+		{"other error", errors.New("operation must not be nil"), false},
+	}
+	for _, tt := range tests {
+		if got := IsRetryable(tt.err); got != tt.want {
+			t.Errorf("%s: IsRetryable(%v) = %v, want %v", tt.name, tt.err, got, tt.want)
+		}
+	}
+}
@@ -7,6 +7,7 @@ import (
 	"path"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/golang/glog"
 	"golang.org/x/oauth2/google"
@@ -16,15 +17,19 @@ import (
 // GCEClient is a placeholder for GCE stuff.
 type GCEClient struct {
 	service    *compute.Service
+	client     *http.Client
 	projectID  string
 	networkURL string
+	// operationTimeout bounds the wait for each operation
+	operationTimeout time.Duration
 }
 
-// CreateGCECloud creates a new instance of GCECloud.
-func CreateGCECloud(project string, network string) (*GCEClient, error) {
-	// Use oauth2.NoContext if there isn't a good context to pass in.
-	ctx := context.TODO()
-
+// CreateGCECloud creates a new instance of GCECloud. Operations are waited
+// for up to operationTimeout, or DefaultOperationTimeout when it is 0.
+func CreateGCECloud(ctx context.Context, project string, network string, operationTimeout time.Duration) (*GCEClient, error) {
+	if operationTimeout <= 0 {
+		operationTimeout = DefaultOperationTimeout
+	}
 	client, err := google.DefaultClient(ctx, compute.ComputeScope)
 	if err != nil {
 		return nil, err
@@ -37,8 +42,10 @@ func CreateGCECloud(project string, network string) (*GCEClient, error) {
 	// TODO validate project and network exist
 
 	return &GCEClient{
-		service:   svc,
-		projectID: project,
+		service:          svc,
+		client:           client,
+		projectID:        project,
+		operationTimeout: operationTimeout,
 		//networkURL: makeNetworkURL(project, network),
 	}, nil
 }
@@ -47,10 +54,24 @@ func makeNetworkURL(project string, network string) string {
 	return fmt.Sprintf("https://www.googleapis.com/compute/v1/projects/%s/global/networks/%s", project, network)
 }
 
+// ParseSelfLink splits a resource self link into its scope (zone or region)
+// and its name, e.g. ".../zones/us-central1-a/instances/vm-1" gives
+// "us-central1-a" and "vm-1".
+func ParseSelfLink(link string) (scope string, name string) {
+	parts := strings.Split(strings.TrimSuffix(link, "/"), "/")
+	name = parts[len(parts)-1]
+	for i := 0; i+1 < len(parts); i++ {
+		if parts[i] == "zones" || parts[i] == "regions" {
+			scope = parts[i+1]
+		}
+	}
+	return scope, name
+}
+
 // ListZonesInRegion gets a list of zones in a given region
-func (gce *GCEClient) ListZonesInRegion(project, region string) ([]string, error) {
+func (gce *GCEClient) ListZonesInRegion(ctx context.Context, project, region string) ([]string, error) {
 	var zones []string
-	r, err := gce.service.Regions.Get(project, region).Do()
+	r, err := gce.service.Regions.Get(project, region).Context(ctx).Do()
 	if err != nil {
 		return nil, err
 	}
@@ -61,8 +82,8 @@ func (gce *GCEClient) ListZonesInRegion(project, region string) ([]string, error
 }
 
 // GetInstanceGroup returns an instance group by name
-func (gce *GCEClient) GetInstanceGroup(project, zone, name string) (*compute.InstanceGroup, error) {
-	ig, err := gce.service.InstanceGroups.Get(project, zone, name).Do()
+func (gce *GCEClient) GetInstanceGroup(ctx context.Context, project, zone, name string) (*compute.InstanceGroup, error) {
+	ig, err := gce.service.InstanceGroups.Get(project, zone, name).Context(ctx).Do()
 	if err != nil {
 		if isHTTPErrorCode(err, 404) {
 			return nil, nil
@@ -75,22 +96,22 @@ func (gce *GCEClient) GetInstanceGroup(project, zone, name string) (*compute.Ins
 }
 
 // DeleteInstanceGroup returns an instance group by name
-func (gce *GCEClient) DeleteInstanceGroup(project, zone, name string) error {
-	op, err := gce.service.InstanceGroups.Delete(project, zone, name).Do()
+func (gce *GCEClient) DeleteInstanceGroup(ctx context.Context, project, zone, name string) error {
+	op, err := gce.service.InstanceGroups.Delete(project, zone, name).Context(ctx).Do()
 	if err != nil {
 		return err
 	}
-	if err = gce.waitForZoneOp(op, zone); err != nil {
+	if err = gce.waitForZoneOp(ctx, op, zone); err != nil {
 		return err
 	}
 	return nil
 }
 
 // ListInstancesInInstanceGroupForZone lists all the instances in a given instance group for the given zone.
-func (gce *GCEClient) ListInstancesInInstanceGroupForZone(name string, zone string) (*compute.InstanceGroupsListInstances, error) {
+func (gce *GCEClient) ListInstancesInInstanceGroupForZone(ctx context.Context, name string, zone string) (*compute.InstanceGroupsListInstances, error) {
 	ig, err := gce.service.InstanceGroups.ListInstances(
 		gce.projectID, zone, name,
-		&compute.InstanceGroupsListInstancesRequest{}).Do()
+		&compute.InstanceGroupsListInstancesRequest{}).Context(ctx).Do()
 	if err != nil {
 		if isHTTPErrorCode(err, 404) {
 			return nil, nil
@@ -102,15 +123,15 @@ func (gce *GCEClient) ListInstancesInInstanceGroupForZone(name string, zone stri
 }
 
 // AddInstancesToInstanceGroup adds given instance to an Instance Group
-func (gce *GCEClient) AddInstancesToInstanceGroup(name, zone string, instances []*compute.InstanceReference) error {
+func (gce *GCEClient) AddInstancesToInstanceGroup(ctx context.Context, name, zone string, instances []*compute.InstanceReference) error {
 	var err error
 	// check instance group exists before trying to add
-	ig, err := gce.GetInstanceGroup(gce.projectID, zone, name)
+	ig, err := gce.GetInstanceGroup(ctx, gce.projectID, zone, name)
 	if err != nil {
 		return err
 	}
 	if ig == nil {
-		_, err = gce.CreateInstanceGroup(gce.projectID, zone, name)
+		_, err = gce.CreateInstanceGroup(ctx, gce.projectID, zone, name)
 		if err != nil {
 			return err
 		}
@@ -119,124 +140,199 @@ func (gce *GCEClient) AddInstancesToInstanceGroup(name, zone string, instances [
 		gce.projectID, zone, name,
 		&compute.InstanceGroupsAddInstancesRequest{
 			Instances: instances,
-		}).Do()
+		}).Context(ctx).Do()
 	if err != nil {
 		return err
 	}
-	if err = gce.waitForZoneOp(op, zone); err != nil {
+	if err = gce.waitForZoneOp(ctx, op, zone); err != nil {
 		return err
 	}
 	return nil
 }
 
 // RemoveInstancesFromInstanceGroup adds given instance to an Instance Group
-func (gce *GCEClient) RemoveInstancesFromInstanceGroup(name, zone string, instances []*compute.InstanceReference) error {
+func (gce *GCEClient) RemoveInstancesFromInstanceGroup(ctx context.Context, name, zone string, instances []*compute.InstanceReference) error {
 	op, err := gce.service.InstanceGroups.RemoveInstances(
 		gce.projectID, zone, name,
 		&compute.InstanceGroupsRemoveInstancesRequest{
 			Instances: instances,
-		}).Do()
+		}).Context(ctx).Do()
 	if err != nil {
 		return err
 	}
-	if err = gce.waitForZoneOp(op, zone); err != nil {
+	if err = gce.waitForZoneOp(ctx, op, zone); err != nil {
 		return err
 	}
 	return nil
 }
 
 // CreateInstanceGroup returns an instance group by name
-func (gce *GCEClient) CreateInstanceGroup(project, zone, name string) (*compute.InstanceGroup, error) {
+func (gce *GCEClient) CreateInstanceGroup(ctx context.Context, project, zone, name string) (*compute.InstanceGroup, error) {
 	fmt.Printf("Creating Instance Group %s in %s\n", name, zone)
 	ig := &compute.InstanceGroup{
 		Name: name,
 		Zone: zone,
 	}
-	op, err := gce.service.InstanceGroups.Insert(project, zone, ig).Do()
+	op, err := gce.service.InstanceGroups.Insert(project, zone, ig).Context(ctx).Do()
 	if err != nil {
 		return nil, err
 	}
-	if err = gce.waitForZoneOp(op, zone); err != nil {
+	if err = gce.waitForZoneOp(ctx, op, zone); err != nil {
 		return nil, err
 	}
-	a, err := gce.GetInstanceGroup(project, zone, name)
+	a, err := gce.GetInstanceGroup(ctx, project, zone, name)
 	if err != nil {
 		return nil, err
 	}
 	return a, nil
 }
 
-// ListInstancesInZone returns all instances in a zone
-func (gce *GCEClient) ListInstancesInZone(zone string, tags, labels []string) (*compute.InstanceList, error) {
+// ListInstancesInZone returns all instances in a zone carrying every label,
+// each written key:value
+func (gce *GCEClient) ListInstancesInZone(ctx context.Context, zone string, tags, labels []string) (*compute.InstanceList, error) {
 	var filter string
 	//fmt.Printf("fetching instances in %s\n", zone)
 	list := gce.service.Instances.List(gce.projectID, zone)
 	filters := []string{}
 	for _, l := range labels {
-		s := strings.Split(l, ":")
+		s := strings.SplitN(l, ":", 2)
+		if len(s) != 2 || s[0] == "" {
+			return nil, fmt.Errorf("invalid label %q, expected key:value", l)
+		}
 		filter = "(labels." + s[0] + " eq '" + s[1] + "')" //"job:master"
 		filters = append(filters, filter)
 	}
 	list.Filter(strings.Join(filters, ""))
 	// TODO implement tag filter
-	return list.Do()
+	all := &compute.InstanceList{}
+	err := list.Pages(ctx, func(l *compute.InstanceList) error {
+		all.Items = append(all.Items, l.Items...)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return all, nil
+}
+
+// GetInstance returns a compute instance by zone and name
+func (gce *GCEClient) GetInstance(ctx context.Context, zone, name string) (*compute.Instance, error) {
+	i, err := gce.service.Instances.Get(gce.projectID, zone, name).Context(ctx).Do()
+	if err != nil {
+		if isHTTPErrorCode(err, http.StatusNotFound) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return i, nil
+}
+
+// SetInstanceMetadata sets a single metadata key on an instance, or removes
+// it when value is nil, leaving the other keys alone.
+func (gce *GCEClient) SetInstanceMetadata(ctx context.Context, zone, name, key string, value *string) error {
+	i, err := gce.GetInstance(ctx, zone, name)
+	if err != nil {
+		return err
+	}
+	if i == nil {
+		return nil
+	}
+	md := i.Metadata
+	if md == nil {
+		md = &compute.Metadata{}
+	}
+	items := []*compute.MetadataItems{}
+	for _, item := range md.Items {
+		if item.Key != key {
+			items = append(items, item)
+		}
+	}
+	if value != nil {
+		items = append(items, &compute.MetadataItems{Key: key, Value: value})
+	}
+	md.Items = items
+	op, err := gce.service.Instances.SetMetadata(gce.projectID, zone, name, md).Context(ctx).Do()
+	if err != nil {
+		return err
+	}
+	return gce.waitForZoneOp(ctx, op, zone)
 }
 
 // AddInstanceToTargetPool adds instances to the targetpool
-func (gce *GCEClient) AddInstanceToTargetPool(region, name string, toAdd []*compute.InstanceReference) error {
+func (gce *GCEClient) AddInstanceToTargetPool(ctx context.Context, region, name string, toAdd []*compute.InstanceReference) error {
 	add := &compute.TargetPoolsAddInstanceRequest{Instances: toAdd}
-	op, err := gce.service.TargetPools.AddInstance(gce.projectID, region, name, add).Do()
+	op, err := gce.service.TargetPools.AddInstance(gce.projectID, region, name, add).Context(ctx).Do()
 	if err != nil {
 		return err
 	}
-	if err = gce.waitForRegionOp(op, region); err != nil {
+	if err = gce.waitForRegionOp(ctx, op, region); err != nil {
 		return err
 	}
 	return nil
 }
 
 // DeleteInstanceFromTargetPool deletes instances from the targetpool
-func (gce *GCEClient) DeleteInstanceFromTargetPool(region, name string, toDel []*compute.InstanceReference) error {
+func (gce *GCEClient) DeleteInstanceFromTargetPool(ctx context.Context, region, name string, toDel []*compute.InstanceReference) error {
 	del := &compute.TargetPoolsRemoveInstanceRequest{Instances: toDel}
-	op, err := gce.service.TargetPools.RemoveInstance(gce.projectID, region, name, del).Do()
+	op, err := gce.service.TargetPools.RemoveInstance(gce.projectID, region, name, del).Context(ctx).Do()
 	if err != nil {
 		return err
 	}
-	if err = gce.waitForRegionOp(op, region); err != nil {
+	if err = gce.waitForRegionOp(ctx, op, region); err != nil {
 		return err
 	}
 	return nil
 }
 
 // GetExternalIP confirms that the named External IP exists
-func (gce *GCEClient) GetExternalIP(region, name string) (*compute.Address, error) {
+func (gce *GCEClient) GetExternalIP(ctx context.Context, region, name string) (*compute.Address, error) {
 	//blerg, err := gce.service.Addresses.List(gce.projectID, region).Do()
 
-	a, err := gce.service.Addresses.Get(gce.projectID, region, name).Do()
+	a, err := gce.service.Addresses.Get(gce.projectID, region, name).Context(ctx).Do()
 	if err != nil {
 		if isHTTPErrorCode(err, 404) {
 			return nil, nil
-		} else {
-			panic(err)
 		}
+		return nil, &Error{Op: "get address", Name: name, Err: err}
 	}
 	return a, nil
 }
 
+// ListExternalIPs returns all the addresses reserved in a region
+func (gce *GCEClient) ListExternalIPs(ctx context.Context, region string) ([]*compute.Address, error) {
+	items := []*compute.Address{}
+	err := gce.service.Addresses.List(gce.projectID, region).Pages(ctx, func(l *compute.AddressList) error {
+		items = append(items, l.Items...)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
 // CreateExternalIP creates a new external IP to be used with LB
-func (gce *GCEClient) CreateExternalIP(region, name string) (*compute.Address, error) {
+func (gce *GCEClient) CreateExternalIP(ctx context.Context, region, name, description string) (*compute.Address, error) {
+	return gce.ReserveExternalIP(ctx, region, name, "", description)
+}
+
+// ReserveExternalIP reserves a named external IP, promoting ip to a static
+// address when it is set.
+func (gce *GCEClient) ReserveExternalIP(ctx context.Context, region, name, ip, description string) (*compute.Address, error) {
 	address := &compute.Address{
-		Name:   name,
-		Region: region,
+		Name:        name,
+		Region:      region,
+		Address:     ip,
+		Description: description,
 	}
-	op, err := gce.service.Addresses.Insert(gce.projectID, region, address).Do()
+	op, err := gce.service.Addresses.Insert(gce.projectID, region, address).Context(ctx).Do()
 	if err != nil {
 		return nil, err
 	}
-	if err = gce.waitForRegionOp(op, region); err != nil {
+	if err = gce.waitForRegionOp(ctx, op, region); err != nil {
 		return nil, err
 	}
-	a, err := gce.GetExternalIP(region, name)
+	a, err := gce.GetExternalIP(ctx, region, name)
 	if err != nil {
 		return nil, err
 	}
@@ -244,101 +340,139 @@ func (gce *GCEClient) CreateExternalIP(region, name string) (*compute.Address, e
 }
 
 // RemoveExternalIP deletes the ExternalIP by name.
-func (gce *GCEClient) RemoveExternalIP(name, region string) error {
-	op, err := gce.service.Addresses.Delete(gce.projectID, region, name).Do()
+func (gce *GCEClient) RemoveExternalIP(ctx context.Context, name, region string) error {
+	op, err := gce.service.Addresses.Delete(gce.projectID, region, name).Context(ctx).Do()
 	if err != nil {
 		if isHTTPErrorCode(err, http.StatusNotFound) {
 			return nil
 		}
 		return err
 	}
-	return gce.waitForRegionOp(op, region)
+	return gce.waitForRegionOp(ctx, op, region)
 }
 
 // GetTargetPool gets the list of instances in a targetpool
-func (gce *GCEClient) GetTargetPool(region, name string) (*compute.TargetPool, error) {
-	tp, err := gce.service.TargetPools.Get(gce.projectID, region, name).Do()
+func (gce *GCEClient) GetTargetPool(ctx context.Context, region, name string) (*compute.TargetPool, error) {
+	tp, err := gce.service.TargetPools.Get(gce.projectID, region, name).Context(ctx).Do()
 	if err != nil {
 		if isHTTPErrorCode(err, 404) {
 			return nil, nil
 		}
+		return nil, &Error{Op: "get target pool", Name: name, Err: err}
 	}
 	return tp, nil
 }
 
 //CreateTargetPool creates a targetpool
-func (gce *GCEClient) CreateTargetPool(region, name string, instances []string) (*compute.TargetPool, error) {
+func (gce *GCEClient) CreateTargetPool(ctx context.Context, region, name string, instances []string, description string) (*compute.TargetPool, error) {
 	rule := &compute.TargetPool{
-		Name:      name,
-		Region:    region,
-		Instances: instances,
+		Name:        name,
+		Region:      region,
+		Instances:   instances,
+		Description: description,
 	}
-	op, err := gce.service.TargetPools.Insert(gce.projectID, region, rule).Do()
+	op, err := gce.service.TargetPools.Insert(gce.projectID, region, rule).Context(ctx).Do()
 	if err != nil {
 		return nil, err
 	}
-	if err = gce.waitForRegionOp(op, region); err != nil {
+	if err = gce.waitForRegionOp(ctx, op, region); err != nil {
 		return nil, err
 	}
-	tp, err := gce.GetTargetPool(region, name)
+	tp, err := gce.GetTargetPool(ctx, region, name)
 	if err != nil {
 		return nil, err
 	}
 	return tp, nil
 }
 
+// ListTargetPools returns all the target pools in a region
+func (gce *GCEClient) ListTargetPools(ctx context.Context, region string) ([]*compute.TargetPool, error) {
+	items := []*compute.TargetPool{}
+	err := gce.service.TargetPools.List(gce.projectID, region).Pages(ctx, func(l *compute.TargetPoolList) error {
+		items = append(items, l.Items...)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+// GetTargetPoolHealth returns the health of an instance as seen by the targetpool's health checks
+func (gce *GCEClient) GetTargetPoolHealth(ctx context.Context, region, name, instance string) ([]*compute.HealthStatus, error) {
+	h, err := gce.service.TargetPools.GetHealth(gce.projectID, region, name, &compute.InstanceReference{Instance: instance}).Context(ctx).Do()
+	if err != nil {
+		return nil, err
+	}
+	return h.HealthStatus, nil
+}
+
 // RemoveTargetPool deletes the TargetPool by name.
-func (gce *GCEClient) RemoveTargetPool(name, region string) error {
-	op, err := gce.service.TargetPools.Delete(gce.projectID, region, name).Do()
+func (gce *GCEClient) RemoveTargetPool(ctx context.Context, name, region string) error {
+	op, err := gce.service.TargetPools.Delete(gce.projectID, region, name).Context(ctx).Do()
 	if err != nil {
 		if isHTTPErrorCode(err, http.StatusNotFound) {
 			return nil
 		}
 		return err
 	}
-	return gce.waitForRegionOp(op, region)
+	return gce.waitForRegionOp(ctx, op, region)
 }
 
 // GetAvailableZones returns all available zones for this project
-func (gce *GCEClient) GetAvailableZones() (*compute.ZoneList, error) {
-	return gce.service.Zones.List(gce.projectID).Do()
+func (gce *GCEClient) GetAvailableZones(ctx context.Context) (*compute.ZoneList, error) {
+	return gce.service.Zones.List(gce.projectID).Context(ctx).Do()
 }
 
-func (gce *GCEClient) GetForwardingRule(region, name string) (*compute.ForwardingRule, error) {
-	fr, err := gce.service.ForwardingRules.Get(gce.projectID, region, name).Do()
+func (gce *GCEClient) GetForwardingRule(ctx context.Context, region, name string) (*compute.ForwardingRule, error) {
+	fr, err := gce.service.ForwardingRules.Get(gce.projectID, region, name).Context(ctx).Do()
 	if err != nil {
 		if isHTTPErrorCode(err, 404) {
 			return nil, nil
-		} else {
-			panic(err)
 		}
+		return nil, &Error{Op: "get forwarding rule", Name: name, Err: err}
 	}
-	return fr, err
+	return fr, nil
 }
 
-// CreateForwardingRule creates and returns a GlobalForwardingRule that points to the given TargetHttpProxy.
-func (gce *GCEClient) CreateForwardingRule(region, name, address, port string) (*compute.ForwardingRule, error) {
+// ListForwardingRules returns all the forwarding rules in a region
+func (gce *GCEClient) ListForwardingRules(ctx context.Context, region string) ([]*compute.ForwardingRule, error) {
+	items := []*compute.ForwardingRule{}
+	err := gce.service.ForwardingRules.List(gce.projectID, region).Pages(ctx, func(l *compute.ForwardingRuleList) error {
+		items = append(items, l.Items...)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+// CreateForwardingRule creates and returns a forwarding rule for protocol (TCP
+// or UDP) and a port or port range that points to the named target pool.
+func (gce *GCEClient) CreateForwardingRule(ctx context.Context, region, name, address, protocol, port, description string) (*compute.ForwardingRule, error) {
 	//thp, _ := gce.GetTargetHttpProxy(name)
-	t, _ := gce.GetTargetPool(region, name)
+	t, _ := gce.GetTargetPool(ctx, region, name)
 	if t == nil {
 		return nil, fmt.Errorf("Could not get targetpool %s", name)
 	}
 	rule := &compute.ForwardingRule{
-		Name:       name,
-		IPProtocol: "TCP",
-		PortRange:  port,
-		Target:     t.SelfLink,
-		IPAddress:  address,
-	}
-	op, err := gce.service.ForwardingRules.Insert(gce.projectID, region, rule).Do()
+		Name:        name,
+		Description: description,
+		IPProtocol:  strings.ToUpper(protocol),
+		PortRange:   port,
+		Target:      t.SelfLink,
+		IPAddress:   address,
+	}
+	op, err := gce.service.ForwardingRules.Insert(gce.projectID, region, rule).Context(ctx).Do()
 	//fmt.Printf("op %v", op)
 	if err != nil {
 		return nil, err
 	}
-	if err = gce.waitForRegionOp(op, region); err != nil {
+	if err = gce.waitForRegionOp(ctx, op, region); err != nil {
 		return nil, err
 	}
-	fr, err := gce.GetForwardingRule(region, name)
+	fr, err := gce.GetForwardingRule(ctx, region, name)
 	if err != nil {
 		return nil, err
 	}
@@ -346,29 +480,32 @@ func (gce *GCEClient) CreateForwardingRule(region, name, address, port string) (
 }
 
 // RemoveForwardingRule deletes the GlobalForwardingRule by name.
-func (gce *GCEClient) RemoveForwardingRule(name, region string) error {
-	op, err := gce.service.ForwardingRules.Delete(gce.projectID, region, name).Do()
+func (gce *GCEClient) RemoveForwardingRule(ctx context.Context, name, region string) error {
+	op, err := gce.service.ForwardingRules.Delete(gce.projectID, region, name).Context(ctx).Do()
 	if err != nil {
 		if isHTTPErrorCode(err, http.StatusNotFound) {
 			return nil
 		}
 		return err
 	}
-	return gce.waitForRegionOp(op, region)
+	return gce.waitForRegionOp(ctx, op, region)
 }
 
 // makeFirewallObject returns a pre-populated instance of *computeFirewall
-func (gce *GCEClient) makeFirewallObject(name, network string, tags []string, port string) (*compute.Firewall, error) {
+func (gce *GCEClient) makeFirewallObject(name, network string, tags []string, protocol string, ports, sourceRanges []string, description string) (*compute.Firewall, error) {
+	if len(sourceRanges) == 0 {
+		sourceRanges = []string{"0.0.0.0/0"} // allow load-balancers alone
+	}
 	firewall := &compute.Firewall{
 		Name:         name,
-		Description:  "Generated by gcp-lb-tags",
+		Description:  description,
 		Network:      makeNetworkURL(gce.projectID, network),
 		TargetTags:   tags,
-		SourceRanges: []string{"0.0.0.0/0"}, // allow load-balancers alone
+		SourceRanges: sourceRanges,
 		Allowed: []*compute.FirewallAllowed{
 			{
-				IPProtocol: "tcp",
-				Ports:      []string{port},
+				IPProtocol: strings.ToLower(protocol),
+				Ports:      ports,
 			},
 		},
 	}
@@ -378,8 +515,8 @@ func (gce *GCEClient) makeFirewallObject(name, network string, tags []string, po
 // Backend Services
 
 // GetBackendService retrieves a backend by name.
-func (gce *GCEClient) GetBackendService(name string) (*compute.BackendService, error) {
-	bs, err := gce.service.BackendServices.Get(gce.projectID, name).Do()
+func (gce *GCEClient) GetBackendService(ctx context.Context, name string) (*compute.BackendService, error) {
+	bs, err := gce.service.BackendServices.Get(gce.projectID, name).Context(ctx).Do()
 	if err != nil {
 		if isHTTPErrorCode(err, 404) {
 			return nil, nil
@@ -391,14 +528,14 @@ func (gce *GCEClient) GetBackendService(name string) (*compute.BackendService, e
 }
 
 // CreateBackendService creates the given BackendService.
-func (gce *GCEClient) CreateBackendService(name string, port string, zones []string) error {
+func (gce *GCEClient) CreateBackendService(ctx context.Context, name string, port string, zones []string) error {
 
 	// prepare backends
 	var backends []*compute.Backend
 	// one backend (instance group) per zone
 	for _, zone := range zones {
 		// instance groups have been previously zonified
-		ig, _ := gce.GetInstanceGroup(gce.projectID, zone, name)
+		ig, _ := gce.GetInstanceGroup(ctx, gce.projectID, zone, name)
 		if ig != nil {
 			backends = append(backends, &compute.Backend{
 				Description: zone,
@@ -406,7 +543,7 @@ func (gce *GCEClient) CreateBackendService(name string, port string, zones []str
 			})
 		}
 	}
-	hc, _ := gce.GetHealthCheck(name, port)
+	hc, _ := gce.GetHealthCheck(ctx, name, port)
 
 	bsPort, err := strconv.ParseInt(port, 10, 64)
 	if err != nil {
@@ -424,15 +561,15 @@ func (gce *GCEClient) CreateBackendService(name string, port string, zones []str
 		Protocol:     "TCP",
 		TimeoutSec:   10, // TODO make configurable
 	}
-	op, err := gce.service.BackendServices.Insert(gce.projectID, bs).Do()
+	op, err := gce.service.BackendServices.Insert(gce.projectID, bs).Context(ctx).Do()
 	if err != nil {
 		return err
 	}
-	return gce.waitForGlobalOp(op)
+	return gce.waitForGlobalOp(ctx, op)
 }
 
 // UpdateBackendService applies the given BackendService as an update to an existing service.
-func (gce *GCEClient) UpdateBackendService(name string, port string, zones []string) error {
+func (gce *GCEClient) UpdateBackendService(ctx context.Context, name string, port string, zones []string) error {
 	bsName := name
 
 	// prepare backends
@@ -440,7 +577,7 @@ func (gce *GCEClient) UpdateBackendService(name string, port string, zones []str
 	// one backend (instance group) per zone
 	for _, zone := range zones {
 		// instance groups have been previously zonified
-		ig, _ := gce.GetInstanceGroup(gce.projectID, zone, name)
+		ig, _ := gce.GetInstanceGroup(ctx, gce.projectID, zone, name)
 		if ig != nil {
 			backends = append(backends, &compute.Backend{
 				Description: zone,
@@ -449,7 +586,7 @@ func (gce *GCEClient) UpdateBackendService(name string, port string, zones []str
 		}
 	}
 
-	hc, _ := gce.GetHealthCheck(name, port)
+	hc, _ := gce.GetHealthCheck(ctx, name, port)
 
 	bsPort, err := strconv.ParseInt(port, 10, 64)
 	if err != nil {
@@ -457,7 +594,7 @@ func (gce *GCEClient) UpdateBackendService(name string, port string, zones []str
 	}
 
 	// need to get the fingerprint of the existing to update
-	exist, err := gce.GetBackendService(bsName)
+	exist, err := gce.GetBackendService(ctx, bsName)
 	if err != nil {
 		return err
 	}
@@ -475,35 +612,35 @@ func (gce *GCEClient) UpdateBackendService(name string, port string, zones []str
 		Fingerprint:  exist.Fingerprint,
 	}
 
-	op, err := gce.service.BackendServices.Update(gce.projectID, bsName, bs).Do()
+	op, err := gce.service.BackendServices.Update(gce.projectID, bsName, bs).Context(ctx).Do()
 	if err != nil {
 		return err
 	}
-	return gce.waitForGlobalOp(op)
+	return gce.waitForGlobalOp(ctx, op)
 }
 
 // RemoveBackendService deletes the given BackendService by name.
-func (gce *GCEClient) RemoveBackendService(name string) error {
+func (gce *GCEClient) RemoveBackendService(ctx context.Context, name string) error {
 	bsName := name
-	op, err := gce.service.BackendServices.Delete(gce.projectID, bsName).Do()
+	op, err := gce.service.BackendServices.Delete(gce.projectID, bsName).Context(ctx).Do()
 	if err != nil {
 		if isHTTPErrorCode(err, http.StatusNotFound) {
 			return nil
 		}
 		return err
 	}
-	return gce.waitForGlobalOp(op)
+	return gce.waitForGlobalOp(ctx, op)
 }
 
 // healthcheck management
 // GetHealthCheck returns the given HttpHealthCheck by name.
-func (gce *GCEClient) GetHealthCheck(name, port string) (*compute.HealthCheck, error) {
+func (gce *GCEClient) GetHealthCheck(ctx context.Context, name, port string) (*compute.HealthCheck, error) {
 	hcName := name
-	return gce.service.HealthChecks.Get(gce.projectID, hcName).Do()
+	return gce.service.HealthChecks.Get(gce.projectID, hcName).Context(ctx).Do()
 }
 
 // CreateHealthCheck creates the given HttpHealthCheck.
-func (gce *GCEClient) CreateHealthCheck(name string, port string) error {
+func (gce *GCEClient) CreateHealthCheck(ctx context.Context, name string, port string) error {
 	hcName := name
 	hcPort, err := strconv.ParseInt(port, 10, 64)
 	if err != nil {
@@ -514,15 +651,15 @@ func (gce *GCEClient) CreateHealthCheck(name string, port string) error {
 		Type:           "TCP",
 		TcpHealthCheck: &compute.TCPHealthCheck{Port: hcPort},
 	}
-	op, err := gce.service.HealthChecks.Insert(gce.projectID, hc).Do()
+	op, err := gce.service.HealthChecks.Insert(gce.projectID, hc).Context(ctx).Do()
 	if err != nil {
 		return err
 	}
-	return gce.waitForGlobalOp(op)
+	return gce.waitForGlobalOp(ctx, op)
 }
 
 // UpdateHealthCheck applies the given HttpHealthCheck as an update.
-func (gce *GCEClient) UpdateHealthCheck(name string, port string) error {
+func (gce *GCEClient) UpdateHealthCheck(ctx context.Context, name string, port string) error {
 	hcName := name
 	hcPort, err := strconv.ParseInt(port, 10, 64)
 	if err != nil {
@@ -534,41 +671,134 @@ func (gce *GCEClient) UpdateHealthCheck(name string, port string) error {
 		Type:           "TCP",
 		TcpHealthCheck: &compute.TCPHealthCheck{Port: hcPort},
 	}
-	op, err := gce.service.HealthChecks.Update(gce.projectID, hcName, hc).Do()
+	op, err := gce.service.HealthChecks.Update(gce.projectID, hcName, hc).Context(ctx).Do()
 	if err != nil {
 		return err
 	}
-	return gce.waitForGlobalOp(op)
+	return gce.waitForGlobalOp(ctx, op)
 }
 
 // RemoveHealthCheck deletes the given HttpHealthCheck by name.
-func (gce *GCEClient) RemoveHealthCheck(name string) error {
+func (gce *GCEClient) RemoveHealthCheck(ctx context.Context, name string) error {
 	hcName := name
-	op, err := gce.service.HealthChecks.Delete(gce.projectID, hcName).Do()
+	op, err := gce.service.HealthChecks.Delete(gce.projectID, hcName).Context(ctx).Do()
+	if err != nil {
+		if isHTTPErrorCode(err, http.StatusNotFound) {
+			return nil
+		}
+		return err
+	}
+	return gce.waitForGlobalOp(ctx, op)
+}
+
+// Legacy HTTP health checks, the only kind target pools accept
+
+// GetHTTPHealthCheck returns the given HttpHealthCheck by name.
+func (gce *GCEClient) GetHTTPHealthCheck(ctx context.Context, name string) (*compute.HttpHealthCheck, error) {
+	hc, err := gce.service.HttpHealthChecks.Get(gce.projectID, name).Context(ctx).Do()
+	if err != nil {
+		if isHTTPErrorCode(err, http.StatusNotFound) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return hc, nil
+}
+
+func makeHTTPHealthCheckObject(name, port, path, description string) (*compute.HttpHealthCheck, error) {
+	hcPort, err := strconv.ParseInt(port, 10, 64)
+	if err != nil {
+		return nil, err
+	}
+	return &compute.HttpHealthCheck{
+		Name:        name,
+		Description: description,
+		Port:        hcPort,
+		RequestPath: path,
+	}, nil
+}
+
+// CreateHTTPHealthCheck creates the given HttpHealthCheck.
+func (gce *GCEClient) CreateHTTPHealthCheck(ctx context.Context, name, port, path, description string) (*compute.HttpHealthCheck, error) {
+	hc, err := makeHTTPHealthCheckObject(name, port, path, description)
+	if err != nil {
+		return nil, err
+	}
+	op, err := gce.service.HttpHealthChecks.Insert(gce.projectID, hc).Context(ctx).Do()
+	if err != nil {
+		return nil, err
+	}
+	if err = gce.waitForGlobalOp(ctx, op); err != nil {
+		return nil, err
+	}
+	return gce.GetHTTPHealthCheck(ctx, name)
+}
+
+// UpdateHTTPHealthCheck applies the given port and path to an existing HttpHealthCheck.
+func (gce *GCEClient) UpdateHTTPHealthCheck(ctx context.Context, name, port, path, description string) error {
+	hc, err := makeHTTPHealthCheckObject(name, port, path, description)
+	if err != nil {
+		return err
+	}
+	op, err := gce.service.HttpHealthChecks.Patch(gce.projectID, name, hc).Context(ctx).Do()
+	if err != nil {
+		return err
+	}
+	return gce.waitForGlobalOp(ctx, op)
+}
+
+// RemoveHTTPHealthCheck deletes the given HttpHealthCheck by name.
+func (gce *GCEClient) RemoveHTTPHealthCheck(ctx context.Context, name string) error {
+	op, err := gce.service.HttpHealthChecks.Delete(gce.projectID, name).Context(ctx).Do()
 	if err != nil {
 		if isHTTPErrorCode(err, http.StatusNotFound) {
 			return nil
 		}
 		return err
 	}
-	return gce.waitForGlobalOp(op)
+	return gce.waitForGlobalOp(ctx, op)
+}
+
+// AddHealthCheckToTargetPool attaches an HttpHealthCheck to the targetpool
+func (gce *GCEClient) AddHealthCheckToTargetPool(ctx context.Context, region, name, healthCheck string) error {
+	add := &compute.TargetPoolsAddHealthCheckRequest{
+		HealthChecks: []*compute.HealthCheckReference{{HealthCheck: healthCheck}},
+	}
+	op, err := gce.service.TargetPools.AddHealthCheck(gce.projectID, region, name, add).Context(ctx).Do()
+	if err != nil {
+		return err
+	}
+	return gce.waitForRegionOp(ctx, op, region)
 }
 
 // Firewall rules management
 
-// CreateFirewall creates a global firewall rule
-func (gce *GCEClient) CreateFirewall(name string, network string, port string, tags []string) error {
+// GetFirewall returns a global firewall rule by name
+func (gce *GCEClient) GetFirewall(ctx context.Context, name string) (*compute.Firewall, error) {
+	fw, err := gce.service.Firewalls.Get(gce.projectID, name).Context(ctx).Do()
+	if err != nil {
+		if isHTTPErrorCode(err, http.StatusNotFound) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return fw, nil
+}
+
+// CreateFirewall creates a global firewall rule admitting protocol on ports
+// from sourceRanges, or from anywhere when sourceRanges is empty.
+func (gce *GCEClient) CreateFirewall(ctx context.Context, name string, network string, protocol string, ports []string, tags, sourceRanges []string, description string) error {
 	fwName := name
-	firewall, err := gce.makeFirewallObject(fwName, network, tags, port)
+	firewall, err := gce.makeFirewallObject(fwName, network, tags, protocol, ports, sourceRanges, description)
 	if err != nil {
 		return err
 	}
-	op, err := gce.service.Firewalls.Insert(gce.projectID, firewall).Do()
+	op, err := gce.service.Firewalls.Insert(gce.projectID, firewall).Context(ctx).Do()
 	if err != nil && !isHTTPErrorCode(err, http.StatusConflict) {
 		return err
 	}
 	if op != nil {
-		err = gce.waitForGlobalOp(op)
+		err = gce.waitForGlobalOp(ctx, op)
 		if err != nil && !isHTTPErrorCode(err, http.StatusConflict) {
 			return err
 		}
@@ -577,18 +807,18 @@ func (gce *GCEClient) CreateFirewall(name string, network string, port string, t
 }
 
 // UpdateFirewall updates a global firewall rule
-func (gce *GCEClient) UpdateFirewall(name string, network string, port string, tags []string) error {
+func (gce *GCEClient) UpdateFirewall(ctx context.Context, name string, network string, protocol string, ports []string, tags, sourceRanges []string, description string) error {
 	fwName := name
-	firewall, err := gce.makeFirewallObject(fwName, network, tags, port)
+	firewall, err := gce.makeFirewallObject(fwName, network, tags, protocol, ports, sourceRanges, description)
 	if err != nil {
 		return err
 	}
-	op, err := gce.service.Firewalls.Update(gce.projectID, fwName, firewall).Do()
+	op, err := gce.service.Firewalls.Update(gce.projectID, fwName, firewall).Context(ctx).Do()
 	if err != nil && !isHTTPErrorCode(err, http.StatusConflict) {
 		return err
 	}
 	if op != nil {
-		err = gce.waitForGlobalOp(op)
+		err = gce.waitForGlobalOp(ctx, op)
 		if err != nil {
 			return err
 		}
@@ -596,17 +826,39 @@ func (gce *GCEClient) UpdateFirewall(name string, network string, port string, t
 	return nil
 }
 
+// ListFirewalls returns all the global firewall rules
+func (gce *GCEClient) ListFirewalls(ctx context.Context) ([]*compute.Firewall, error) {
+	items := []*compute.Firewall{}
+	err := gce.service.Firewalls.List(gce.projectID).Pages(ctx, func(l *compute.FirewallList) error {
+		items = append(items, l.Items...)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+// SetFirewallDescription replaces only the description of a global firewall rule
+func (gce *GCEClient) SetFirewallDescription(ctx context.Context, name, description string) error {
+	op, err := gce.service.Firewalls.Patch(gce.projectID, name, &compute.Firewall{Description: description}).Context(ctx).Do()
+	if err != nil {
+		return err
+	}
+	return gce.waitForGlobalOp(ctx, op)
+}
+
 // RemoveFirewall removes a global firewall rule
-func (gce *GCEClient) RemoveFirewall(name string) error {
+func (gce *GCEClient) RemoveFirewall(ctx context.Context, name string) error {
 	fwName := name
-	op, err := gce.service.Firewalls.Delete(gce.projectID, fwName).Do()
+	op, err := gce.service.Firewalls.Delete(gce.projectID, fwName).Context(ctx).Do()
 	if err != nil && isHTTPErrorCode(err, http.StatusNotFound) {
 		glog.Infof("Firewall %s already deleted. Continuing to delete other resources.", fwName)
 	} else if err != nil {
 		glog.Warningf("Failed to delete firewall %s, got error %v", fwName, err)
 		return err
 	} else {
-		if err := gce.waitForGlobalOp(op); err != nil {
+		if err := gce.waitForGlobalOp(ctx, op); err != nil {
 			glog.Warningf("Failed waiting for Firewall %s to be deleted.  Got error: %v", fwName, err)
 			return err
 		}
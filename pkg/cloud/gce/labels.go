@@ -0,0 +1,140 @@
+package gce
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	neturl "net/url"
+
+	compute "google.golang.org/api/compute/v1"
+	"google.golang.org/api/googleapi"
+)
+
+// The vendored compute/v1 client predates labels on addresses and forwarding
+// rules, so these calls talk to the REST endpoints directly.
+
+type resourceLabels struct {
+	Labels           map[string]string `json:"labels,omitempty"`
+	LabelFingerprint string            `json:"labelFingerprint,omitempty"`
+}
+
+func (gce *GCEClient) regionResourceURL(region, collection, name string) string {
+	return gce.service.BasePath + gce.projectID + "/regions/" + region + "/" + collection + "/" + name
+}
+
+// GetAddressLabels returns the labels and label fingerprint of an external address.
+func (gce *GCEClient) GetAddressLabels(ctx context.Context, region, name string) (map[string]string, string, error) {
+	return gce.getLabels(ctx, gce.regionResourceURL(region, "addresses", name))
+}
+
+// SetAddressLabels replaces the labels of an external address.
+func (gce *GCEClient) SetAddressLabels(ctx context.Context, region, name string, labels map[string]string, fingerprint string) error {
+	op, err := gce.setLabels(ctx, gce.regionResourceURL(region, "addresses", name), labels, fingerprint)
+	if err != nil {
+		return err
+	}
+	return gce.waitForRegionOp(ctx, op, region)
+}
+
+// GetForwardingRuleLabels returns the labels and label fingerprint of a forwarding rule.
+func (gce *GCEClient) GetForwardingRuleLabels(ctx context.Context, region, name string) (map[string]string, string, error) {
+	return gce.getLabels(ctx, gce.regionResourceURL(region, "forwardingRules", name))
+}
+
+// SetForwardingRuleLabels replaces the labels of a forwarding rule.
+func (gce *GCEClient) SetForwardingRuleLabels(ctx context.Context, region, name string, labels map[string]string, fingerprint string) error {
+	op, err := gce.setLabels(ctx, gce.regionResourceURL(region, "forwardingRules", name), labels, fingerprint)
+	if err != nil {
+		return err
+	}
+	return gce.waitForRegionOp(ctx, op, region)
+}
+
+// ListAddressLabels returns the labels of every external address in a region, keyed by name.
+func (gce *GCEClient) ListAddressLabels(ctx context.Context, region string) (map[string]map[string]string, error) {
+	return gce.listLabels(ctx, gce.service.BasePath+gce.projectID+"/regions/"+region+"/addresses")
+}
+
+// ListForwardingRuleLabels returns the labels of every forwarding rule in a region, keyed by name.
+func (gce *GCEClient) ListForwardingRuleLabels(ctx context.Context, region string) (map[string]map[string]string, error) {
+	return gce.listLabels(ctx, gce.service.BasePath+gce.projectID+"/regions/"+region+"/forwardingRules")
+}
+
+func (gce *GCEClient) listLabels(ctx context.Context, url string) (map[string]map[string]string, error) {
+	labels := map[string]map[string]string{}
+	token := ""
+	for {
+		page := url
+		if token != "" {
+			page += "?pageToken=" + neturl.QueryEscape(token)
+		}
+		next, err := gce.listLabelsPage(ctx, page, labels)
+		if err != nil {
+			return nil, err
+		}
+		if next == "" {
+			return labels, nil
+		}
+		token = next
+	}
+}
+
+// listLabelsPage adds the labels of one page of url to labels and returns
+// the token of the next page, empty on the last one.
+func (gce *GCEClient) listLabelsPage(ctx context.Context, url string, labels map[string]map[string]string) (string, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return "", err
+	}
+	res, err := gce.client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer res.Body.Close()
+	if err := googleapi.CheckResponse(res); err != nil {
+		return "", err
+	}
+	var l struct {
+		Items []struct {
+			Name   string            `json:"name"`
+			Labels map[string]string `json:"labels"`
+		} `json:"items"`
+		NextPageToken string `json:"nextPageToken"`
+	}
+	if err := json.NewDecoder(res.Body).Decode(&l); err != nil {
+		return "", err
+	}
+	for _, i := range l.Items {
+		labels[i.Name] = i.Labels
+	}
+	return l.NextPageToken, nil
+}
+
+func (gce *GCEClient) getLabels(ctx context.Context, url string) (map[string]string, string, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, "", err
+	}
+	res, err := gce.client.Do(req)
+	if err != nil {
+		return nil, "", err
+	}
+	defer res.Body.Close()
+	if err := googleapi.CheckResponse(res); err != nil {
+		return nil, "", err
+	}
+	var l resourceLabels
+	if err := json.NewDecoder(res.Body).Decode(&l); err != nil {
+		return nil, "", err
+	}
+	return l.Labels, l.LabelFingerprint, nil
+}
+
+func (gce *GCEClient) setLabels(ctx context.Context, url string, labels map[string]string, fingerprint string) (*compute.Operation, error) {
+	body, err := json.Marshal(&resourceLabels{Labels: labels, LabelFingerprint: fingerprint})
+	if err != nil {
+		return nil, err
+	}
+	return gce.postOperation(ctx, url+"/setLabels", bytes.NewReader(body))
+}
@@ -1,34 +1,78 @@
 package gce
 
 import (
+	"context"
+	"encoding/json"
 	"fmt"
+	"io"
+	"net/http"
 	"time"
 
 	compute "google.golang.org/api/compute/v1"
 	"google.golang.org/api/googleapi"
-	"k8s.io/apimachinery/pkg/util/wait"
 )
 
 const (
-	operationPollInterval        = 3 * time.Second
-	operationPollTimeoutDuration = 30 * time.Minute
+	operationPollInterval = 3 * time.Second
+	// maxPollErrors retryable errors in a row give up on an operation
+	maxPollErrors = 5
+	// DefaultOperationTimeout is how long an operation is waited for when
+	// the client isn't given a timeout.
+	DefaultOperationTimeout = 30 * time.Minute
 )
 
-func (gce *GCEClient) waitForGlobalOp(op *compute.Operation) error {
-	return waitForOp(op, func(operationName string) (*compute.Operation, error) {
-		return gce.service.GlobalOperations.Get(gce.projectID, operationName).Do()
+// OperationTracker is told about the operations a client waits for, so they
+// can be picked up again when the process dies while waiting.
+type OperationTracker interface {
+	// Started is called before waiting for an operation that isn't done.
+	Started(op *compute.Operation)
+	// Finished is called once the operation is seen to be done.
+	Finished(op *compute.Operation)
+}
+
+type trackerKey struct{}
+
+// WithOperationTracker returns a context whose operations are reported to t.
+func WithOperationTracker(ctx context.Context, t OperationTracker) context.Context {
+	return context.WithValue(ctx, trackerKey{}, t)
+}
+
+func trackerFrom(ctx context.Context) OperationTracker {
+	t, _ := ctx.Value(trackerKey{}).(OperationTracker)
+	return t
+}
+
+// WaitForOperation waits for the operation at selfLink, such as one left
+// pending by an earlier run, and returns its error. It returns false when
+// GCE no longer knows the operation.
+func (gce *GCEClient) WaitForOperation(ctx context.Context, selfLink string) (bool, error) {
+	op, err := gce.getOperation(ctx, selfLink)
+	if err != nil {
+		if isHTTPErrorCode(err, http.StatusNotFound) {
+			return false, nil
+		}
+		return true, &Error{Op: "get operation", Name: selfLink, Err: err}
+	}
+	return true, gce.waitForOp(ctx, op, func(ctx context.Context, operationName string) (*compute.Operation, error) {
+		return gce.getOperation(ctx, selfLink)
+	})
+}
+
+func (gce *GCEClient) waitForGlobalOp(ctx context.Context, op *compute.Operation) error {
+	return gce.waitForOp(ctx, op, func(ctx context.Context, operationName string) (*compute.Operation, error) {
+		return gce.service.GlobalOperations.Get(gce.projectID, operationName).Context(ctx).Do()
 	})
 }
 
-func (gce *GCEClient) waitForRegionOp(op *compute.Operation, region string) error {
-	return waitForOp(op, func(operationName string) (*compute.Operation, error) {
-		return gce.service.RegionOperations.Get(gce.projectID, region, operationName).Do()
+func (gce *GCEClient) waitForRegionOp(ctx context.Context, op *compute.Operation, region string) error {
+	return gce.waitForOp(ctx, op, func(ctx context.Context, operationName string) (*compute.Operation, error) {
+		return gce.service.RegionOperations.Get(gce.projectID, region, operationName).Context(ctx).Do()
 	})
 }
 
-func (gce *GCEClient) waitForZoneOp(op *compute.Operation, zone string) error {
-	return waitForOp(op, func(operationName string) (*compute.Operation, error) {
-		return gce.service.ZoneOperations.Get(gce.projectID, zone, operationName).Do()
+func (gce *GCEClient) waitForZoneOp(ctx context.Context, op *compute.Operation, zone string) error {
+	return gce.waitForOp(ctx, op, func(ctx context.Context, operationName string) (*compute.Operation, error) {
+		return gce.service.ZoneOperations.Get(gce.projectID, zone, operationName).Context(ctx).Do()
 	})
 }
 
@@ -37,7 +81,13 @@ func isHTTPErrorCode(err error, code int) bool {
 	return ok && apiErr.Code == code
 }
 
-func waitForOp(op *compute.Operation, getOperation func(operationName string) (*compute.Operation, error)) error {
+// waitForOp waits for op to finish, the operation timeout to pass or ctx to
+// be cancelled. It long polls the operation's wait endpoint, which returns as
+// soon as the operation is done, and falls back to getting the operation
+// every operationPollInterval when the endpoint can't be used. Retryable
+// errors are retried up to maxPollErrors times in a row, anything else is
+// returned straight away.
+func (gce *GCEClient) waitForOp(ctx context.Context, op *compute.Operation, getOperation func(ctx context.Context, operationName string) (*compute.Operation, error)) error {
 	if op == nil {
 		return fmt.Errorf("operation must not be nil")
 	}
@@ -46,14 +96,101 @@ func waitForOp(op *compute.Operation, getOperation func(operationName string) (*
 		return getErrorFromOp(op)
 	}
 
+	tracker := trackerFrom(ctx)
+	if tracker != nil {
+		tracker.Started(op)
+	}
+	ctx, cancel := context.WithTimeout(ctx, gce.operationTimeout)
+	defer cancel()
 	opName := op.Name
-	return wait.Poll(operationPollInterval, operationPollTimeoutDuration, func() (bool, error) {
-		pollOp, err := getOperation(opName)
-		if err != nil {
-			fmt.Printf("GCE poll operation failed: %v\n", err)
+	useWait := op.SelfLink != ""
+	failures := 0
+	for {
+		var pollOp *compute.Operation
+		var err error
+		if useWait {
+			pollOp, err = gce.postOperation(ctx, op.SelfLink+"/wait", nil)
+			if err != nil && ctx.Err() == nil && !IsRetryable(err) {
+				fmt.Printf("GCE operation wait unavailable, polling instead: %v\n", err)
+				useWait = false
+				continue
+			}
+		} else {
+			pollOp, err = getOperation(ctx, opName)
 		}
-		return opIsDone(pollOp), getErrorFromOp(pollOp)
-	})
+		if ctx.Err() != nil {
+			return fmt.Errorf("waiting for operation %s: %w", opName, ctx.Err())
+		}
+
+		delay := operationPollInterval
+		switch {
+		case err != nil && !IsRetryable(err):
+			return &Error{Op: "wait for operation", Name: opName, Err: err}
+		case err != nil:
+			failures++
+			if failures >= maxPollErrors {
+				return &Error{Op: "wait for operation", Name: opName, Err: fmt.Errorf("%d polls failed in a row: %w", failures, err)}
+			}
+			fmt.Printf("GCE poll operation failed, retrying: %v\n", err)
+			delay = time.Duration(failures) * operationPollInterval
+		case opIsDone(pollOp):
+			if tracker != nil {
+				tracker.Finished(pollOp)
+			}
+			return getErrorFromOp(pollOp)
+		default:
+			failures = 0
+			if useWait {
+				// the wait endpoint returned before the operation
+				// finished, wait on it again straight away
+				continue
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("waiting for operation %s: %w", opName, ctx.Err())
+		case <-time.After(delay):
+		}
+	}
+}
+
+// getOperation gets the operation at url.
+func (gce *GCEClient) getOperation(ctx context.Context, url string) (*compute.Operation, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	return gce.doOperation(req)
+}
+
+// postOperation posts body to url, a compute REST endpoint that isn't in the
+// vendored client, and returns the operation it responds with.
+func (gce *GCEClient) postOperation(ctx context.Context, url string, body io.Reader) (*compute.Operation, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, body)
+	if err != nil {
+		return nil, err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	return gce.doOperation(req)
+}
+
+func (gce *GCEClient) doOperation(req *http.Request) (*compute.Operation, error) {
+	res, err := gce.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+	if err := googleapi.CheckResponse(res); err != nil {
+		return nil, err
+	}
+	op := &compute.Operation{}
+	if err := json.NewDecoder(res.Body).Decode(op); err != nil {
+		return nil, err
+	}
+	return op, nil
 }
 
 func opIsDone(op *compute.Operation) bool {
@@ -62,11 +199,14 @@ func opIsDone(op *compute.Operation) bool {
 
 func getErrorFromOp(op *compute.Operation) error {
 	if op != nil && op.Error != nil && len(op.Error.Errors) > 0 {
-		err := &googleapi.Error{
-			Code:    int(op.HttpErrorStatusCode),
-			Message: op.Error.Errors[0].Message,
+		return &OperationError{
+			Name: op.Name,
+			Err: &googleapi.Error{
+				Code:    int(op.HttpErrorStatusCode),
+				Message: op.Error.Errors[0].Message,
+				Errors:  []googleapi.ErrorItem{{Reason: op.Error.Errors[0].Code, Message: op.Error.Errors[0].Message}},
+			},
 		}
-		return fmt.Errorf("GCE operation failed: %v", err)
 	}
 
 	return nil
@@ -0,0 +1,75 @@
+package gce
+
+import (
+	"fmt"
+	"strings"
+)
+
+const (
+	// OwnerMarker identifies resources created or adopted by gcp-lb-tags.
+	OwnerMarker = "gcp-lb-tags"
+
+	ownerLabel    = "managed-by"
+	lbLabel       = "gcp-lb-tags-lb"
+	hashLabel     = "gcp-lb-tags-hash"
+	templateLabel = "gcp-lb-tags-template"
+	groupLabel    = "gcp-lb-tags-group"
+)
+
+// Owner describes the load balancer a managed resource belongs to.
+type Owner struct {
+	LB   string
+	Hash string
+	// Template and Group are set on a discovered load balancer, only in
+	// labels: the template it was rendered from and its group.
+	Template string
+	Group    string
+}
+
+// Description renders the ownership marker for resources that only carry a description.
+func (o Owner) Description() string {
+	return fmt.Sprintf("Generated by %s: %s=%s lb=%s hash=%s", OwnerMarker, ownerLabel, OwnerMarker, o.LB, o.Hash)
+}
+
+// Labels renders the ownership marker for resources that support labels.
+func (o Owner) Labels() map[string]string {
+	labels := map[string]string{
+		ownerLabel: OwnerMarker,
+		lbLabel:    o.LB,
+		hashLabel:  o.Hash,
+	}
+	if o.Template != "" {
+		labels[templateLabel] = o.Template
+		labels[groupLabel] = o.Group
+	}
+	return labels
+}
+
+// OwnerFromDescription parses an ownership marker out of a resource description.
+func OwnerFromDescription(description string) (Owner, bool) {
+	var o Owner
+	managed := false
+	for _, f := range strings.Fields(description) {
+		kv := strings.SplitN(f, "=", 2)
+		if len(kv) != 2 {
+			continue
+		}
+		switch kv[0] {
+		case ownerLabel:
+			managed = kv[1] == OwnerMarker
+		case "lb":
+			o.LB = kv[1]
+		case "hash":
+			o.Hash = kv[1]
+		}
+	}
+	return o, managed && o.LB != ""
+}
+
+// OwnerFromLabels parses an ownership marker out of a resource's labels.
+func OwnerFromLabels(labels map[string]string) (Owner, bool) {
+	if labels[ownerLabel] != OwnerMarker || labels[lbLabel] == "" {
+		return Owner{}, false
+	}
+	return Owner{LB: labels[lbLabel], Hash: labels[hashLabel], Template: labels[templateLabel], Group: labels[groupLabel]}, true
+}
@@ -0,0 +1,117 @@
+package gce
+
+import (
+	"testing"
+)
+
+func TestOwnerFromDescription(t *testing.T) {
+	tests := []struct {
+		name        string
+		description string
+		want        Owner
+		wantManaged bool
+	}{
+		{
+			name:        "rendered marker",
+			description: Owner{LB: "pks-demo", Hash: "0123456789ab"}.Description(),
+			want:        Owner{LB: "pks-demo", Hash: "0123456789ab"},
+			wantManaged: true,
+		},
+		{
+			name:        "marker after other text",
+			description: "master LB managed-by=gcp-lb-tags lb=pks-demo hash=abc",
+			want:        Owner{LB: "pks-demo", Hash: "abc"},
+			wantManaged: true,
+		},
+		{
+			name:        "no hash",
+			description: "managed-by=gcp-lb-tags lb=pks-demo",
+			want:        Owner{LB: "pks-demo"},
+			wantManaged: true,
+		},
+		{
+			name:        "empty",
+			description: "",
+		},
+		{
+			name:        "hand written",
+			description: "load balancer for the demo cluster",
+		},
+		{
+			name:        "another manager",
+			description: "managed-by=terraform lb=pks-demo",
+			want:        Owner{LB: "pks-demo"},
+		},
+		{
+			name:        "no load balancer",
+			description: "managed-by=gcp-lb-tags hash=abc",
+			want:        Owner{Hash: "abc"},
+		},
+	}
+	for _, tt := range tests {
+		got, managed := OwnerFromDescription(tt.description)
+		if managed != tt.wantManaged {
+			t.Errorf("%s: OwnerFromDescription(%q) managed = %v, want %v", tt.name, tt.description, managed, tt.wantManaged)
+		}
+		if managed && got != tt.want {
+			t.Errorf("%s: OwnerFromDescription(%q) = %+v, want %+v", tt.name, tt.description, got, tt.want)
+		}
+	}
+}
+
+func TestOwnerFromLabels(t *testing.T) {
+	tests := []struct {
+		name        string
+		labels      map[string]string
+		want        Owner
+		wantManaged bool
+	}{
+		{
+			name:        "rendered marker",
+			labels:      Owner{LB: "pks-demo", Hash: "abc"}.Labels(),
+			want:        Owner{LB: "pks-demo", Hash: "abc"},
+			wantManaged: true,
+		},
+		{
+			name:        "discovered load balancer",
+			labels:      Owner{LB: "pks-1234", Hash: "abc", Template: "0123456789ab", Group: "service-instance-1234"}.Labels(),
+			want:        Owner{LB: "pks-1234", Hash: "abc", Template: "0123456789ab", Group: "service-instance-1234"},
+			wantManaged: true,
+		},
+		{
+			name:        "other labels kept",
+			labels:      map[string]string{"env": "prod", ownerLabel: OwnerMarker, lbLabel: "pks-demo"},
+			want:        Owner{LB: "pks-demo"},
+			wantManaged: true,
+		},
+		{
+			name: "no labels",
+		},
+		{
+			name:   "another manager",
+			labels: map[string]string{ownerLabel: "terraform", lbLabel: "pks-demo"},
+		},
+		{
+			name:   "no load balancer",
+			labels: map[string]string{ownerLabel: OwnerMarker, hashLabel: "abc"},
+		},
+	}
+	for _, tt := range tests {
+		got, managed := OwnerFromLabels(tt.labels)
+		if managed != tt.wantManaged {
+			t.Errorf("%s: OwnerFromLabels() managed = %v, want %v", tt.name, managed, tt.wantManaged)
+		}
+		if got != tt.want {
+			t.Errorf("%s: OwnerFromLabels() = %+v, want %+v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestOwnerLabelsWithoutTemplate(t *testing.T) {
+	labels := Owner{LB: "pks-demo", Hash: "abc"}.Labels()
+	for _, l := range []string{templateLabel, groupLabel} {
+		if _, ok := labels[l]; ok {
+			t.Errorf("Labels() of a load balancer that wasn't discovered sets %s", l)
+		}
+	}
+}
@@ -0,0 +1,109 @@
+package cloud
+
+import (
+	"context"
+	"fmt"
+)
+
+// HealthGate takes target pool members that stay unhealthy out of rotation.
+type HealthGate struct {
+	// UnhealthyCycles is how many consecutive reconciles a member must be
+	// reported UNHEALTHY before it is removed, 0 disables the gate.
+	UnhealthyCycles int `yaml:"unhealthyCycles,omitempty"`
+	// RecheckCycles is how many reconciles a removed member is kept out
+	// before it is put back on probation to see whether it has recovered.
+	RecheckCycles int `yaml:"recheckCycles,omitempty"`
+	// MinMembers is the floor below which unhealthy members are kept.
+	MinMembers int `yaml:"minMembers,omitempty"`
+}
+
+// healthGate tracks member health across the reconciles of one load balancer.
+type healthGate struct {
+	// unhealthy counts consecutive UNHEALTHY reports per member
+	unhealthy map[string]int
+	// ejected counts the reconciles since a member was taken out
+	ejected map[string]int
+	// probation holds re-admitted members that are ejected again on
+	// their first UNHEALTHY report
+	probation map[string]bool
+}
+
+func newHealthGate() *healthGate {
+	return &healthGate{
+		unhealthy: map[string]int{},
+		ejected:   map[string]int{},
+		probation: map[string]bool{},
+	}
+}
+
+// apply reads the health of every target pool member and keeps members that
+// have been unhealthy for cfg.HealthGate.UnhealthyCycles out of the plan.
+// Ejected members are re-admitted on probation after RecheckCycles, and stay
+// in once the target pool reports them HEALTHY.
+func (h *healthGate) apply(ctx context.Context, c *gceCloud, cfg *Config, plan *membershipPlan) error {
+	gate := cfg.HealthGate
+	if gate.UnhealthyCycles <= 0 {
+		return nil
+	}
+
+	// forget instances that are no longer selected
+	for _, m := range []map[string]int{h.unhealthy, h.ejected} {
+		for i := range m {
+			if !plan.desired[i] {
+				delete(m, i)
+			}
+		}
+	}
+	for i := range h.probation {
+		if !plan.desired[i] {
+			delete(h.probation, i)
+		}
+	}
+
+	for _, i := range plan.members {
+		hs, err := c.client.GetTargetPoolHealth(ctx, cfg.Region, cfg.Name, i)
+		if err != nil || len(hs) == 0 {
+			// health unknown, keep counting from where we were
+			continue
+		}
+		if hs[0].HealthState == "HEALTHY" {
+			if h.probation[i] {
+				fmt.Printf("====> %s has recovered\n", i)
+				delete(h.probation, i)
+			}
+			delete(h.unhealthy, i)
+		} else {
+			h.unhealthy[i]++
+		}
+	}
+
+	// keep ejected instances out until it's time to recheck them
+	for i, n := range h.ejected {
+		if n+1 >= gate.RecheckCycles {
+			delete(h.ejected, i)
+			h.probation[i] = true
+			plan.notes[i] = "re-admitted on probation after being unhealthy"
+			continue
+		}
+		h.ejected[i] = n + 1
+		plan.desired[i] = false
+		plan.notes[i] = "ejected as unhealthy"
+	}
+
+	for _, i := range plan.members {
+		n := h.unhealthy[i]
+		if !plan.desired[i] || n == 0 || (n < gate.UnhealthyCycles && !h.probation[i]) {
+			continue
+		}
+		if plan.size()-1 < gate.MinMembers {
+			plan.notes[i] = fmt.Sprintf("unhealthy for %d cycles, kept to stay at %d members", n, gate.MinMembers)
+			continue
+		}
+		plan.desired[i] = false
+		plan.notes[i] = fmt.Sprintf("unhealthy for %d cycles", n)
+		h.ejected[i] = 0
+		delete(h.unhealthy, i)
+		delete(h.probation, i)
+	}
+	return nil
+}
@@ -0,0 +1,68 @@
+package cloud
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/paulczar/gcp-lb-tags/pkg/state"
+)
+
+// Hysteresis delays target pool changes until the selector has given the
+// same answer for a number of reconciles and/or a minimum duration, so a
+// transient listing result doesn't remove and re-add an instance.
+type Hysteresis struct {
+	// AddAfter is how many consecutive reconciles an instance must be
+	// selected before it is added.
+	AddAfter int `yaml:"addAfter,omitempty"`
+	// RemoveAfter is how many consecutive reconciles a member must be
+	// missing before it is removed.
+	RemoveAfter int `yaml:"removeAfter,omitempty"`
+	// AddDelay is how long an instance must have been selected before it is added.
+	AddDelay time.Duration `yaml:"addDelay,omitempty"`
+	// RemoveDelay is how long a member must have been missing before it is removed.
+	RemoveDelay time.Duration `yaml:"removeDelay,omitempty"`
+}
+
+// settled reports whether a change seen count times since since may go ahead.
+func settled(count, after int, since time.Time, delay time.Duration, now time.Time) bool {
+	return count >= after && now.Sub(since) >= delay
+}
+
+// applyHysteresis records whether each candidate is wanted and holds back
+// additions and removals that haven't settled yet.
+func (c *gceCloud) applyHysteresis(cfg *Config, plan *membershipPlan) error {
+	h := cfg.Hysteresis
+	now := time.Now()
+	in := plan.inPool()
+	st := c.stateFile(cfg)
+	st.Update(cfg.Name, func(lb *state.LoadBalancer) {
+		seen := map[string]bool{}
+		for _, i := range plan.candidates {
+			seen[i] = true
+			wanted := plan.desired[i]
+			m, ok := lb.Members[i]
+			if !ok || m.Wanted != wanted {
+				m = &state.Member{Wanted: wanted, Since: now}
+				lb.Members[i] = m
+			}
+			m.Count++
+			switch {
+			case wanted && !in[i] && !settled(m.Count, h.AddAfter, m.Since, h.AddDelay, now):
+				plan.desired[i] = false
+				plan.notes[i] = fmt.Sprintf("selected for %d reconciles since %s, waiting to add", m.Count, m.Since.Format(time.RFC3339))
+			case !wanted && in[i] && !settled(m.Count, h.RemoveAfter, m.Since, h.RemoveDelay, now):
+				plan.desired[i] = true
+				plan.notes[i] = fmt.Sprintf("missing for %d reconciles since %s, waiting to remove", m.Count, m.Since.Format(time.RFC3339))
+			}
+		}
+		for i := range lb.Members {
+			if !seen[i] {
+				delete(lb.Members, i)
+			}
+		}
+	})
+	if plan.dryRun {
+		return nil
+	}
+	return st.Save()
+}
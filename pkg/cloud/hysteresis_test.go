@@ -0,0 +1,122 @@
+package cloud
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestSettled(t *testing.T) {
+	now := time.Now()
+	tests := []struct {
+		name  string
+		count int
+		after int
+		since time.Time
+		delay time.Duration
+		want  bool
+	}{
+		{"no hysteresis", 1, 0, now, 0, true},
+		{"too few reconciles", 2, 3, now.Add(-time.Hour), 0, false},
+		{"enough reconciles", 3, 3, now.Add(-time.Hour), 0, true},
+		{"too recent", 5, 0, now.Add(-time.Minute), 5 * time.Minute, false},
+		{"long enough", 5, 0, now.Add(-5 * time.Minute), 5 * time.Minute, true},
+		{"both needed", 5, 3, now.Add(-time.Minute), 5 * time.Minute, false},
+	}
+	for _, tt := range tests {
+		if got := settled(tt.count, tt.after, tt.since, tt.delay, now); got != tt.want {
+			t.Errorf("%s: settled() = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+// hysteresisStep is one reconcile: the instances selected, and the changes
+// the plan is expected to make to the pool left by the previous step.
+type hysteresisStep struct {
+	selected []string
+	add, del []string
+}
+
+func TestApplyHysteresis(t *testing.T) {
+	tests := []struct {
+		name       string
+		hysteresis Hysteresis
+		members    []string
+		steps      []hysteresisStep
+	}{
+		{
+			name:    "no hysteresis",
+			members: []string{"a"},
+			steps: []hysteresisStep{
+				{selected: []string{"b"}, add: []string{"b"}, del: []string{"a"}},
+			},
+		},
+		{
+			name:       "add after",
+			hysteresis: Hysteresis{AddAfter: 3},
+			members:    []string{"a"},
+			steps: []hysteresisStep{
+				{selected: []string{"a", "b"}},
+				{selected: []string{"a", "b"}},
+				{selected: []string{"a", "b"}, add: []string{"b"}},
+				{selected: []string{"a", "b"}},
+			},
+		},
+		{
+			name:       "remove after",
+			hysteresis: Hysteresis{RemoveAfter: 2},
+			members:    []string{"a", "b"},
+			steps: []hysteresisStep{
+				{selected: []string{"a"}},
+				{selected: []string{"a"}, del: []string{"b"}},
+			},
+		},
+		{
+			name:       "flapping starts over",
+			hysteresis: Hysteresis{AddAfter: 2, RemoveAfter: 2},
+			members:    []string{"a"},
+			steps: []hysteresisStep{
+				{selected: []string{"a", "b"}},
+				{selected: []string{"a"}},
+				{selected: []string{"a", "b"}},
+				{selected: []string{"a", "b"}, add: []string{"b"}},
+			},
+		},
+		{
+			name:       "add delay",
+			hysteresis: Hysteresis{AddDelay: time.Hour, RemoveDelay: time.Hour},
+			members:    []string{"a"},
+			steps: []hysteresisStep{
+				{selected: []string{"b"}},
+				{selected: []string{"b"}},
+			},
+		},
+	}
+	for _, tt := range tests {
+		c := &gceCloud{}
+		cfg := &Config{Name: "lb", Hysteresis: tt.hysteresis}
+		members := tt.members
+		for n, step := range tt.steps {
+			plan := newMembershipPlan(members, step.selected)
+			if err := c.applyHysteresis(cfg, plan); err != nil {
+				t.Fatalf("%s: step %d: applyHysteresis() error = %v", tt.name, n, err)
+			}
+			add, del := plan.toAdd(), plan.toDel()
+			if step.add == nil {
+				step.add = []string{}
+			}
+			if step.del == nil {
+				step.del = []string{}
+			}
+			if !reflect.DeepEqual(add, step.add) || !reflect.DeepEqual(del, step.del) {
+				t.Errorf("%s: step %d: adds %v and removes %v, want %v and %v", tt.name, n, add, del, step.add, step.del)
+			}
+			members = []string{}
+			for _, i := range plan.candidates {
+				if plan.desired[i] {
+					members = append(members, i)
+				}
+			}
+		}
+	}
+}
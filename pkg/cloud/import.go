@@ -0,0 +1,336 @@
+package cloud
+
+import (
+	"context"
+	"fmt"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/paulczar/gcp-lb-tags/pkg/cloud/gce"
+	compute "google.golang.org/api/compute/v1"
+)
+
+// ImportLoadBalancer inspects an existing forwarding rule, target pool,
+// address and firewall, works out the equivalent load balancer definition and
+// stamps the resources as managed so later runs reconcile them. The
+// forwarding rule is looked up by cfg.Name unless a self link is given.
+func (c *gceCloud) ImportLoadBalancer(ctx context.Context, cfg *Config, forwardingRule string) (*Config, error) {
+	out := &Config{
+		Name:      cfg.Name,
+		ProjectID: cfg.ProjectID,
+		Region:    cfg.Region,
+		Network:   cfg.Network,
+		Tags:      cfg.Tags,
+		Labels:    cfg.Labels,
+		Zones:     cfg.Zones,
+	}
+	if forwardingRule != "" {
+		out.Region, out.Name = gce.ParseSelfLink(forwardingRule)
+	}
+	fmt.Printf("Importing Loadbalancer %s\n", out.Name)
+
+	fmt.Println("--> Inspecting Forwarding Rule")
+	fr, err := c.client.GetForwardingRule(ctx, out.Region, out.Name)
+	if err != nil {
+		return nil, err
+	}
+	if fr == nil {
+		return nil, fmt.Errorf("forwarding rule %s not found in %s", out.Name, out.Region)
+	}
+	switch fr.IPProtocol {
+	case "TCP":
+		out.Mode = ModeTCP
+	case "UDP":
+		out.Mode = ModeUDP
+	default:
+		return nil, fmt.Errorf("forwarding rule %s uses %s, only TCP and UDP are supported", fr.Name, fr.IPProtocol)
+	}
+	if !strings.Contains(fr.Target, "/targetPools/") {
+		return nil, fmt.Errorf("forwarding rule %s does not point at a target pool", fr.Name)
+	}
+	if _, tpName := gce.ParseSelfLink(fr.Target); tpName != fr.Name {
+		return nil, fmt.Errorf("target pool %s must share the forwarding rule's name %s", tpName, fr.Name)
+	}
+	out.Port = portFromRange(fr.PortRange)
+	fmt.Printf("====> %s %s:%s\n", fr.IPProtocol, fr.IPAddress, out.Port)
+
+	fmt.Println("--> Inspecting Target Pool")
+	tp, err := c.client.GetTargetPool(ctx, out.Region, out.Name)
+	if err != nil {
+		return nil, err
+	}
+	if tp == nil {
+		return nil, fmt.Errorf("target pool %s not found in %s", out.Name, out.Region)
+	}
+	members := []*compute.Instance{}
+	for _, link := range tp.Instances {
+		zone, name := gce.ParseSelfLink(link)
+		i, err := c.client.GetInstance(ctx, zone, name)
+		if err != nil {
+			return nil, err
+		}
+		if i == nil {
+			fmt.Printf("====> Ignoring missing instance %s\n", link)
+			continue
+		}
+		members = append(members, i)
+	}
+	fmt.Printf("====> %d instances\n", len(members))
+	hc, err := c.importHealthCheck(ctx, out, tp)
+	if err != nil {
+		return nil, err
+	}
+
+	fmt.Println("--> Inspecting External Address")
+	addrs, err := c.client.ListExternalIPs(ctx, out.Region)
+	if err != nil {
+		return nil, err
+	}
+	for _, a := range addrs {
+		if a.Address == fr.IPAddress {
+			out.Address = a.Name
+		}
+	}
+	// an ephemeral IP is only reserved once the whole load balancer is
+	// known to import
+	reserve := out.Address == ""
+	if reserve {
+		out.Address = out.Name
+		fmt.Printf("====> %s is ephemeral, it will be reserved as %s\n", fr.IPAddress, out.Address)
+	} else {
+		fmt.Printf("====> %s - %s\n", out.Address, fr.IPAddress)
+	}
+
+	fmt.Println("--> Inspecting Firewall Rule")
+	fw, err := c.client.GetFirewall(ctx, out.Name)
+	if err != nil {
+		return nil, err
+	}
+	if fw != nil {
+		if len(out.Tags) == 0 {
+			out.Tags = fw.TargetTags
+		}
+		if out.Network == "" {
+			out.Network = path.Base(fw.Network)
+		}
+		if err = importFirewall(out, fr, fw); err != nil {
+			return nil, err
+		}
+	} else {
+		fmt.Printf("====> No firewall rule named %s, one will be created\n", out.Name)
+	}
+	if len(members) > 0 {
+		if out.Network == "" && len(members[0].NetworkInterfaces) > 0 {
+			out.Network = path.Base(members[0].NetworkInterfaces[0].Network)
+		}
+		if len(out.Tags) == 0 {
+			out.Tags = commonTags(members)
+		}
+	}
+	if out.Network == "" {
+		return nil, fmt.Errorf("could not work out the network of %s, please pass --network", out.Name)
+	}
+
+	if len(out.Labels) == 0 {
+		out.Labels = commonLabels(members)
+		if len(out.Labels) == 0 {
+			return nil, fmt.Errorf("target pool %s members share no labels, please pass --labels", out.Name)
+		}
+		fmt.Printf("====> Inferred labels: %s\n", strings.Join(out.Labels, ", "))
+	}
+	if err = c.compareSelector(ctx, out, tp); err != nil {
+		return nil, err
+	}
+
+	if reserve {
+		// promote the ephemeral IP so the VIP survives a recreate
+		fmt.Printf("--> Reserving ephemeral address %s as %s\n", fr.IPAddress, out.Address)
+		if _, err = c.client.ReserveExternalIP(ctx, out.Region, out.Address, fr.IPAddress, c.owner(out).Description()); err != nil {
+			return nil, err
+		}
+	}
+
+	fmt.Println("--> Stamping ownership")
+	if err = c.stampForwardingRule(ctx, out, fr.Name); err != nil {
+		return nil, err
+	}
+	if err = c.stampAddress(ctx, out, out.Address); err != nil {
+		return nil, err
+	}
+	if fw != nil {
+		if err = c.client.SetFirewallDescription(ctx, fw.Name, c.owner(out).Description()); err != nil {
+			return nil, err
+		}
+	}
+	if hc != nil {
+		if err = c.client.UpdateHTTPHealthCheck(ctx, hc.Name, out.HealthCheckPort, out.HealthCheckPath, c.owner(out).Description()); err != nil {
+			return nil, err
+		}
+	}
+	fmt.Println("--> Done.")
+	return out, nil
+}
+
+// importHealthCheck reads the port and path of the health check attached to
+// tp. create only manages a health check sharing the load balancer's name, so
+// any other one fails the import rather than being left behind.
+func (c *gceCloud) importHealthCheck(ctx context.Context, out *Config, tp *compute.TargetPool) (*compute.HttpHealthCheck, error) {
+	if len(tp.HealthChecks) == 0 {
+		return nil, nil
+	}
+	if len(tp.HealthChecks) > 1 {
+		return nil, fmt.Errorf("target pool %s has %d health checks, only one is supported", tp.Name, len(tp.HealthChecks))
+	}
+	if !strings.Contains(tp.HealthChecks[0], "/httpHealthChecks/") {
+		return nil, fmt.Errorf("target pool %s uses %s, only HTTP health checks are supported", tp.Name, tp.HealthChecks[0])
+	}
+	name := path.Base(tp.HealthChecks[0])
+	if name != out.Name {
+		return nil, fmt.Errorf("health check %s must share the target pool's name %s", name, out.Name)
+	}
+	hc, err := c.client.GetHTTPHealthCheck(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+	if hc == nil {
+		return nil, fmt.Errorf("health check %s attached to target pool %s not found", name, tp.Name)
+	}
+	if hc.Host != "" {
+		return nil, fmt.Errorf("health check %s sets a Host header, which can't be managed", name)
+	}
+	out.HealthCheckPort = strconv.FormatInt(hc.Port, 10)
+	if hc.Port == 0 {
+		out.HealthCheckPort = "80"
+	}
+	out.HealthCheckPath = hc.RequestPath
+	if out.HealthCheckPath == "" {
+		out.HealthCheckPath = "/"
+	}
+	fmt.Printf("====> Health check %s on port %s\n", out.HealthCheckPath, out.HealthCheckPort)
+	return hc, nil
+}
+
+// importFirewall reads the ports and source ranges fw admits into out, so
+// the first reconcile keeps them. A rule the config can't express fails the
+// import rather than being rewritten into a different one.
+func importFirewall(out *Config, fr *compute.ForwardingRule, fw *compute.Firewall) error {
+	if fw.Direction == "EGRESS" {
+		return fmt.Errorf("firewall rule %s is an egress rule", fw.Name)
+	}
+	if len(fw.Denied) > 0 || len(fw.SourceTags) > 0 || len(fw.SourceServiceAccounts) > 0 || len(fw.TargetServiceAccounts) > 0 {
+		return fmt.Errorf("firewall rule %s uses deny entries, source tags or service accounts, which can't be managed", fw.Name)
+	}
+	ports := []string{}
+	for _, a := range fw.Allowed {
+		if !strings.EqualFold(a.IPProtocol, out.Mode) {
+			return fmt.Errorf("firewall rule %s allows %s, only %s can be managed", fw.Name, a.IPProtocol, out.Mode)
+		}
+		if len(a.Ports) == 0 {
+			return fmt.Errorf("firewall rule %s allows every %s port, only listed ports can be managed", fw.Name, a.IPProtocol)
+		}
+		ports = append(ports, a.Ports...)
+	}
+	if len(ports) == 0 {
+		return fmt.Errorf("firewall rule %s allows nothing", fw.Name)
+	}
+	// the forwarding rule takes the smallest range covering the ports
+	// admitted, so they must span the imported one exactly
+	lo, hi := parsePortRange(ports[0])
+	for _, p := range ports[1:] {
+		plo, phi := parsePortRange(p)
+		if plo < lo {
+			lo = plo
+		}
+		if phi > hi {
+			hi = phi
+		}
+	}
+	if flo, fhi := parsePortRange(fr.PortRange); lo != flo || hi != fhi {
+		return fmt.Errorf("firewall rule %s admits ports %s, which don't span the forwarding rule's %s", fw.Name, strings.Join(ports, ", "), fr.PortRange)
+	}
+	if len(ports) > 1 || ports[0] != out.Port {
+		out.Ports = ports
+	}
+	if len(fw.SourceRanges) != 1 || fw.SourceRanges[0] != "0.0.0.0/0" {
+		out.SourceRanges = fw.SourceRanges
+	}
+	return nil
+}
+
+// compareSelector warns about the pool changes the first reconcile with the
+// imported selector would make.
+func (c *gceCloud) compareSelector(ctx context.Context, cfg *Config, tp *compute.TargetPool) error {
+	if err := c.listInstancesPerZone(ctx, cfg); err != nil {
+		return err
+	}
+	selected := map[string]bool{}
+	for _, z := range c.zones {
+		for _, i := range c.instancesInZone[z] {
+			selected[i.SelfLink] = true
+		}
+	}
+	for _, i := range tp.Instances {
+		if !selected[i] {
+			fmt.Printf("====> Warning: %s is not selected by the labels and would be removed\n", i)
+		}
+		delete(selected, i)
+	}
+	for i := range selected {
+		fmt.Printf("====> Warning: %s is selected by the labels and would be added\n", i)
+	}
+	return nil
+}
+
+// portFromRange collapses a single port range such as "8443-8443" to "8443".
+func portFromRange(r string) string {
+	p := strings.SplitN(r, "-", 2)
+	if len(p) == 2 && p[0] == p[1] {
+		return p[0]
+	}
+	return r
+}
+
+// commonLabels returns the key:value labels every instance carries.
+func commonLabels(instances []*compute.Instance) []string {
+	if len(instances) == 0 {
+		return nil
+	}
+	labels := []string{}
+	for k, v := range instances[0].Labels {
+		shared := true
+		for _, i := range instances[1:] {
+			if i.Labels[k] != v {
+				shared = false
+				break
+			}
+		}
+		if shared {
+			labels = append(labels, k+":"+v)
+		}
+	}
+	sort.Strings(labels)
+	return labels
+}
+
+// commonTags returns the network tags every instance carries.
+func commonTags(instances []*compute.Instance) []string {
+	counts := map[string]int{}
+	for _, i := range instances {
+		if i.Tags == nil {
+			continue
+		}
+		for _, t := range i.Tags.Items {
+			counts[t]++
+		}
+	}
+	tags := []string{}
+	for t, n := range counts {
+		if n == len(instances) {
+			tags = append(tags, t)
+		}
+	}
+	sort.Strings(tags)
+	return tags
+}
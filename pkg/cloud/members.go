@@ -0,0 +1,57 @@
+package cloud
+
+import (
+	"context"
+	"fmt"
+
+	compute "google.golang.org/api/compute/v1"
+)
+
+// AddMember adds one instance to the load balancer's target pool, for an
+// instance registering itself. It is a no-op when the instance is already a
+// member.
+func (c *gceCloud) AddMember(ctx context.Context, cfg *Config, zone, instance string) error {
+	selfLink, member, err := c.member(ctx, cfg, zone, instance)
+	if err != nil || member {
+		return err
+	}
+	return c.client.AddInstanceToTargetPool(ctx, cfg.Region, cfg.Name, []*compute.InstanceReference{{Instance: selfLink}})
+}
+
+// RemoveMember removes one instance from the load balancer's target pool. It
+// is a no-op when the instance isn't a member.
+func (c *gceCloud) RemoveMember(ctx context.Context, cfg *Config, zone, instance string) error {
+	selfLink, member, err := c.member(ctx, cfg, zone, instance)
+	if err != nil || !member {
+		return err
+	}
+	return c.client.DeleteInstanceFromTargetPool(ctx, cfg.Region, cfg.Name, []*compute.InstanceReference{{Instance: selfLink}})
+}
+
+// member returns the self link of the instance and whether it is in the
+// target pool, which must exist and belong to the load balancer.
+func (c *gceCloud) member(ctx context.Context, cfg *Config, zone, instance string) (string, bool, error) {
+	tp, err := c.client.GetTargetPool(ctx, cfg.Region, cfg.Name)
+	if err != nil {
+		return "", false, err
+	}
+	if tp == nil {
+		return "", false, fmt.Errorf("target pool %s doesn't exist in %s", cfg.Name, cfg.Region)
+	}
+	if err = c.checkTargetPool(ctx, cfg, tp); err != nil {
+		return "", false, err
+	}
+	i, err := c.client.GetInstance(ctx, zone, instance)
+	if err != nil {
+		return "", false, err
+	}
+	if i == nil {
+		return "", false, fmt.Errorf("instance %s doesn't exist in %s", instance, zone)
+	}
+	for _, m := range tp.Instances {
+		if m == i.SelfLink {
+			return i.SelfLink, true, nil
+		}
+	}
+	return i.SelfLink, false, nil
+}
@@ -0,0 +1,205 @@
+package cloud
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/paulczar/gcp-lb-tags/pkg/cloud/gce"
+	compute "google.golang.org/api/compute/v1"
+)
+
+// Plan is the target pool change the next reconcile would make.
+type Plan struct {
+	Name      string       `json:"name"`
+	Instances []*PlanEntry `json:"instances"`
+}
+
+// Plan actions.
+const (
+	ActionAdd    = "add"
+	ActionRemove = "remove"
+	ActionKeep   = "keep"
+	ActionSkip   = "skip"
+)
+
+// PlanEntry is what the next reconcile would do with one instance, and why.
+type PlanEntry struct {
+	Name     string `json:"name"`
+	Zone     string `json:"zone"`
+	Action   string `json:"action"`
+	Note     string `json:"note,omitempty"`
+	SelfLink string `json:"selfLink"`
+}
+
+// membershipPlan is the target pool membership a reconcile works towards.
+// It starts from the label selector and each membership policy may then
+// move instances in or out, noting why.
+type membershipPlan struct {
+	// members are the instances currently in the target pool
+	members []string
+	// candidates are the selected instances followed by any other members
+	candidates []string
+	desired    map[string]bool
+	notes      map[string]string
+	// dryRun plans without recording state or touching instances
+	dryRun bool
+}
+
+func newMembershipPlan(members, selected []string) *membershipPlan {
+	p := &membershipPlan{
+		members: members,
+		desired: map[string]bool{},
+		notes:   map[string]string{},
+	}
+	for _, i := range selected {
+		if !p.desired[i] {
+			p.desired[i] = true
+			p.candidates = append(p.candidates, i)
+		}
+	}
+	for _, i := range members {
+		if _, ok := p.desired[i]; !ok {
+			p.desired[i] = false
+			p.candidates = append(p.candidates, i)
+		}
+	}
+	return p
+}
+
+func (p *membershipPlan) inPool() map[string]bool {
+	in := map[string]bool{}
+	for _, i := range p.members {
+		in[i] = true
+	}
+	return in
+}
+
+// size is the number of members the target pool will have once applied.
+func (p *membershipPlan) size() int {
+	n := 0
+	for _, i := range p.candidates {
+		if p.desired[i] {
+			n++
+		}
+	}
+	return n
+}
+
+func (p *membershipPlan) toAdd() []string {
+	in := p.inPool()
+	add := []string{}
+	for _, i := range p.candidates {
+		if p.desired[i] && !in[i] {
+			add = append(add, i)
+		}
+	}
+	return add
+}
+
+func (p *membershipPlan) toDel() []string {
+	del := []string{}
+	for _, i := range p.members {
+		if !p.desired[i] {
+			del = append(del, i)
+		}
+	}
+	return del
+}
+
+// planMembership works out the target pool membership from the instances
+// matching the labels and the membership policies in cfg.
+func (c *gceCloud) planMembership(ctx context.Context, cfg *Config, members, selected []string, dryRun bool) (*membershipPlan, error) {
+	plan := newMembershipPlan(members, selected)
+	plan.dryRun = dryRun
+	if err := c.applyOverrides(ctx, cfg, plan); err != nil {
+		return nil, err
+	}
+	c.applyDrainLabel(cfg, plan)
+	if err := c.applyHysteresis(cfg, plan); err != nil {
+		return nil, err
+	}
+	if err := c.applyDrainGrace(ctx, cfg, plan); err != nil {
+		return nil, err
+	}
+	c.applyProbes(cfg, plan)
+	if err := c.health.apply(ctx, c, cfg, plan); err != nil {
+		return nil, err
+	}
+	if err := c.applySafetyLimits(cfg, plan); err != nil {
+		return nil, err
+	}
+	return plan, nil
+}
+
+// PlanLoadBalancer works out what the next reconcile would do to the target
+// pool without changing anything.
+func (c *gceCloud) PlanLoadBalancer(ctx context.Context, cfg *Config) (*Plan, error) {
+	if err := c.listInstancesPerZone(ctx, cfg); err != nil {
+		return nil, err
+	}
+	tp, err := c.client.GetTargetPool(ctx, cfg.Region, cfg.Name)
+	if err != nil {
+		return nil, err
+	}
+	members := []string{}
+	if tp != nil {
+		members = tp.Instances
+	}
+	mp, err := c.planMembership(ctx, cfg, members, c.selectedInstances(), true)
+	if err != nil {
+		return nil, err
+	}
+
+	p := &Plan{Name: cfg.Name, Instances: []*PlanEntry{}}
+	in := mp.inPool()
+	for _, i := range mp.candidates {
+		zone, name := gce.ParseSelfLink(i)
+		e := &PlanEntry{Name: name, Zone: zone, Note: mp.notes[i], SelfLink: i}
+		switch {
+		case mp.desired[i] && in[i]:
+			e.Action = ActionKeep
+		case mp.desired[i]:
+			e.Action = ActionAdd
+		case in[i]:
+			e.Action = ActionRemove
+		default:
+			e.Action = ActionSkip
+		}
+		p.Instances = append(p.Instances, e)
+	}
+	return p, nil
+}
+
+// applyMembership adds and removes target pool instances according to plan.
+func (c *gceCloud) applyMembership(ctx context.Context, cfg *Config, plan *membershipPlan) error {
+	toAdd := []*compute.InstanceReference{}
+	for _, i := range plan.toAdd() {
+		fmt.Printf("Need to add %s to TargetPool%s\n", i, plan.note(i))
+		toAdd = append(toAdd, &compute.InstanceReference{Instance: i})
+	}
+	toDel := []*compute.InstanceReference{}
+	for _, i := range plan.toDel() {
+		fmt.Printf("Need to remove %s from TargetPool%s\n", i, plan.note(i))
+		toDel = append(toDel, &compute.InstanceReference{Instance: i})
+	}
+
+	// Add and Delete Instances in TargetPool
+	if len(toAdd) > 0 {
+		if err := c.client.AddInstanceToTargetPool(ctx, cfg.Region, cfg.Name, toAdd); err != nil {
+			return err
+		}
+	}
+	if len(toDel) > 0 {
+		if err := c.client.DeleteInstanceFromTargetPool(ctx, cfg.Region, cfg.Name, toDel); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (p *membershipPlan) note(i string) string {
+	if n, ok := p.notes[i]; ok {
+		return " (" + n + ")"
+	}
+	return ""
+}
@@ -0,0 +1,84 @@
+package cloud
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/paulczar/gcp-lb-tags/pkg/cloud/gce"
+	"github.com/paulczar/gcp-lb-tags/pkg/state"
+	compute "google.golang.org/api/compute/v1"
+)
+
+// pendingOperations records the operations of one load balancer in the
+// state file while they are waited for, so a run that dies part way through
+// an operation can be followed by one that waits for it.
+type pendingOperations struct {
+	st *state.File
+	lb string
+}
+
+func (p *pendingOperations) Started(op *compute.Operation) {
+	p.st.Update(p.lb, func(lb *state.LoadBalancer) {
+		lb.Operations[op.Name] = &state.Operation{
+			SelfLink: op.SelfLink,
+			Type:     op.OperationType,
+			Target:   op.TargetLink,
+			Since:    time.Now(),
+		}
+	})
+	p.save()
+}
+
+func (p *pendingOperations) Finished(op *compute.Operation) {
+	p.st.Update(p.lb, func(lb *state.LoadBalancer) {
+		delete(lb.Operations, op.Name)
+	})
+	p.save()
+}
+
+func (p *pendingOperations) save() {
+	if err := p.st.Save(); err != nil {
+		fmt.Printf("====> Failed to save pending operations: %s\n", err)
+	}
+}
+
+// trackOperations returns a context that records the operations started
+// for cfg in its state.
+func (c *gceCloud) trackOperations(ctx context.Context, cfg *Config) context.Context {
+	return gce.WithOperationTracker(ctx, &pendingOperations{st: c.stateFile(cfg), lb: cfg.Name})
+}
+
+// resumeOperations waits for the operations an earlier run left pending and
+// reports how they ended, before any new change is planned. It only fails
+// when it can't find out whether an operation is still running.
+func (c *gceCloud) resumeOperations(ctx context.Context, cfg *Config) error {
+	st := c.stateFile(cfg)
+	pending := map[string]*state.Operation{}
+	st.Update(cfg.Name, func(lb *state.LoadBalancer) {
+		for name, op := range lb.Operations {
+			pending[name] = op
+		}
+	})
+	for name, op := range pending {
+		fmt.Printf("====> Waiting for %s of %s, pending since %s\n", op.Type, op.Target, op.Since.Format(time.RFC3339))
+		known, err := c.client.WaitForOperation(ctx, op.SelfLink)
+		switch {
+		case !known:
+			fmt.Printf("====> Operation %s is no longer known to GCE\n", name)
+		case err != nil && ctx.Err() == nil && gce.IsOperationError(err):
+			fmt.Printf("====> Operation %s failed: %s\n", name, err)
+		case err != nil:
+			return err
+		default:
+			fmt.Printf("====> Operation %s finished\n", name)
+		}
+		st.Update(cfg.Name, func(lb *state.LoadBalancer) {
+			delete(lb.Operations, name)
+		})
+	}
+	if len(pending) > 0 {
+		return st.Save()
+	}
+	return nil
+}
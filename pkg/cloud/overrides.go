@@ -0,0 +1,83 @@
+package cloud
+
+import (
+	"context"
+	"github.com/paulczar/gcp-lb-tags/pkg/cloud/gce"
+)
+
+const (
+	// ExcludeLabel keeps an instance out of the load balancer named by its
+	// value, or out of every load balancer when set to "true", even when it
+	// matches the labels.
+	ExcludeLabel = "gcp-lb-tags-exclude"
+	// PinLabel keeps a member in the target pool of the load balancer named
+	// by its value, or of every load balancer when set to "true", once it no
+	// longer matches the labels. It never adds an instance that isn't a
+	// member.
+	PinLabel = "gcp-lb-tags-pin"
+
+	overrideExcluded = "excluded"
+	overridePinned   = "pinned"
+)
+
+// listOverrides returns the instances of the region that are excluded from
+// or pinned to the load balancer, keyed by self link.
+func (c *gceCloud) listOverrides(ctx context.Context, cfg *Config) (map[string]string, error) {
+	labelled := map[string][]string{}
+	value := "(true|" + cfg.Name + ")"
+	for _, label := range []string{PinLabel, ExcludeLabel} {
+		for _, z := range c.zones {
+			zi, err := c.client.ListInstancesInZone(ctx, z, nil, []string{label + ":" + value})
+			if err != nil {
+				return nil, err
+			}
+			for _, i := range zi.Items {
+				labelled[label] = append(labelled[label], i.SelfLink)
+			}
+		}
+	}
+	return mergeOverrides(labelled[PinLabel], labelled[ExcludeLabel]), nil
+}
+
+// mergeOverrides keys the overrides of the pinned and excluded instances by
+// self link. Exclusion wins when an instance carries both labels.
+func mergeOverrides(pinned, excluded []string) map[string]string {
+	overrides := map[string]string{}
+	for _, i := range pinned {
+		overrides[i] = overridePinned
+	}
+	for _, i := range excluded {
+		overrides[i] = overrideExcluded
+	}
+	return overrides
+}
+
+// applyOverrides keeps pinned members in the plan and takes excluded
+// instances out.
+func (c *gceCloud) applyOverrides(ctx context.Context, cfg *Config, plan *membershipPlan) error {
+	overrides, err := c.listOverrides(ctx, cfg)
+	if err != nil {
+		return err
+	}
+	plan.override(overrides)
+	return nil
+}
+
+func (p *membershipPlan) override(overrides map[string]string) {
+	in := p.inPool()
+	for i, o := range overrides {
+		_, name := gce.ParseSelfLink(i)
+		switch o {
+		case overridePinned:
+			if in[i] && !p.desired[i] {
+				p.desired[i] = true
+				p.notes[i] = "pinned by " + PinLabel + " on " + name
+			}
+		case overrideExcluded:
+			if p.desired[i] {
+				p.desired[i] = false
+				p.notes[i] = "excluded by " + ExcludeLabel + " on " + name
+			}
+		}
+	}
+}
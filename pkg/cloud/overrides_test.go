@@ -0,0 +1,94 @@
+package cloud
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestOverrides(t *testing.T) {
+	tests := []struct {
+		name     string
+		members  []string
+		selected []string
+		pinned   []string
+		excluded []string
+		want     []string
+		// notes are the overrides expected to show in the plan
+		notes map[string]string
+	}{
+		{
+			name:     "no overrides",
+			members:  []string{"a", "b"},
+			selected: []string{"b", "c"},
+			want:     []string{"b", "c"},
+			notes:    map[string]string{},
+		},
+		{
+			name:     "pin keeps a member",
+			members:  []string{"a", "b"},
+			selected: []string{"b"},
+			pinned:   []string{"a"},
+			want:     []string{"b", "a"},
+			notes:    map[string]string{"a": "pinned by " + PinLabel + " on a"},
+		},
+		{
+			name:     "pin does not add an instance",
+			members:  []string{"a"},
+			selected: []string{"a"},
+			pinned:   []string{"c"},
+			want:     []string{"a"},
+			notes:    map[string]string{},
+		},
+		{
+			name:     "pin of a selected member changes nothing",
+			members:  []string{"a"},
+			selected: []string{"a"},
+			pinned:   []string{"a"},
+			want:     []string{"a"},
+			notes:    map[string]string{},
+		},
+		{
+			name:     "exclude removes a selected member",
+			members:  []string{"a", "b"},
+			selected: []string{"a", "b"},
+			excluded: []string{"a"},
+			want:     []string{"b"},
+			notes:    map[string]string{"a": "excluded by " + ExcludeLabel + " on a"},
+		},
+		{
+			name:     "exclude keeps a selected instance out",
+			members:  []string{"a"},
+			selected: []string{"a", "c"},
+			excluded: []string{"c"},
+			want:     []string{"a"},
+			notes:    map[string]string{"c": "excluded by " + ExcludeLabel + " on c"},
+		},
+		{
+			name:     "exclude wins over pin",
+			members:  []string{"a", "b"},
+			selected: []string{"b"},
+			pinned:   []string{"a", "b"},
+			excluded: []string{"a", "b"},
+			want:     []string{},
+			notes: map[string]string{
+				"b": "excluded by " + ExcludeLabel + " on b",
+			},
+		},
+	}
+	for _, tt := range tests {
+		plan := newMembershipPlan(tt.members, tt.selected)
+		plan.override(mergeOverrides(tt.pinned, tt.excluded))
+		got := []string{}
+		for _, i := range plan.candidates {
+			if plan.desired[i] {
+				got = append(got, i)
+			}
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: pool = %v, want %v", tt.name, got, tt.want)
+		}
+		if !reflect.DeepEqual(plan.notes, tt.notes) {
+			t.Errorf("%s: notes = %v, want %v", tt.name, plan.notes, tt.notes)
+		}
+	}
+}
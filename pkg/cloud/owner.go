@@ -0,0 +1,140 @@
+package cloud
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/paulczar/gcp-lb-tags/pkg/cloud/gce"
+	compute "google.golang.org/api/compute/v1"
+)
+
+// NotManagedError is returned when a resource shares the load balancer's name
+// but does not carry its ownership marker.
+type NotManagedError struct {
+	Kind  string
+	Name  string
+	Owner string
+}
+
+func (e *NotManagedError) Error() string {
+	if e.Owner != "" {
+		return fmt.Sprintf("%s %s is managed by load balancer %s, use --adopt to take it over", e.Kind, e.Name, e.Owner)
+	}
+	return fmt.Sprintf("%s %s is not managed by %s, use --adopt to take it over", e.Kind, e.Name, gce.OwnerMarker)
+}
+
+func (c *gceCloud) owner(cfg *Config) gce.Owner {
+	return gce.Owner{LB: cfg.Name, Hash: cfg.Hash(), Template: cfg.Template, Group: cfg.Group}
+}
+
+// checkOwner refuses to touch a resource that isn't marked as belonging to
+// this load balancer unless the user asked to adopt it.
+func checkOwner(cfg *Config, kind, name string, o gce.Owner, managed bool) error {
+	if managed && o.LB == cfg.Name {
+		return nil
+	}
+	if cfg.Adopt {
+		fmt.Printf("====> Adopting %s %s\n", kind, name)
+		return nil
+	}
+	err := &NotManagedError{Kind: kind, Name: name}
+	if managed {
+		err.Owner = o.LB
+	}
+	return err
+}
+
+func (c *gceCloud) checkTargetPool(ctx context.Context, cfg *Config, tp *compute.TargetPool) error {
+	o, ok := gce.OwnerFromDescription(tp.Description)
+	if !ok {
+		// an imported pool can't be relabelled, it's ours when our
+		// forwarding rule points at it
+		fr, err := c.client.GetForwardingRule(ctx, cfg.Region, cfg.Name)
+		if err != nil {
+			return err
+		}
+		if fr != nil && fr.Target == tp.SelfLink {
+			labels, _, err := c.client.GetForwardingRuleLabels(ctx, cfg.Region, fr.Name)
+			if err != nil {
+				return err
+			}
+			o, ok = gce.OwnerFromLabels(labels)
+		}
+	}
+	return checkOwner(cfg, "target pool", tp.Name, o, ok)
+}
+
+func (c *gceCloud) checkHealthCheck(cfg *Config, hc *compute.HttpHealthCheck) error {
+	o, ok := gce.OwnerFromDescription(hc.Description)
+	return checkOwner(cfg, "health check", hc.Name, o, ok)
+}
+
+func (c *gceCloud) checkFirewall(cfg *Config, fw *compute.Firewall) error {
+	o, ok := gce.OwnerFromDescription(fw.Description)
+	return checkOwner(cfg, "firewall", fw.Name, o, ok)
+}
+
+func (c *gceCloud) checkForwardingRule(ctx context.Context, cfg *Config, fr *compute.ForwardingRule) error {
+	labels, _, err := c.client.GetForwardingRuleLabels(ctx, cfg.Region, fr.Name)
+	if err != nil {
+		return err
+	}
+	o, ok := gce.OwnerFromLabels(labels)
+	if !ok {
+		o, ok = gce.OwnerFromDescription(fr.Description)
+	}
+	return checkOwner(cfg, "forwarding rule", fr.Name, o, ok)
+}
+
+func (c *gceCloud) checkAddress(ctx context.Context, cfg *Config, a *compute.Address) error {
+	labels, _, err := c.client.GetAddressLabels(ctx, cfg.Region, a.Name)
+	if err != nil {
+		return err
+	}
+	o, ok := gce.OwnerFromLabels(labels)
+	if !ok {
+		o, ok = gce.OwnerFromDescription(a.Description)
+	}
+	return checkOwner(cfg, "external address", a.Name, o, ok)
+}
+
+// stampForwardingRule makes sure the forwarding rule labels carry the current
+// ownership marker and config hash.
+func (c *gceCloud) stampForwardingRule(ctx context.Context, cfg *Config, name string) error {
+	labels, fingerprint, err := c.client.GetForwardingRuleLabels(ctx, cfg.Region, name)
+	if err != nil {
+		return err
+	}
+	if merged, changed := mergeLabels(labels, c.owner(cfg).Labels()); changed {
+		return c.client.SetForwardingRuleLabels(ctx, cfg.Region, name, merged, fingerprint)
+	}
+	return nil
+}
+
+// stampAddress makes sure the address labels carry the current ownership
+// marker and config hash.
+func (c *gceCloud) stampAddress(ctx context.Context, cfg *Config, name string) error {
+	labels, fingerprint, err := c.client.GetAddressLabels(ctx, cfg.Region, name)
+	if err != nil {
+		return err
+	}
+	if merged, changed := mergeLabels(labels, c.owner(cfg).Labels()); changed {
+		return c.client.SetAddressLabels(ctx, cfg.Region, name, merged, fingerprint)
+	}
+	return nil
+}
+
+func mergeLabels(existing, marker map[string]string) (map[string]string, bool) {
+	merged := map[string]string{}
+	for k, v := range existing {
+		merged[k] = v
+	}
+	changed := false
+	for k, v := range marker {
+		if merged[k] != v {
+			merged[k] = v
+			changed = true
+		}
+	}
+	return merged, changed
+}
@@ -0,0 +1,81 @@
+package cloud
+
+import (
+	"testing"
+
+	"github.com/paulczar/gcp-lb-tags/pkg/cloud/gce"
+)
+
+func TestCheckOwner(t *testing.T) {
+	tests := []struct {
+		name      string
+		adopt     bool
+		owner     gce.Owner
+		managed   bool
+		wantErr   bool
+		wantOwner string
+	}{
+		{name: "ours", owner: gce.Owner{LB: "lb"}, managed: true},
+		{name: "unmarked", wantErr: true},
+		{name: "another load balancer's", owner: gce.Owner{LB: "other"}, managed: true, wantErr: true, wantOwner: "other"},
+		{name: "unmarked adopted", adopt: true},
+		{name: "another load balancer's adopted", adopt: true, owner: gce.Owner{LB: "other"}, managed: true},
+	}
+	for _, tt := range tests {
+		cfg := &Config{Name: "lb", Adopt: tt.adopt}
+		err := checkOwner(cfg, "firewall", "lb", tt.owner, tt.managed)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("%s: checkOwner() error = %v, want error %v", tt.name, err, tt.wantErr)
+			continue
+		}
+		if err == nil {
+			continue
+		}
+		nm, ok := err.(*NotManagedError)
+		if !ok {
+			t.Errorf("%s: checkOwner() error is a %T, want *NotManagedError", tt.name, err)
+			continue
+		}
+		if nm.Owner != tt.wantOwner {
+			t.Errorf("%s: checkOwner() owner = %q, want %q", tt.name, nm.Owner, tt.wantOwner)
+		}
+	}
+}
+
+func TestHash(t *testing.T) {
+	base := func() *Config {
+		return &Config{Name: "lb", ProjectID: "p", Region: "us-central1", Network: "default", Port: "8443",
+			Address: "lb", Labels: []string{"job:master", "deployment:one"}, Tags: []string{"b", "a"}}
+	}
+	h := base().Hash()
+	if len(h) != 12 {
+		t.Errorf("Hash() = %q, want 12 characters", h)
+	}
+
+	reordered := base()
+	reordered.Labels = []string{"deployment:one", "job:master"}
+	reordered.Tags = []string{"a", "b"}
+	if reordered.Hash() != h {
+		t.Error("Hash() depends on the order of the labels and tags")
+	}
+	tcp := base()
+	tcp.Mode = ModeTCP
+	if tcp.Hash() != h {
+		t.Error("Hash() changes when the default mode is spelled out")
+	}
+
+	for name, change := range map[string]func(cfg *Config){
+		"port":          func(cfg *Config) { cfg.Port = "443" },
+		"labels":        func(cfg *Config) { cfg.Labels = []string{"job:master"} },
+		"mode":          func(cfg *Config) { cfg.Mode = ModeUDP },
+		"ports":         func(cfg *Config) { cfg.Ports = []string{"8443", "9443"} },
+		"source ranges": func(cfg *Config) { cfg.SourceRanges = []string{"10.0.0.0/8"} },
+		"health check":  func(cfg *Config) { cfg.HealthCheckPort = "8080" },
+	} {
+		cfg := base()
+		change(cfg)
+		if cfg.Hash() == h {
+			t.Errorf("Hash() does not change with the %s", name)
+		}
+	}
+}
@@ -0,0 +1,66 @@
+package cloud
+
+import (
+	"net"
+
+	"github.com/paulczar/gcp-lb-tags/pkg/probe"
+)
+
+// applyProbes keeps selected instances that don't pass the controller's own
+// probes out of the plan. Current members start out passing, so a restart or
+// a new probe config only removes them once they fail. The prober is started
+// on first use and restarted when its config changes.
+func (c *gceCloud) applyProbes(cfg *Config, plan *membershipPlan) {
+	if cfg.Probe.Mode == "" {
+		if c.prober != nil {
+			c.prober.Stop()
+			c.prober = nil
+		}
+		return
+	}
+	pc := cfg.Probe
+	if pc.Port == "" {
+		pc.Port = cfg.Port
+	}
+	if c.prober == nil || c.proberConfig != pc {
+		if c.prober != nil {
+			c.prober.Stop()
+		}
+		c.prober = probe.New(pc)
+		c.proberConfig = pc
+		c.prober.Start()
+	}
+
+	addrs := map[string]string{}
+	for _, z := range c.zones {
+		for _, i := range c.instancesInZone[z] {
+			if plan.desired[i.SelfLink] && len(i.NetworkInterfaces) > 0 {
+				addrs[i.SelfLink] = net.JoinHostPort(i.NetworkInterfaces[0].NetworkIP, pc.Port)
+			}
+		}
+	}
+	in := plan.inPool()
+	targets, members := []string{}, []string{}
+	for i, a := range addrs {
+		targets = append(targets, a)
+		if in[i] {
+			members = append(members, a)
+		}
+	}
+	c.prober.SetTargets(targets, members)
+
+	for _, i := range plan.candidates {
+		a, ok := addrs[i]
+		if !ok {
+			continue
+		}
+		if healthy, err := c.prober.Healthy(a); !healthy {
+			plan.desired[i] = false
+			if err != nil {
+				plan.notes[i] = pc.Mode + " probe failing: " + err.Error()
+			} else {
+				plan.notes[i] = pc.Mode + " probe not passing yet"
+			}
+		}
+	}
+}
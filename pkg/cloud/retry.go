@@ -0,0 +1,121 @@
+package cloud
+
+import (
+	"fmt"
+	"math/rand"
+	"time"
+
+	"github.com/paulczar/gcp-lb-tags/pkg/cloud/gce"
+	"github.com/paulczar/gcp-lb-tags/pkg/metrics"
+)
+
+// Error classes of a failed reconcile.
+const (
+	// ErrorRetryable errors, such as rate limits and 5xx responses, are
+	// expected to go away on their own and are retried with backoff.
+	ErrorRetryable = "retryable"
+	// ErrorPermanent errors, such as a 403 or invalid config, need someone
+	// to fix them and are retried on the normal interval.
+	ErrorPermanent = "permanent"
+)
+
+var (
+	reconcileErrors = metrics.NewCounter("gcp_lb_tags_reconcile_errors_total",
+		"Failed reconciles by load balancer and error class.", "lb", "class")
+	consecutiveFailures = metrics.NewGauge("gcp_lb_tags_consecutive_failures",
+		"Reconciles in a row that have failed.", "lb")
+)
+
+// ErrorClass returns ErrorRetryable or ErrorPermanent for err.
+func ErrorClass(err error) string {
+	if gce.IsRetryable(err) {
+		return ErrorRetryable
+	}
+	return ErrorPermanent
+}
+
+// Backoff spaces out the retries of a failing reconcile.
+type Backoff struct {
+	// Initial is the delay after the first retryable failure, it doubles
+	// with every further failure.
+	Initial time.Duration `yaml:"initial,omitempty"`
+	// Max caps the delay, the loop interval is used when it is 0.
+	Max time.Duration `yaml:"max,omitempty"`
+	// MaxFailures consecutive failures give up on the load balancer, 0
+	// retries forever.
+	MaxFailures int `yaml:"maxFailures,omitempty"`
+}
+
+// GiveUpError is returned once a load balancer has failed MaxFailures
+// reconciles in a row.
+type GiveUpError struct {
+	LB       string
+	Failures int
+	Err      error
+}
+
+func (e *GiveUpError) Error() string {
+	return fmt.Sprintf("giving up on %s after %d failed reconciles in a row: %v", e.LB, e.Failures, e.Err)
+}
+
+// Failures tracks the consecutive failed reconciles of one load balancer.
+type Failures struct {
+	lb      string
+	backoff Backoff
+	count   int
+}
+
+// NewFailures returns a tracker for the load balancer named lb.
+func NewFailures(lb string, backoff Backoff) *Failures {
+	return &Failures{lb: lb, backoff: backoff}
+}
+
+// Record notes the result of a reconcile and returns how long to wait before
+// the next one: interval after a success or a permanent error, and a jittered
+// exponential delay after a retryable one. It returns a *GiveUpError once
+// MaxFailures reconciles in a row have failed.
+func (f *Failures) Record(err error, interval time.Duration) (time.Duration, error) {
+	if err == nil {
+		f.count = 0
+		consecutiveFailures.Set(0, f.lb)
+		return interval, nil
+	}
+	f.count++
+	class := ErrorClass(err)
+	reconcileErrors.Inc(f.lb, class)
+	consecutiveFailures.Set(float64(f.count), f.lb)
+
+	if f.backoff.MaxFailures > 0 && f.count >= f.backoff.MaxFailures {
+		return 0, &GiveUpError{LB: f.lb, Failures: f.count, Err: err}
+	}
+	if class == ErrorPermanent {
+		fmt.Printf("====> %s failed with a permanent error (%d in a row), fix it and it is retried in %s: %s\n", f.lb, f.count, interval, err)
+		return interval, nil
+	}
+	delay := f.delay(interval)
+	fmt.Printf("====> %s failed with a retryable error (%d in a row), retrying in %s: %s\n", f.lb, f.count, delay, err)
+	return delay, nil
+}
+
+// delay returns the backoff before the next retry, somewhere between half
+// and all of Initial doubled for every failure after the first.
+func (f *Failures) delay(interval time.Duration) time.Duration {
+	max := f.backoff.Max
+	if max <= 0 {
+		max = interval
+	}
+	d := f.backoff.Initial
+	if d <= 0 {
+		d = time.Second
+	}
+	for i := 1; i < f.count && d < max; i++ {
+		d *= 2
+	}
+	if d > max {
+		d = max
+	}
+	if d < 2 {
+		return d
+	}
+	return d/2 + time.Duration(rand.Int63n(int64(d/2)))
+}
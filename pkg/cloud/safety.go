@@ -0,0 +1,90 @@
+package cloud
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/paulczar/gcp-lb-tags/pkg/metrics"
+)
+
+var blockedChanges = metrics.NewCounter("gcp_lb_tags_blocked_removals_total",
+	"Target pool removals blocked by the safety limits.", "lb", "reason")
+
+// SafetyLimits stop a single reconcile from removing too many members, for
+// example after a mislabelled deploy.
+type SafetyLimits struct {
+	// MinMembers is the fewest members removals may leave in the target pool.
+	MinMembers int `yaml:"minMembers,omitempty"`
+	// MaxRemove caps the removals per reconcile, either a number such as
+	// "2" or a fraction of the current members such as "25%", rounded up.
+	MaxRemove string `yaml:"maxRemove,omitempty"`
+	// AllowEmpty lets a reconcile remove every member of the target pool.
+	AllowEmpty bool `yaml:"allowEmpty,omitempty"`
+	// Force ignores every limit for an intentional large change.
+	Force bool `yaml:"-"`
+}
+
+// maxRemovals returns how many of members may be removed, or -1 for no limit.
+func (s SafetyLimits) maxRemovals(members int) (int, error) {
+	if s.MaxRemove == "" {
+		return -1, nil
+	}
+	if strings.HasSuffix(s.MaxRemove, "%") {
+		pct, err := strconv.ParseFloat(strings.TrimSuffix(s.MaxRemove, "%"), 64)
+		if err != nil {
+			return 0, fmt.Errorf("invalid max removals %q: %v", s.MaxRemove, err)
+		}
+		// rounded up, so a small pool may still lose a member
+		return int(math.Ceil(float64(members) * pct / 100)), nil
+	}
+	n, err := strconv.Atoi(s.MaxRemove)
+	if err != nil {
+		return 0, fmt.Errorf("invalid max removals %q: %v", s.MaxRemove, err)
+	}
+	return n, nil
+}
+
+// applySafetyLimits keeps back removals that would break the limits in cfg.
+func (c *gceCloud) applySafetyLimits(cfg *Config, plan *membershipPlan) error {
+	limits := cfg.Safety
+	toDel := plan.toDel()
+	if len(toDel) == 0 {
+		return nil
+	}
+	if limits.Force {
+		fmt.Printf("====> Forcing removal of %d of %d members\n", len(toDel), len(plan.members))
+		return nil
+	}
+	max, err := limits.maxRemovals(len(plan.members))
+	if err != nil {
+		return err
+	}
+
+	block := func(i, reason, why string) {
+		plan.desired[i] = true
+		plan.notes[i] = "blocked: " + why
+		fmt.Printf("====> Refusing to remove %s from TargetPool: %s\n", i, why)
+		blockedChanges.Inc(cfg.Name, reason)
+	}
+
+	if plan.size() == 0 && !limits.AllowEmpty {
+		for _, i := range toDel {
+			block(i, "empty", "it would empty the target pool, use --force if that's intended")
+		}
+		return nil
+	}
+	removed := 0
+	for _, i := range toDel {
+		switch {
+		case max >= 0 && removed >= max:
+			block(i, "max-remove", fmt.Sprintf("already removing %d members this reconcile (limit %s)", removed, limits.MaxRemove))
+		case plan.size() < limits.MinMembers:
+			block(i, "min-members", fmt.Sprintf("the target pool would drop below %d members", limits.MinMembers))
+		default:
+			removed++
+		}
+	}
+	return nil
+}
@@ -0,0 +1,131 @@
+package cloud
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestMaxRemovals(t *testing.T) {
+	tests := []struct {
+		maxRemove string
+		members   int
+		want      int
+		wantErr   bool
+	}{
+		{"", 10, -1, false},
+		{"2", 10, 2, false},
+		{"0", 10, 0, false},
+		{"25%", 8, 2, false},
+		{"25%", 10, 3, false},
+		{"25%", 3, 1, false},
+		{"10%", 1, 1, false},
+		{"0%", 10, 0, false},
+		{"100%", 7, 7, false},
+		{"12.5%", 16, 2, false},
+		{"two", 10, 0, true},
+		{"x%", 10, 0, true},
+	}
+	for _, tt := range tests {
+		got, err := SafetyLimits{MaxRemove: tt.maxRemove}.maxRemovals(tt.members)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("maxRemovals(%q, %d) error = %v, want error %v", tt.maxRemove, tt.members, err, tt.wantErr)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("maxRemovals(%q, %d) = %d, want %d", tt.maxRemove, tt.members, got, tt.want)
+		}
+	}
+}
+
+// instances returns the self links i0 to i<n-1>.
+func instances(n int) []string {
+	links := []string{}
+	for i := 0; i < n; i++ {
+		links = append(links, fmt.Sprintf("i%d", i))
+	}
+	return links
+}
+
+func TestApplySafetyLimits(t *testing.T) {
+	tests := []struct {
+		name     string
+		safety   SafetyLimits
+		members  int
+		selected int
+		// wantDel are the removals left in the plan
+		wantDel []string
+	}{
+		{
+			name:     "no limits",
+			members:  4,
+			selected: 1,
+			wantDel:  []string{"i1", "i2", "i3"},
+		},
+		{
+			name:     "refuses to empty the pool",
+			members:  3,
+			selected: 0,
+			wantDel:  []string{},
+		},
+		{
+			name:     "empties the pool when allowed",
+			safety:   SafetyLimits{AllowEmpty: true},
+			members:  3,
+			selected: 0,
+			wantDel:  []string{"i0", "i1", "i2"},
+		},
+		{
+			name:     "force empties the pool",
+			safety:   SafetyLimits{Force: true, MaxRemove: "1", MinMembers: 2},
+			members:  3,
+			selected: 0,
+			wantDel:  []string{"i0", "i1", "i2"},
+		},
+		{
+			name:     "max remove count",
+			safety:   SafetyLimits{MaxRemove: "2"},
+			members:  6,
+			selected: 1,
+			wantDel:  []string{"i1", "i2"},
+		},
+		{
+			name:     "max remove percentage rounds up",
+			safety:   SafetyLimits{MaxRemove: "25%"},
+			members:  6,
+			selected: 1,
+			wantDel:  []string{"i1", "i2"},
+		},
+		{
+			name:     "min members",
+			safety:   SafetyLimits{MinMembers: 3},
+			members:  5,
+			selected: 1,
+			wantDel:  []string{"i3", "i4"},
+		},
+		{
+			name:     "min members and max remove",
+			safety:   SafetyLimits{MinMembers: 2, MaxRemove: "1"},
+			members:  5,
+			selected: 1,
+			wantDel:  []string{"i2"},
+		},
+	}
+	for _, tt := range tests {
+		cfg := &Config{Name: "lb", Safety: tt.safety}
+		plan := newMembershipPlan(instances(tt.members), instances(tt.selected))
+		if err := (&gceCloud{}).applySafetyLimits(cfg, plan); err != nil {
+			t.Errorf("%s: applySafetyLimits() error = %v", tt.name, err)
+			continue
+		}
+		if got := plan.toDel(); !reflect.DeepEqual(got, tt.wantDel) {
+			t.Errorf("%s: removals = %v, want %v", tt.name, got, tt.wantDel)
+		}
+		for _, i := range instances(tt.members)[tt.selected:] {
+			if plan.desired[i] && !strings.HasPrefix(plan.notes[i], "blocked: ") {
+				t.Errorf("%s: %s was kept with note %q", tt.name, i, plan.notes[i])
+			}
+		}
+	}
+}
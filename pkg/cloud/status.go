@@ -0,0 +1,179 @@
+package cloud
+
+import (
+	"context"
+	"fmt"
+	"sort"
+
+	"github.com/paulczar/gcp-lb-tags/pkg/cloud/gce"
+)
+
+// ManagedResource is a resource carrying a gcp-lb-tags ownership marker.
+type ManagedResource struct {
+	LB       string `json:"lb"`
+	Kind     string `json:"kind"`
+	Name     string `json:"name"`
+	Hash     string `json:"hash,omitempty"`
+	SelfLink string `json:"selfLink"`
+}
+
+// Status describes a managed load balancer as it currently exists in GCP.
+type Status struct {
+	Name     string          `json:"name"`
+	VIP      string          `json:"vip"`
+	Ports    string          `json:"ports"`
+	Protocol string          `json:"protocol"`
+	Members  []*MemberStatus `json:"members"`
+	// Missing lists instances matching the labels that aren't in the target pool.
+	Missing []*MemberStatus `json:"missing"`
+	// Excluded lists instances matching the labels that are kept out by ExcludeLabel.
+	Excluded []*MemberStatus `json:"excluded"`
+}
+
+// MemberStatus describes one instance of a load balancer.
+type MemberStatus struct {
+	Name     string `json:"name"`
+	Zone     string `json:"zone"`
+	Health   string `json:"health,omitempty"`
+	Override string `json:"override,omitempty"`
+	SelfLink string `json:"selfLink"`
+}
+
+// ListLoadBalancers returns every managed forwarding rule, target pool,
+// address and firewall in the project and region, sorted by load balancer.
+func (c *gceCloud) ListLoadBalancers(ctx context.Context, cfg *Config) ([]*ManagedResource, error) {
+	managed := []*ManagedResource{}
+
+	frs, err := c.client.ListForwardingRules(ctx, cfg.Region)
+	if err != nil {
+		return nil, err
+	}
+	frLabels, err := c.client.ListForwardingRuleLabels(ctx, cfg.Region)
+	if err != nil {
+		return nil, err
+	}
+	// imported target pools can't carry a marker, they belong to the
+	// managed forwarding rule pointing at them
+	poolOwners := map[string]gce.Owner{}
+	for _, fr := range frs {
+		o, ok := gce.OwnerFromLabels(frLabels[fr.Name])
+		if !ok {
+			o, ok = gce.OwnerFromDescription(fr.Description)
+		}
+		if ok {
+			managed = append(managed, &ManagedResource{LB: o.LB, Kind: "forwarding-rule", Name: fr.Name, Hash: o.Hash, SelfLink: fr.SelfLink})
+			poolOwners[fr.Target] = o
+		}
+	}
+
+	tps, err := c.client.ListTargetPools(ctx, cfg.Region)
+	if err != nil {
+		return nil, err
+	}
+	for _, tp := range tps {
+		o, ok := gce.OwnerFromDescription(tp.Description)
+		if !ok {
+			o, ok = poolOwners[tp.SelfLink]
+		}
+		if ok {
+			managed = append(managed, &ManagedResource{LB: o.LB, Kind: "target-pool", Name: tp.Name, Hash: o.Hash, SelfLink: tp.SelfLink})
+		}
+	}
+
+	addrs, err := c.client.ListExternalIPs(ctx, cfg.Region)
+	if err != nil {
+		return nil, err
+	}
+	addrLabels, err := c.client.ListAddressLabels(ctx, cfg.Region)
+	if err != nil {
+		return nil, err
+	}
+	for _, a := range addrs {
+		o, ok := gce.OwnerFromLabels(addrLabels[a.Name])
+		if !ok {
+			o, ok = gce.OwnerFromDescription(a.Description)
+		}
+		if ok {
+			managed = append(managed, &ManagedResource{LB: o.LB, Kind: "address", Name: a.Name, Hash: o.Hash, SelfLink: a.SelfLink})
+		}
+	}
+
+	fws, err := c.client.ListFirewalls(ctx)
+	if err != nil {
+		return nil, err
+	}
+	for _, fw := range fws {
+		if o, ok := gce.OwnerFromDescription(fw.Description); ok {
+			managed = append(managed, &ManagedResource{LB: o.LB, Kind: "firewall", Name: fw.Name, Hash: o.Hash, SelfLink: fw.SelfLink})
+		}
+	}
+
+	sort.SliceStable(managed, func(i, j int) bool {
+		return managed[i].LB < managed[j].LB
+	})
+	return managed, nil
+}
+
+// LoadBalancerStatus reports the VIP, target pool members and their health
+// for the load balancer named in cfg. Instances matching cfg.Labels that are
+// not in the target pool are reported as missing.
+func (c *gceCloud) LoadBalancerStatus(ctx context.Context, cfg *Config) (*Status, error) {
+	fr, err := c.client.GetForwardingRule(ctx, cfg.Region, cfg.Name)
+	if err != nil {
+		return nil, err
+	}
+	if fr == nil {
+		return nil, fmt.Errorf("forwarding rule %s not found in %s", cfg.Name, cfg.Region)
+	}
+	s := &Status{
+		Name:     cfg.Name,
+		VIP:      fr.IPAddress,
+		Ports:    fr.PortRange,
+		Protocol: fr.IPProtocol,
+		Members:  []*MemberStatus{},
+		Missing:  []*MemberStatus{},
+		Excluded: []*MemberStatus{},
+	}
+	overrides, err := c.listOverrides(ctx, cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	tp, err := c.client.GetTargetPool(ctx, cfg.Region, cfg.Name)
+	if err != nil {
+		return nil, err
+	}
+	inPool := map[string]bool{}
+	if tp != nil {
+		for _, i := range tp.Instances {
+			inPool[i] = true
+			zone, name := gce.ParseSelfLink(i)
+			m := &MemberStatus{Name: name, Zone: zone, SelfLink: i, Health: "UNKNOWN", Override: overrides[i]}
+			hs, err := c.client.GetTargetPoolHealth(ctx, cfg.Region, cfg.Name, i)
+			if err == nil && len(hs) > 0 {
+				m.Health = hs[0].HealthState
+			}
+			s.Members = append(s.Members, m)
+		}
+	}
+
+	if len(cfg.Labels) > 0 {
+		if err = c.listInstancesPerZone(ctx, cfg); err != nil {
+			return nil, err
+		}
+		for _, z := range c.zones {
+			for _, i := range c.instancesInZone[z] {
+				if inPool[i.SelfLink] {
+					continue
+				}
+				m := &MemberStatus{Name: i.Name, Zone: z, SelfLink: i.SelfLink, Override: overrides[i.SelfLink]}
+				if m.Override == overrideExcluded {
+					s.Excluded = append(s.Excluded, m)
+				} else {
+					s.Missing = append(s.Missing, m)
+				}
+			}
+		}
+	}
+	return s, nil
+}
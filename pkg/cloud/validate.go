@@ -0,0 +1,96 @@
+package cloud
+
+import (
+	"fmt"
+	"regexp"
+
+	"github.com/paulczar/gcp-lb-tags/pkg/probe"
+	"github.com/paulczar/gcp-lb-tags/pkg/validate"
+)
+
+var regionName = regexp.MustCompile(`^[a-z]+-[a-z]+[0-9]+$`)
+
+// Validate checks cfg before any API call is made and returns every problem
+// found as validate.Errors, with paths named after the config fields.
+func (cfg *Config) Validate() error {
+	errs := validate.Errors{}
+	cfg.ValidateInto(&errs, func(field string) string { return field })
+	return errs.Err()
+}
+
+// ValidateInto adds the problems found in cfg to errs. path turns the name
+// of a Config field, such as "labels[0]", into the path reported, so that
+// definitions read from elsewhere can report their own field names; an empty
+// path skips the problem.
+func (cfg *Config) ValidateInto(errs *validate.Errors, path func(field string) string) {
+	errs.Name(path("name"), cfg.Name)
+	if cfg.Address != "" && cfg.Address != cfg.Name {
+		errs.Name(path("address"), cfg.Address)
+	}
+	if cfg.ProjectID == "" {
+		errs.Add(path("project"), "is required")
+	}
+	if !regionName.MatchString(cfg.Region) {
+		errs.Add(path("region"), "%q is not a GCE region such as us-central1", cfg.Region)
+	}
+	for i, z := range cfg.Zones {
+		errs.Zone(path(fmt.Sprintf("zones[%d]", i)), z, cfg.Region)
+	}
+
+	switch cfg.mode() {
+	case ModeTCP, ModeUDP:
+	default:
+		errs.Add(path("mode"), "%q must be tcp or udp", cfg.Mode)
+	}
+	errs.PortRange(path("port"), cfg.Port)
+	for i, p := range cfg.Ports {
+		errs.PortRange(path(fmt.Sprintf("ports[%d]", i)), p)
+	}
+	for i, r := range cfg.SourceRanges {
+		errs.CIDR(path(fmt.Sprintf("sourceRanges[%d]", i)), r)
+	}
+	if len(cfg.Labels) == 0 {
+		errs.Add(path("labels"), "at least one label is required to select instances")
+	}
+	for i, l := range cfg.Labels {
+		errs.Label(path(fmt.Sprintf("labels[%d]", i)), l)
+	}
+	for i, t := range cfg.Tags {
+		errs.Name(path(fmt.Sprintf("tags[%d]", i)), t)
+	}
+
+	if cfg.HealthCheckPort != "" {
+		errs.Port(path("healthCheckPort"), cfg.HealthCheckPort)
+		if fw := cfg.healthCheckFirewall(); len(cfg.Name) <= validate.MaxNameLength && len(fw) > validate.MaxNameLength {
+			errs.Add(path("name"), "%q is too long to name the health check firewall rule %s, at most %d characters", cfg.Name, fw, validate.MaxNameLength)
+		}
+		if cfg.HealthCheckPath == "" || cfg.HealthCheckPath[0] != '/' {
+			errs.Add(path("healthCheckPath"), "%q must start with /", cfg.HealthCheckPath)
+		}
+	}
+	if cfg.Drain.FailHealthCheck && cfg.HealthCheckPort == "" {
+		errs.Add(path("drain.failHealthCheck"), "needs healthCheckPort")
+	}
+	if cfg.Drain.Label != "" {
+		if _, _, ok := splitLabel(cfg.Drain.Label); !ok {
+			errs.Add(path("drain.label"), "%q must be written key:value", cfg.Drain.Label)
+		}
+	}
+	if _, err := cfg.Safety.maxRemovals(1); err != nil {
+		errs.Add(path("safety.maxRemove"), "%s", err)
+	}
+	cfg.Cleanup.validateInto(errs, path)
+
+	switch cfg.Probe.Mode {
+	case "":
+	case probe.ModeTCP, probe.ModeTLS, probe.ModeHTTP, probe.ModeHTTPS:
+		if cfg.Probe.Port != "" {
+			errs.Port(path("probe.port"), cfg.Probe.Port)
+		} else if cfg.mode() == ModeUDP {
+			// the load balanced port can't be probed over TCP
+			errs.Add(path("probe.port"), "is required when mode is udp")
+		}
+	default:
+		errs.Add(path("probe.mode"), "%q must be tcp, tls, http or https", cfg.Probe.Mode)
+	}
+}
@@ -0,0 +1,183 @@
+package events
+
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+)
+
+// Instance methods that trigger a reconcile.
+const (
+	MethodInsert    = "insert"
+	MethodDelete    = "delete"
+	MethodSetLabels = "setLabels"
+	MethodStart     = "start"
+	MethodStop      = "stop"
+)
+
+// Event is a change to a compute instance read from an audit log sink or an
+// asset feed.
+type Event struct {
+	Method string
+	Zone   string
+	Name   string
+	// Labels and PriorLabels are the instance labels after and before the
+	// change, nil when the message doesn't carry them.
+	Labels      map[string]string
+	PriorLabels map[string]string
+}
+
+func (e *Event) String() string {
+	return e.Method + " of " + e.Zone + "/" + e.Name
+}
+
+// Affects reports whether e may change the membership of a load balancer in
+// region selecting instances by labels ("key:value"). Events that don't
+// carry labels affect every load balancer in the region.
+func (e *Event) Affects(region string, labels []string) bool {
+	if !strings.HasPrefix(e.Zone, region+"-") {
+		return false
+	}
+	if e.Labels == nil && e.PriorLabels == nil {
+		return true
+	}
+	return matches(e.Labels, labels) || matches(e.PriorLabels, labels)
+}
+
+func matches(have map[string]string, labels []string) bool {
+	if have == nil {
+		return false
+	}
+	for _, l := range labels {
+		s := strings.SplitN(l, ":", 2)
+		if len(s) != 2 || have[s[0]] != s[1] {
+			return false
+		}
+	}
+	return true
+}
+
+// auditLogEntry is the part of a Cloud Audit Log entry read from a log sink.
+type auditLogEntry struct {
+	ProtoPayload *struct {
+		MethodName   string `json:"methodName"`
+		ResourceName string `json:"resourceName"`
+	} `json:"protoPayload"`
+	Operation *struct {
+		Last bool `json:"last"`
+	} `json:"operation"`
+}
+
+// assetFeedMessage is the part of a Cloud Asset feed message we use.
+type assetFeedMessage struct {
+	Asset      *asset `json:"asset"`
+	PriorAsset *asset `json:"priorAsset"`
+	Deleted    bool   `json:"deleted"`
+}
+
+type asset struct {
+	Name      string `json:"name"`
+	AssetType string `json:"assetType"`
+	Resource  *struct {
+		Data struct {
+			Labels map[string]string `json:"labels"`
+			Status string            `json:"status"`
+		} `json:"data"`
+	} `json:"resource"`
+}
+
+// Parse reads an audit log entry or an asset feed message. It returns false
+// for messages that aren't about one of the instance methods above.
+func Parse(data []byte) (*Event, bool) {
+	var entry auditLogEntry
+	if err := json.Unmarshal(data, &entry); err == nil && entry.ProtoPayload != nil {
+		return parseAuditLog(&entry)
+	}
+	var msg assetFeedMessage
+	if err := json.Unmarshal(data, &msg); err == nil && msg.Asset != nil {
+		return parseAssetFeed(&msg)
+	}
+	return nil, false
+}
+
+func parseAuditLog(entry *auditLogEntry) (*Event, bool) {
+	// long running operations log a first and a last entry, the instance
+	// is only worth looking at once the operation has finished
+	if entry.Operation != nil && !entry.Operation.Last {
+		return nil, false
+	}
+	m := entry.ProtoPayload.MethodName
+	i := strings.Index(m, "compute.instances.")
+	if i < 0 {
+		return nil, false
+	}
+	method := m[i+len("compute.instances."):]
+	switch method {
+	case MethodInsert, MethodDelete, MethodSetLabels, MethodStart, MethodStop:
+	default:
+		return nil, false
+	}
+	zone, name, ok := parseInstanceName(entry.ProtoPayload.ResourceName)
+	if !ok {
+		return nil, false
+	}
+	return &Event{Method: method, Zone: zone, Name: name}, true
+}
+
+func parseAssetFeed(msg *assetFeedMessage) (*Event, bool) {
+	if msg.Asset.AssetType != "compute.googleapis.com/Instance" {
+		return nil, false
+	}
+	zone, name, ok := parseInstanceName(msg.Asset.Name)
+	if !ok {
+		return nil, false
+	}
+	e := &Event{Zone: zone, Name: name}
+	if msg.Asset.Resource != nil {
+		e.Labels = labelsOrEmpty(msg.Asset.Resource.Data.Labels)
+	}
+	if msg.PriorAsset != nil && msg.PriorAsset.Resource != nil {
+		e.PriorLabels = labelsOrEmpty(msg.PriorAsset.Resource.Data.Labels)
+	}
+
+	switch {
+	case msg.Deleted:
+		e.Method = MethodDelete
+	case msg.PriorAsset == nil || msg.PriorAsset.Resource == nil:
+		e.Method = MethodInsert
+	case msg.Asset.Resource == nil:
+		return nil, false
+	case !reflect.DeepEqual(e.Labels, e.PriorLabels):
+		e.Method = MethodSetLabels
+	case msg.Asset.Resource.Data.Status == msg.PriorAsset.Resource.Data.Status:
+		// some other update of the instance
+		return nil, false
+	case msg.Asset.Resource.Data.Status == "RUNNING":
+		e.Method = MethodStart
+	case msg.PriorAsset.Resource.Data.Status == "RUNNING":
+		e.Method = MethodStop
+	default:
+		return nil, false
+	}
+	return e, true
+}
+
+func labelsOrEmpty(l map[string]string) map[string]string {
+	if l == nil {
+		return map[string]string{}
+	}
+	return l
+}
+
+// parseInstanceName reads the zone and name from an instance resource name
+// such as projects/p/zones/z/instances/n or its full //compute.googleapis.com
+// form.
+func parseInstanceName(name string) (zone, instance string, ok bool) {
+	parts := strings.Split(name, "/")
+	for i := 0; i+3 < len(parts); i++ {
+		if parts[i] == "zones" && parts[i+2] == "instances" {
+			return parts[i+1], parts[i+3], true
+		}
+	}
+	return "", "", false
+}
@@ -0,0 +1,99 @@
+package events
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		name string
+		data string
+		want *Event
+	}{
+		{
+			name: "audit log insert",
+			data: `{"protoPayload":{"methodName":"v1.compute.instances.insert","resourceName":"projects/p/zones/us-central1-a/instances/master-0"},"operation":{"last":true}}`,
+			want: &Event{Method: MethodInsert, Zone: "us-central1-a", Name: "master-0"},
+		},
+		{
+			name: "audit log setLabels without operation",
+			data: `{"protoPayload":{"methodName":"beta.compute.instances.setLabels","resourceName":"projects/p/zones/us-central1-b/instances/master-1"}}`,
+			want: &Event{Method: MethodSetLabels, Zone: "us-central1-b", Name: "master-1"},
+		},
+		{
+			name: "audit log first entry of an operation",
+			data: `{"protoPayload":{"methodName":"v1.compute.instances.delete","resourceName":"projects/p/zones/us-central1-a/instances/master-0"},"operation":{"first":true}}`,
+		},
+		{
+			name: "audit log other method",
+			data: `{"protoPayload":{"methodName":"v1.compute.instances.setMetadata","resourceName":"projects/p/zones/us-central1-a/instances/master-0"}}`,
+		},
+		{
+			name: "audit log other resource",
+			data: `{"protoPayload":{"methodName":"v1.compute.disks.insert","resourceName":"projects/p/zones/us-central1-a/disks/d"}}`,
+		},
+		{
+			name: "asset feed new instance",
+			data: `{"asset":{"name":"//compute.googleapis.com/projects/p/zones/us-central1-a/instances/master-0","assetType":"compute.googleapis.com/Instance","resource":{"data":{"labels":{"job":"master"},"status":"RUNNING"}}}}`,
+			want: &Event{Method: MethodInsert, Zone: "us-central1-a", Name: "master-0", Labels: map[string]string{"job": "master"}},
+		},
+		{
+			name: "asset feed deleted instance",
+			data: `{"asset":{"name":"//compute.googleapis.com/projects/p/zones/us-central1-a/instances/master-0","assetType":"compute.googleapis.com/Instance"},"priorAsset":{"name":"//compute.googleapis.com/projects/p/zones/us-central1-a/instances/master-0","assetType":"compute.googleapis.com/Instance","resource":{"data":{"labels":{"job":"master"},"status":"RUNNING"}}},"deleted":true}`,
+			want: &Event{Method: MethodDelete, Zone: "us-central1-a", Name: "master-0", PriorLabels: map[string]string{"job": "master"}},
+		},
+		{
+			name: "asset feed relabelled instance",
+			data: `{"asset":{"name":"//compute.googleapis.com/projects/p/zones/us-central1-a/instances/master-0","assetType":"compute.googleapis.com/Instance","resource":{"data":{"status":"RUNNING"}}},"priorAsset":{"name":"//compute.googleapis.com/projects/p/zones/us-central1-a/instances/master-0","assetType":"compute.googleapis.com/Instance","resource":{"data":{"labels":{"job":"master"},"status":"RUNNING"}}}}`,
+			want: &Event{Method: MethodSetLabels, Zone: "us-central1-a", Name: "master-0", Labels: map[string]string{}, PriorLabels: map[string]string{"job": "master"}},
+		},
+		{
+			name: "asset feed stopped instance",
+			data: `{"asset":{"name":"//compute.googleapis.com/projects/p/zones/us-central1-a/instances/master-0","assetType":"compute.googleapis.com/Instance","resource":{"data":{"labels":{"job":"master"},"status":"TERMINATED"}}},"priorAsset":{"name":"//compute.googleapis.com/projects/p/zones/us-central1-a/instances/master-0","assetType":"compute.googleapis.com/Instance","resource":{"data":{"labels":{"job":"master"},"status":"RUNNING"}}}}`,
+			want: &Event{Method: MethodStop, Zone: "us-central1-a", Name: "master-0", Labels: map[string]string{"job": "master"}, PriorLabels: map[string]string{"job": "master"}},
+		},
+		{
+			name: "asset feed other update",
+			data: `{"asset":{"name":"//compute.googleapis.com/projects/p/zones/us-central1-a/instances/master-0","assetType":"compute.googleapis.com/Instance","resource":{"data":{"labels":{"job":"master"},"status":"RUNNING"}}},"priorAsset":{"name":"//compute.googleapis.com/projects/p/zones/us-central1-a/instances/master-0","assetType":"compute.googleapis.com/Instance","resource":{"data":{"labels":{"job":"master"},"status":"RUNNING"}}}}`,
+		},
+		{
+			name: "asset feed other asset type",
+			data: `{"asset":{"name":"//compute.googleapis.com/projects/p/zones/us-central1-a/disks/d","assetType":"compute.googleapis.com/Disk"}}`,
+		},
+		{
+			name: "not json",
+			data: `instance created`,
+		},
+	}
+	for _, tt := range tests {
+		got, ok := Parse([]byte(tt.data))
+		if ok != (tt.want != nil) {
+			t.Errorf("%s: Parse() ok = %v, want %v", tt.name, ok, tt.want != nil)
+			continue
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: Parse() = %+v, want %+v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestAffects(t *testing.T) {
+	labels := []string{"job:master"}
+	tests := []struct {
+		name  string
+		event *Event
+		want  bool
+	}{
+		{"other region", &Event{Zone: "europe-west1-b"}, false},
+		{"no labels", &Event{Zone: "us-central1-a"}, true},
+		{"matching labels", &Event{Zone: "us-central1-a", Labels: map[string]string{"job": "master"}}, true},
+		{"labels removed", &Event{Zone: "us-central1-a", Labels: map[string]string{}, PriorLabels: map[string]string{"job": "master"}}, true},
+		{"other labels", &Event{Zone: "us-central1-a", Labels: map[string]string{"job": "worker"}}, false},
+	}
+	for _, tt := range tests {
+		if got := tt.event.Affects("us-central1", labels); got != tt.want {
+			t.Errorf("%s: Affects() = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,138 @@
+package events
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"golang.org/x/oauth2/google"
+)
+
+const (
+	pubsubScope    = "https://www.googleapis.com/auth/pubsub"
+	pubsubEndpoint = "https://pubsub.googleapis.com/v1/"
+	// EmulatorHostEnv points the subscriber at a Pub/Sub emulator, as it
+	// does for the gcloud tooling.
+	EmulatorHostEnv = "PUBSUB_EMULATOR_HOST"
+
+	pullMaxMessages = 100
+	pullRetryDelay  = 5 * time.Second
+)
+
+// Subscriber pulls instance events from a Pub/Sub subscription through the
+// Pub/Sub REST API.
+type Subscriber struct {
+	client       *http.Client
+	endpoint     string
+	subscription string
+}
+
+// NewSubscriber returns a subscriber for subscription, given either by name
+// in project or as projects/<project>/subscriptions/<name>. When
+// PUBSUB_EMULATOR_HOST is set the emulator is used without credentials.
+func NewSubscriber(ctx context.Context, project, subscription string) (*Subscriber, error) {
+	if !strings.HasPrefix(subscription, "projects/") {
+		subscription = fmt.Sprintf("projects/%s/subscriptions/%s", project, subscription)
+	}
+	s := &Subscriber{subscription: subscription}
+	if host := os.Getenv(EmulatorHostEnv); host != "" {
+		s.client = http.DefaultClient
+		s.endpoint = "http://" + host + "/v1/"
+		return s, nil
+	}
+	client, err := google.DefaultClient(ctx, pubsubScope)
+	if err != nil {
+		return nil, err
+	}
+	s.client = client
+	s.endpoint = pubsubEndpoint
+	return s, nil
+}
+
+type receivedMessage struct {
+	AckID   string `json:"ackId"`
+	Message struct {
+		Data      string `json:"data"`
+		MessageID string `json:"messageId"`
+	} `json:"message"`
+}
+
+// Run pulls messages until ctx is cancelled and calls handle with every
+// instance event. Every message is acknowledged, including the ones that
+// aren't instance events, so they aren't redelivered.
+func (s *Subscriber) Run(ctx context.Context, handle func(*Event)) {
+	for ctx.Err() == nil {
+		msgs, err := s.pull(ctx)
+		if ctx.Err() != nil {
+			return
+		}
+		if err != nil {
+			fmt.Printf("====> Pulling from %s failed: %s\n", s.subscription, err)
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(pullRetryDelay):
+			}
+			continue
+		}
+		ackIDs := []string{}
+		for _, m := range msgs {
+			ackIDs = append(ackIDs, m.AckID)
+			data, err := base64.StdEncoding.DecodeString(m.Message.Data)
+			if err != nil {
+				fmt.Printf("====> Ignoring message %s: %s\n", m.Message.MessageID, err)
+				continue
+			}
+			if e, ok := Parse(data); ok {
+				handle(e)
+			}
+		}
+		if len(ackIDs) > 0 {
+			if err = s.acknowledge(ctx, ackIDs); err != nil {
+				fmt.Printf("====> Acknowledging messages on %s failed: %s\n", s.subscription, err)
+			}
+		}
+	}
+}
+
+func (s *Subscriber) pull(ctx context.Context) ([]*receivedMessage, error) {
+	var out struct {
+		ReceivedMessages []*receivedMessage `json:"receivedMessages"`
+	}
+	err := s.post(ctx, ":pull", map[string]interface{}{"maxMessages": pullMaxMessages}, &out)
+	return out.ReceivedMessages, err
+}
+
+func (s *Subscriber) acknowledge(ctx context.Context, ackIDs []string) error {
+	return s.post(ctx, ":acknowledge", map[string]interface{}{"ackIds": ackIDs}, nil)
+}
+
+func (s *Subscriber) post(ctx context.Context, verb string, in, out interface{}) error {
+	body, err := json.Marshal(in)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.endpoint+s.subscription+verb, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s%s: %s", s.subscription, verb, resp.Status)
+	}
+	if out == nil {
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
@@ -0,0 +1,86 @@
+package events
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// TestSubscriberEmulator runs a subscriber against the Pub/Sub emulator. It
+// is skipped unless PUBSUB_EMULATOR_HOST is set, e.g. with
+//
+//	gcloud beta emulators pubsub start --host-port=localhost:8085 &
+//	PUBSUB_EMULATOR_HOST=localhost:8085 go test ./pkg/events/
+func TestSubscriberEmulator(t *testing.T) {
+	host := os.Getenv(EmulatorHostEnv)
+	if host == "" {
+		t.Skip(EmulatorHostEnv + " is not set")
+	}
+	project := "gcp-lb-tags-test"
+	name := fmt.Sprintf("instance-events-%d", time.Now().UnixNano())
+	topic := "projects/" + project + "/topics/" + name
+	subscription := "projects/" + project + "/subscriptions/" + name
+
+	emulator := func(method, path string, in interface{}) {
+		body := []byte{}
+		if in != nil {
+			var err error
+			if body, err = json.Marshal(in); err != nil {
+				t.Fatal(err)
+			}
+		}
+		req, err := http.NewRequest(method, "http://"+host+"/v1/"+path, bytes.NewReader(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set("Content-Type", "application/json")
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("%s %s: %s", method, path, resp.Status)
+		}
+	}
+	emulator(http.MethodPut, topic, map[string]interface{}{})
+	emulator(http.MethodPut, subscription, map[string]interface{}{"topic": topic})
+	defer emulator(http.MethodDelete, subscription, nil)
+	defer emulator(http.MethodDelete, topic, nil)
+
+	messages := []string{
+		`not an instance event`,
+		`{"protoPayload":{"methodName":"v1.compute.instances.insert","resourceName":"projects/p/zones/us-central1-a/instances/master-0"},"operation":{"last":true}}`,
+	}
+	publish := []map[string]string{}
+	for _, m := range messages {
+		publish = append(publish, map[string]string{"data": base64.StdEncoding.EncodeToString([]byte(m))})
+	}
+	emulator(http.MethodPost, topic+":publish", map[string]interface{}{"messages": publish})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	s, err := NewSubscriber(ctx, project, name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got *Event
+	s.Run(ctx, func(e *Event) {
+		got = e
+		cancel()
+	})
+	want := &Event{Method: MethodInsert, Zone: "us-central1-a", Name: "master-0"}
+	if got == nil {
+		t.Fatal("no event received before the timeout")
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("received %+v, want %+v", got, want)
+	}
+}
@@ -0,0 +1,113 @@
+// Package kube talks to the API server of the Kubernetes cluster gcp-lb-tags
+// runs in, with the pod's service account. It only knows the few calls
+// leader election and sharding make.
+package kube
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+// serviceAccountDir is where Kubernetes mounts the token, CA and namespace
+// of a pod's service account.
+const serviceAccountDir = "/var/run/secrets/kubernetes.io/serviceaccount"
+
+// ErrConflict is returned when an object was changed, or created, since it
+// was read.
+var ErrConflict = errors.New("the object changed since it was read")
+
+// Client is a client of the API server.
+type Client struct {
+	// Namespace is the namespace the client works in.
+	Namespace string
+	host      string
+	client    *http.Client
+}
+
+// InCluster returns a client of the cluster the pod runs in, working in
+// namespace, or in the pod's own namespace when namespace is empty.
+func InCluster(namespace string) (*Client, error) {
+	host, port := os.Getenv("KUBERNETES_SERVICE_HOST"), os.Getenv("KUBERNETES_SERVICE_PORT")
+	if host == "" || port == "" {
+		return nil, fmt.Errorf("not running in a Kubernetes pod, KUBERNETES_SERVICE_HOST and KUBERNETES_SERVICE_PORT are not set")
+	}
+	if namespace == "" {
+		ns, err := ioutil.ReadFile(serviceAccountDir + "/namespace")
+		if err != nil {
+			return nil, fmt.Errorf("reading the pod's namespace: %s", err)
+		}
+		namespace = strings.TrimSpace(string(ns))
+	}
+	ca, err := ioutil.ReadFile(serviceAccountDir + "/ca.crt")
+	if err != nil {
+		return nil, fmt.Errorf("reading the cluster CA: %s", err)
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(ca) {
+		return nil, fmt.Errorf("no certificate found in %s/ca.crt", serviceAccountDir)
+	}
+	return &Client{
+		Namespace: namespace,
+		host:      "https://" + net.JoinHostPort(host, port),
+		client: &http.Client{
+			Timeout:   30 * time.Second,
+			Transport: &http.Transport{TLSClientConfig: &tls.Config{RootCAs: pool}},
+		},
+	}, nil
+}
+
+// Do sends in, when set, to path and decodes the response into out, when
+// set. It returns false when a GET or DELETE finds nothing, and ErrConflict
+// on a 409.
+func (c *Client) Do(ctx context.Context, method, path string, in, out interface{}) (bool, error) {
+	var body []byte
+	if in != nil {
+		var err error
+		if body, err = json.Marshal(in); err != nil {
+			return false, err
+		}
+	}
+	req, err := http.NewRequestWithContext(ctx, method, c.host+path, bytes.NewReader(body))
+	if err != nil {
+		return false, err
+	}
+	// the token is read every time, projected tokens are rotated
+	token, err := ioutil.ReadFile(serviceAccountDir + "/token")
+	if err != nil {
+		return false, fmt.Errorf("reading the service account token: %s", err)
+	}
+	req.Header.Set("Authorization", "Bearer "+strings.TrimSpace(string(token)))
+	req.Header.Set("Accept", "application/json")
+	if in != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	res, err := c.client.Do(req)
+	if err != nil {
+		return false, err
+	}
+	defer res.Body.Close()
+	switch {
+	case res.StatusCode == http.StatusNotFound && (method == http.MethodGet || method == http.MethodDelete):
+		return false, nil
+	case res.StatusCode == http.StatusConflict:
+		return false, ErrConflict
+	case res.StatusCode >= 300:
+		msg, _ := ioutil.ReadAll(res.Body)
+		return false, fmt.Errorf("%s %s: %s: %s", method, path, res.Status, bytes.TrimSpace(msg))
+	}
+	if out != nil {
+		return true, json.NewDecoder(res.Body).Decode(out)
+	}
+	return true, nil
+}
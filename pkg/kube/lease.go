@@ -0,0 +1,114 @@
+package kube
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// MicroTime is how the API writes the times of a Lease.
+const MicroTime = "2006-01-02T15:04:05.000000Z07:00"
+
+// Lease is a coordination.k8s.io/v1 Lease.
+type Lease struct {
+	APIVersion string        `json:"apiVersion"`
+	Kind       string        `json:"kind"`
+	Metadata   LeaseMetadata `json:"metadata"`
+	Spec       LeaseSpec     `json:"spec"`
+}
+
+// LeaseMetadata is the part of a Lease's metadata gcp-lb-tags uses.
+type LeaseMetadata struct {
+	Name            string            `json:"name"`
+	Namespace       string            `json:"namespace"`
+	ResourceVersion string            `json:"resourceVersion,omitempty"`
+	Labels          map[string]string `json:"labels,omitempty"`
+	Annotations     map[string]string `json:"annotations,omitempty"`
+}
+
+// LeaseSpec is the spec of a Lease.
+type LeaseSpec struct {
+	HolderIdentity       *string `json:"holderIdentity"`
+	LeaseDurationSeconds *int    `json:"leaseDurationSeconds,omitempty"`
+	AcquireTime          *string `json:"acquireTime,omitempty"`
+	RenewTime            *string `json:"renewTime,omitempty"`
+}
+
+// NewLease returns a Lease named name held by holder, renewed at renewed
+// and lasting lease.
+func NewLease(name, holder string, renewed time.Time, lease time.Duration) *Lease {
+	seconds := int(lease / time.Second)
+	renewTime := renewed.UTC().Format(MicroTime)
+	return &Lease{
+		APIVersion: "coordination.k8s.io/v1",
+		Kind:       "Lease",
+		Metadata:   LeaseMetadata{Name: name},
+		Spec: LeaseSpec{
+			HolderIdentity:       &holder,
+			LeaseDurationSeconds: &seconds,
+			RenewTime:            &renewTime,
+		},
+	}
+}
+
+// Holder returns the holder of the lease, when it was renewed and how long
+// it lasts; zero values for what isn't set.
+func (l *Lease) Holder() (string, time.Time, time.Duration) {
+	holder, renewed, lease := "", time.Time{}, time.Duration(0)
+	if l.Spec.HolderIdentity != nil {
+		holder = *l.Spec.HolderIdentity
+	}
+	if l.Spec.RenewTime != nil {
+		renewed, _ = time.Parse(MicroTime, *l.Spec.RenewTime)
+	}
+	if l.Spec.LeaseDurationSeconds != nil {
+		lease = time.Duration(*l.Spec.LeaseDurationSeconds) * time.Second
+	}
+	return holder, renewed, lease
+}
+
+func (c *Client) leasesPath(name string) string {
+	p := "/apis/coordination.k8s.io/v1/namespaces/" + c.Namespace + "/leases"
+	if name != "" {
+		p += "/" + name
+	}
+	return p
+}
+
+// GetLease returns the Lease name, nil when it doesn't exist.
+func (c *Client) GetLease(ctx context.Context, name string) (*Lease, error) {
+	l := &Lease{}
+	found, err := c.Do(ctx, http.MethodGet, c.leasesPath(name), nil, l)
+	if err != nil || !found {
+		return nil, err
+	}
+	return l, nil
+}
+
+// ListLeases returns the Leases matching the label selector.
+func (c *Client) ListLeases(ctx context.Context, selector string) ([]*Lease, error) {
+	var list struct {
+		Items []*Lease `json:"items"`
+	}
+	_, err := c.Do(ctx, http.MethodGet, c.leasesPath("")+"?labelSelector="+url.QueryEscape(selector), nil, &list)
+	return list.Items, err
+}
+
+// PutLease creates l when it has no resource version and updates it
+// otherwise, returning ErrConflict when it changed since it was read.
+func (c *Client) PutLease(ctx context.Context, l *Lease) error {
+	l.Metadata.Namespace = c.Namespace
+	method, path := http.MethodPut, c.leasesPath(l.Metadata.Name)
+	if l.Metadata.ResourceVersion == "" {
+		method, path = http.MethodPost, c.leasesPath("")
+	}
+	_, err := c.Do(ctx, method, path, l, nil)
+	return err
+}
+
+// DeleteLease deletes the Lease name, if it exists.
+func (c *Client) DeleteLease(ctx context.Context, name string) error {
+	_, err := c.Do(ctx, http.MethodDelete, c.leasesPath(name), nil, nil)
+	return err
+}
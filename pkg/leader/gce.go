@@ -0,0 +1,109 @@
+package leader
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/paulczar/gcp-lb-tags/pkg/cloud/gce"
+	"google.golang.org/api/googleapi"
+)
+
+// Labels of the address a GCELock keeps the lease in.
+const (
+	holderLabel   = "gcp-lb-tags-leader"
+	acquiredLabel = "gcp-lb-tags-leader-acquired"
+	renewedLabel  = "gcp-lb-tags-leader-renewed"
+	leaseLabel    = "gcp-lb-tags-leader-lease"
+)
+
+// GCELock keeps the lease in the labels of an external address, reserved
+// the first time a replica takes the lease, so replicas outside Kubernetes
+// need nothing but the GCE API. The address is billed as an unattached static
+// IP and is never released; deleting it is left to the operator. The label
+// fingerprint guards every update: setLabels fails when the labels changed
+// since they were read. Times are kept in unix seconds.
+type GCELock struct {
+	Client *gce.GCEClient
+	Region string
+	Name   string
+	// labels are the labels last read, other labels of the address are
+	// kept as they are
+	labels map[string]string
+}
+
+func (l *GCELock) String() string {
+	return fmt.Sprintf("address %s/%s", l.Region, l.Name)
+}
+
+// Get returns the record kept in the address's labels, nil when it has none.
+// The version is empty when the address doesn't exist yet.
+func (l *GCELock) Get(ctx context.Context) (*Record, string, error) {
+	labels, fingerprint, err := l.Client.GetAddressLabels(ctx, l.Region, l.Name)
+	if isCode(err, http.StatusNotFound) {
+		l.labels = nil
+		return nil, "", nil
+	}
+	if err != nil {
+		return nil, "", err
+	}
+	l.labels = labels
+	if _, ok := labels[holderLabel]; !ok {
+		return nil, fingerprint, nil
+	}
+	unix := func(key string) time.Time {
+		s, _ := strconv.ParseInt(labels[key], 10, 64)
+		return time.Unix(s, 0)
+	}
+	seconds, _ := strconv.Atoi(labels[leaseLabel])
+	return &Record{
+		Holder:   labels[holderLabel],
+		Acquired: unix(acquiredLabel),
+		Renewed:  unix(renewedLabel),
+		Lease:    time.Duration(seconds) * time.Second,
+	}, fingerprint, nil
+}
+
+// Put sets the record in the address's labels if their fingerprint is still
+// version, reserving the address first when version is empty. The wait on
+// the setLabels operation ends with ctx, so a renewal never outlasts the
+// deadline the Elector gives it.
+func (l *GCELock) Put(ctx context.Context, r *Record, version string) error {
+	if version == "" {
+		_, err := l.Client.CreateExternalIP(ctx, l.Region, l.Name, "gcp-lb-tags leader election lock")
+		if isCode(err, http.StatusConflict) {
+			// another replica reserved it first
+			return ErrConflict
+		}
+		if err != nil {
+			return fmt.Errorf("reserving %s: %s", l, err)
+		}
+		if _, version, err = l.Client.GetAddressLabels(ctx, l.Region, l.Name); err != nil {
+			return err
+		}
+	}
+	labels := map[string]string{}
+	for k, v := range l.labels {
+		labels[k] = v
+	}
+	labels[holderLabel] = r.Holder
+	labels[acquiredLabel] = strconv.FormatInt(r.Acquired.Unix(), 10)
+	labels[renewedLabel] = strconv.FormatInt(r.Renewed.Unix(), 10)
+	labels[leaseLabel] = strconv.Itoa(int(r.Lease / time.Second))
+	if r.Acquired.IsZero() {
+		delete(labels, acquiredLabel)
+	}
+	err := l.Client.SetAddressLabels(ctx, l.Region, l.Name, labels, version)
+	if isCode(err, http.StatusPreconditionFailed) {
+		return ErrConflict
+	}
+	return err
+}
+
+func isCode(err error, code int) bool {
+	var apiErr *googleapi.Error
+	return errors.As(err, &apiErr) && apiErr.Code == code
+}
@@ -0,0 +1,60 @@
+package leader
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/paulczar/gcp-lb-tags/pkg/kube"
+)
+
+// KubernetesLock keeps the lease in a coordination.k8s.io/v1 Lease of the
+// cluster gcp-lb-tags runs in. The Lease's resourceVersion guards every
+// update.
+type KubernetesLock struct {
+	Name   string
+	client *kube.Client
+}
+
+// NewKubernetesLock returns the lock of the Lease name in namespace, or in
+// the pod's own namespace when namespace is empty.
+func NewKubernetesLock(namespace, name string) (*KubernetesLock, error) {
+	client, err := kube.InCluster(namespace)
+	if err != nil {
+		return nil, err
+	}
+	return &KubernetesLock{Name: name, client: client}, nil
+}
+
+func (l *KubernetesLock) String() string {
+	return fmt.Sprintf("Lease %s/%s", l.client.Namespace, l.Name)
+}
+
+// Get returns the record of the Lease, nil when it doesn't exist yet.
+func (l *KubernetesLock) Get(ctx context.Context) (*Record, string, error) {
+	ls, err := l.client.GetLease(ctx, l.Name)
+	if err != nil || ls == nil {
+		return nil, "", err
+	}
+	r := &Record{}
+	r.Holder, r.Renewed, r.Lease = ls.Holder()
+	if ls.Spec.AcquireTime != nil {
+		r.Acquired, _ = time.Parse(kube.MicroTime, *ls.Spec.AcquireTime)
+	}
+	return r, ls.Metadata.ResourceVersion, nil
+}
+
+// Put creates the Lease when version is empty and updates it otherwise.
+func (l *KubernetesLock) Put(ctx context.Context, r *Record, version string) error {
+	ls := kube.NewLease(l.Name, r.Holder, r.Renewed, r.Lease)
+	ls.Metadata.ResourceVersion = version
+	if !r.Acquired.IsZero() {
+		acquired := r.Acquired.UTC().Format(kube.MicroTime)
+		ls.Spec.AcquireTime = &acquired
+	}
+	err := l.client.PutLease(ctx, ls)
+	if err == kube.ErrConflict {
+		return ErrConflict
+	}
+	return err
+}
@@ -0,0 +1,231 @@
+// Package leader elects a single replica of gcp-lb-tags to reconcile, so
+// that several can run for availability without fighting over the same load
+// balancers. The lease is kept in a Lock, such as a Kubernetes Lease or the
+// labels of a GCE address.
+package leader
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/paulczar/gcp-lb-tags/pkg/metrics"
+)
+
+// ErrConflict is returned by Lock.Put when the record changed since it was
+// read.
+var ErrConflict = errors.New("the lock changed since it was read")
+
+var isLeader = metrics.NewGauge("gcp_lb_tags_leader",
+	"1 while this replica holds the leader lease, 0 otherwise.")
+
+// Record is who holds the lease, and until when.
+type Record struct {
+	// Holder is the identity of the leader, empty once it released the lease.
+	Holder   string
+	Acquired time.Time
+	Renewed  time.Time
+	Lease    time.Duration
+}
+
+// Expired tells whether the lease is free to take at now.
+func (r *Record) Expired(now time.Time) bool {
+	return r == nil || r.Holder == "" || now.After(r.Renewed.Add(r.Lease))
+}
+
+// Lock stores the Record of the lease.
+type Lock interface {
+	// Get returns the record, nil when there is none yet, and its version.
+	Get(ctx context.Context) (*Record, string, error)
+	// Put replaces the record of version, as returned by Get, and returns
+	// ErrConflict when the record has changed since.
+	Put(ctx context.Context, r *Record, version string) error
+	// String describes where the lock is kept.
+	String() string
+}
+
+// Current returns the holder of the lease kept in lock, nil when there is
+// none or its lease has expired.
+func Current(ctx context.Context, lock Lock) (*Record, error) {
+	r, _, err := lock.Get(ctx)
+	if err != nil || r.Expired(time.Now()) {
+		return nil, err
+	}
+	return r, nil
+}
+
+var invalidIdentity = regexp.MustCompile(`[^a-z0-9-]+`)
+
+// Identity turns name, such as a pod or host name, into an identity every
+// Lock can store, and that can be part of a Kubernetes object name:
+// lowercase letters, digits and inner dashes, at most 63 characters.
+func Identity(name string) string {
+	id := invalidIdentity.ReplaceAllString(strings.ToLower(name), "-")
+	if len(id) > 63 {
+		id = id[:63]
+	}
+	return strings.Trim(id, "-")
+}
+
+// Elector competes for the lease of Lock as Identity.
+type Elector struct {
+	Lock     Lock
+	Identity string
+	// Lease is how long the lease lasts without being renewed, and so how
+	// long the other replicas wait before taking over from a leader that
+	// stopped without releasing it.
+	Lease time.Duration
+	// Renew is how often the leader renews the lease and the other replicas
+	// try to take it.
+	Renew time.Duration
+	// Grace is how long lead may take to return once its context is
+	// cancelled. A leader failing to renew steps down that long, and a
+	// renewal, before its lease could run out, so the next leader can't
+	// overlap with it.
+	Grace time.Duration
+}
+
+// Run calls lead once it holds the lease, and cancels its context when the
+// lease can't be renewed before it runs out; Run then competes for the lease
+// again. When ctx is done the lease is released once lead has returned. An
+// error from lead is returned after releasing the lease.
+func (e *Elector) Run(ctx context.Context, lead func(ctx context.Context) error) error {
+	isLeader.Set(0)
+	for {
+		r := e.acquire(ctx)
+		if r == nil {
+			return nil
+		}
+		fmt.Printf("====> %s is the leader on %s\n", e.Identity, e.Lock)
+		isLeader.Set(1)
+		lctx, cancel := context.WithCancel(ctx)
+		done := make(chan error, 1)
+		go func() { done <- lead(lctx) }()
+		finished, err := e.hold(ctx, r.Renewed, done)
+		cancel()
+		if !finished {
+			err = <-done
+		}
+		isLeader.Set(0)
+		if ctx.Err() != nil || err != nil || finished {
+			e.release()
+			return err
+		}
+	}
+}
+
+// acquire tries to take the lease every Renew until it does, returning the
+// record it wrote, or nil when ctx is done first. Each try is bounded by
+// Renew, so the lease taken still has most of its time left.
+func (e *Elector) acquire(ctx context.Context) *Record {
+	logged := ""
+	for {
+		tctx, cancel := context.WithTimeout(ctx, e.Renew)
+		ok, r, err := e.try(tctx)
+		cancel()
+		switch {
+		case ok:
+			return r
+		case err != nil && ctx.Err() == nil:
+			fmt.Printf("====> Failed to take the leader lease on %s: %s\n", e.Lock, err)
+		case r != nil && r.Holder != logged:
+			fmt.Printf("====> Waiting for the leader lease on %s, held by %s\n", e.Lock, r.Holder)
+			logged = r.Holder
+		}
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-time.After(e.Renew):
+		}
+	}
+}
+
+// hold renews the lease, last renewed at renewed as the other replicas see
+// it, every Renew until lead returns, ctx is done or the lease is lost. It
+// returns whether lead returned, and its error. A renewal that hasn't
+// returned by Grace before the lease runs out is abandoned, leaving lead
+// Grace to stop.
+func (e *Elector) hold(ctx context.Context, renewed time.Time, done <-chan error) (bool, error) {
+	ticker := time.NewTicker(e.Renew)
+	defer ticker.Stop()
+	for {
+		select {
+		case err := <-done:
+			return true, err
+		case <-ctx.Done():
+			return false, nil
+		case <-ticker.C:
+		}
+		tctx, cancel := context.WithDeadline(ctx, renewed.Add(e.Lease-e.Grace))
+		ok, r, err := e.try(tctx)
+		cancel()
+		switch {
+		case ok:
+			renewed = r.Renewed
+		case err == nil:
+			fmt.Printf("====> Lost the leader lease on %s to %s, stopping\n", e.Lock, r.Holder)
+			return false, nil
+		case ctx.Err() != nil:
+			return false, nil
+		case time.Since(renewed) >= e.Lease-e.Renew-e.Grace:
+			fmt.Printf("====> Failed to renew the leader lease on %s in time, stopping: %s\n", e.Lock, err)
+			return false, nil
+		default:
+			fmt.Printf("====> Failed to renew the leader lease on %s: %s\n", e.Lock, err)
+		}
+	}
+}
+
+// try takes or renews the lease. It returns true with the record it wrote,
+// or false with the current record when another replica holds it.
+func (e *Elector) try(ctx context.Context) (bool, *Record, error) {
+	r, version, err := e.Lock.Get(ctx)
+	if err != nil {
+		return false, nil, err
+	}
+	// every Lock keeps whole seconds at least, the renewal time written must
+	// be the one the other replicas read
+	now := time.Now().Truncate(time.Second)
+	if r != nil && r.Holder != e.Identity && !r.Expired(now) {
+		return false, r, nil
+	}
+	next := &Record{Holder: e.Identity, Acquired: now, Renewed: now, Lease: e.Lease}
+	if r != nil && r.Holder == e.Identity {
+		next.Acquired = r.Acquired
+	}
+	err = e.Lock.Put(ctx, next, version)
+	if errors.Is(err, ErrConflict) {
+		// another replica got there first
+		r, _, err = e.Lock.Get(ctx)
+		if err == nil && r == nil {
+			r = &Record{}
+		}
+		return false, r, err
+	}
+	if err != nil {
+		return false, nil, err
+	}
+	return true, next, nil
+}
+
+// release gives the lease up so another replica can take over straight
+// away rather than once it expires.
+func (e *Elector) release() {
+	ctx, cancel := context.WithTimeout(context.Background(), e.Renew)
+	defer cancel()
+	r, version, err := e.Lock.Get(ctx)
+	if err == nil && (r == nil || r.Holder != e.Identity) {
+		return
+	}
+	if err == nil {
+		err = e.Lock.Put(ctx, &Record{Renewed: time.Now(), Lease: e.Lease}, version)
+	}
+	if err != nil {
+		fmt.Printf("====> Failed to release the leader lease on %s, it expires in %s: %s\n", e.Lock, e.Lease, err)
+		return
+	}
+	fmt.Printf("====> Released the leader lease on %s\n", e.Lock)
+}
@@ -0,0 +1,308 @@
+package leader
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeLock keeps the record in memory, versioned by a counter.
+type fakeLock struct {
+	mu      sync.Mutex
+	record  *Record
+	version int
+	// err fails every call while set
+	err error
+}
+
+func (l *fakeLock) Get(ctx context.Context) (*Record, string, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.err != nil {
+		return nil, "", l.err
+	}
+	if l.record == nil {
+		return nil, "", nil
+	}
+	r := *l.record
+	return &r, strconv.Itoa(l.version), nil
+}
+
+func (l *fakeLock) Put(ctx context.Context, r *Record, version string) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.err != nil {
+		return l.err
+	}
+	current := ""
+	if l.record != nil {
+		current = strconv.Itoa(l.version)
+	}
+	if version != current {
+		return ErrConflict
+	}
+	stored := *r
+	l.record = &stored
+	l.version++
+	return nil
+}
+
+func (l *fakeLock) String() string { return "fake" }
+
+func (l *fakeLock) set(r *Record) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.record = r
+	l.version++
+}
+
+func (l *fakeLock) get() Record {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.record == nil {
+		return Record{}
+	}
+	return *l.record
+}
+
+func (l *fakeLock) fail(err error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.err = err
+}
+
+func TestRecordExpired(t *testing.T) {
+	now := time.Now()
+	tests := []struct {
+		name   string
+		record *Record
+		want   bool
+	}{
+		{"no record", nil, true},
+		{"released", &Record{Renewed: now, Lease: time.Minute}, true},
+		{"held", &Record{Holder: "a", Renewed: now.Add(-30 * time.Second), Lease: time.Minute}, false},
+		{"run out", &Record{Holder: "a", Renewed: now.Add(-2 * time.Minute), Lease: time.Minute}, true},
+	}
+	for _, tt := range tests {
+		if got := tt.record.Expired(now); got != tt.want {
+			t.Errorf("%s: Expired() = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestTry(t *testing.T) {
+	now := time.Now().Truncate(time.Second)
+	acquired := now.Add(-time.Hour)
+	tests := []struct {
+		name       string
+		record     *Record
+		wantOK     bool
+		wantHolder string
+		// keepsAcquired is set when a renewal keeps the acquisition time
+		keepsAcquired bool
+	}{
+		{"free", nil, true, "a", false},
+		{"released", &Record{Renewed: now, Lease: time.Minute}, true, "a", false},
+		{"held by another", &Record{Holder: "b", Acquired: acquired, Renewed: now, Lease: time.Minute}, false, "b", false},
+		{"expired", &Record{Holder: "b", Acquired: acquired, Renewed: now.Add(-2 * time.Minute), Lease: time.Minute}, true, "a", false},
+		{"renewed", &Record{Holder: "a", Acquired: acquired, Renewed: now.Add(-10 * time.Second), Lease: time.Minute}, true, "a", true},
+	}
+	for _, tt := range tests {
+		lock := &fakeLock{record: tt.record}
+		e := &Elector{Lock: lock, Identity: "a", Lease: time.Minute}
+		ok, r, err := e.try(context.Background())
+		if err != nil {
+			t.Errorf("%s: try() error = %v", tt.name, err)
+			continue
+		}
+		if ok != tt.wantOK || r.Holder != tt.wantHolder {
+			t.Errorf("%s: try() = %v, %q, want %v, %q", tt.name, ok, r.Holder, tt.wantOK, tt.wantHolder)
+			continue
+		}
+		if !ok {
+			continue
+		}
+		if got := lock.get(); got != *r {
+			t.Errorf("%s: lock holds %+v, try returned %+v", tt.name, got, r)
+		}
+		// the renewal is truncated to the second so it is what others read
+		if r.Renewed.Before(now) || r.Renewed != r.Renewed.Truncate(time.Second) {
+			t.Errorf("%s: renewed at %s, want a whole second from %s", tt.name, r.Renewed, now)
+		}
+		want := r.Renewed
+		if tt.keepsAcquired {
+			want = acquired
+		}
+		if !r.Acquired.Equal(want) {
+			t.Errorf("%s: acquired at %s, want %s", tt.name, r.Acquired, want)
+		}
+	}
+}
+
+func TestTryConflict(t *testing.T) {
+	lock := &conflictLock{fakeLock: &fakeLock{}}
+	e := &Elector{Lock: lock, Identity: "a", Lease: time.Minute}
+	ok, r, err := e.try(context.Background())
+	if err != nil || ok || r.Holder != "b" {
+		t.Errorf("try() = %v, %+v, %v, want the lease held by b", ok, r, err)
+	}
+}
+
+// conflictLock lets another replica take the lease between every Get and Put.
+type conflictLock struct {
+	*fakeLock
+}
+
+func (l *conflictLock) Put(ctx context.Context, r *Record, version string) error {
+	l.set(&Record{Holder: "b", Renewed: time.Now(), Lease: time.Minute})
+	return l.fakeLock.Put(ctx, r, version)
+}
+
+func testElector(lock Lock, identity string) *Elector {
+	return &Elector{Lock: lock, Identity: identity, Lease: 2 * time.Second, Renew: 100 * time.Millisecond, Grace: 500 * time.Millisecond}
+}
+
+// leading runs e and reports on the returned channels when lead starts and
+// when its context is cancelled.
+func leading(ctx context.Context, e *Elector) (started, stopped <-chan time.Time, result <-chan error) {
+	start, stop, res := make(chan time.Time, 10), make(chan time.Time, 10), make(chan error, 1)
+	go func() {
+		res <- e.Run(ctx, func(lctx context.Context) error {
+			start <- time.Now()
+			<-lctx.Done()
+			stop <- time.Now()
+			return nil
+		})
+	}()
+	return start, stop, res
+}
+
+func wait(t *testing.T, c <-chan time.Time, within time.Duration, what string) time.Time {
+	t.Helper()
+	select {
+	case at := <-c:
+		return at
+	case <-time.After(within):
+		t.Fatalf("%s did not happen within %s", what, within)
+		return time.Time{}
+	}
+}
+
+func TestElectorHandover(t *testing.T) {
+	lock := &fakeLock{}
+	actx, acancel := context.WithCancel(context.Background())
+	defer acancel()
+	aStarted, aStopped, aResult := leading(actx, testElector(lock, "a"))
+	wait(t, aStarted, time.Second, "a leading")
+
+	bctx, bcancel := context.WithCancel(context.Background())
+	defer bcancel()
+	bStarted, _, bResult := leading(bctx, testElector(lock, "b"))
+	select {
+	case <-bStarted:
+		t.Fatal("b leads while a holds the lease")
+	case <-time.After(500 * time.Millisecond):
+	}
+
+	// a stopping releases the lease, b takes over without waiting for it
+	// to expire
+	acancel()
+	stopped := wait(t, aStopped, time.Second, "a stopping")
+	if err := <-aResult; err != nil {
+		t.Errorf("a Run() error = %v", err)
+	}
+	if r := lock.get(); r.Holder == "a" {
+		t.Errorf("a did not release the lease: %+v", r)
+	}
+	started := wait(t, bStarted, time.Second, "b taking over")
+	if started.Before(stopped) {
+		t.Errorf("b started leading at %s, before a stopped at %s", started, stopped)
+	}
+	bcancel()
+	if err := <-bResult; err != nil {
+		t.Errorf("b Run() error = %v", err)
+	}
+}
+
+func TestElectorLeaseTaken(t *testing.T) {
+	lock := &fakeLock{}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	started, stopped, result := leading(ctx, testElector(lock, "a"))
+	wait(t, started, time.Second, "a leading")
+
+	// another replica overwrites the record, a steps down on its next renewal
+	lock.set(&Record{Holder: "b", Renewed: time.Now(), Lease: time.Minute})
+	wait(t, stopped, time.Second, "a stepping down")
+	select {
+	case <-started:
+		t.Fatal("a leads again while b holds the lease")
+	case <-time.After(300 * time.Millisecond):
+	}
+	cancel()
+	if err := <-result; err != nil {
+		t.Errorf("Run() error = %v", err)
+	}
+	if r := lock.get(); r.Holder != "b" {
+		t.Errorf("a released b's lease: %+v", r)
+	}
+}
+
+func TestElectorLeaseLost(t *testing.T) {
+	lock := &fakeLock{}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	e := testElector(lock, "a")
+	started, stopped, result := leading(ctx, e)
+	wait(t, started, time.Second, "a leading")
+
+	// renewals fail, a must step down Grace before its lease runs out so
+	// the next leader can't overlap with it
+	lock.fail(errors.New("unavailable"))
+	last := lock.get()
+	at := wait(t, stopped, e.Lease+time.Second, "a stepping down")
+	if deadline := last.Renewed.Add(e.Lease - e.Grace); at.After(deadline) {
+		t.Errorf("a stepped down at %s, after %s", at, deadline)
+	}
+
+	lock.fail(nil)
+	wait(t, started, e.Lease+time.Second, "a leading again")
+	cancel()
+	if err := <-result; err != nil {
+		t.Errorf("Run() error = %v", err)
+	}
+}
+
+func TestElectorLeadError(t *testing.T) {
+	lock := &fakeLock{}
+	e := testElector(lock, "a")
+	want := errors.New("failed")
+	err := e.Run(context.Background(), func(ctx context.Context) error { return want })
+	if err != want {
+		t.Errorf("Run() error = %v, want %v", err, want)
+	}
+	if r := lock.get(); r.Holder != "" {
+		t.Errorf("the lease was not released: %+v", r)
+	}
+}
+
+func TestIdentity(t *testing.T) {
+	tests := []struct {
+		name string
+		want string
+	}{
+		{"gcp-lb-tags-7d9f8-x2x", "gcp-lb-tags-7d9f8-x2x"},
+		{"Master.Example.COM", "master-example-com"},
+		{"-pod_1-", "pod-1"},
+		{strings.Repeat("a", 70), strings.Repeat("a", 63)},
+	}
+	for _, tt := range tests {
+		if got := Identity(tt.name); got != tt.want {
+			t.Errorf("Identity(%q) = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
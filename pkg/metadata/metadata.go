@@ -0,0 +1,125 @@
+// Package metadata reads what the GCE metadata server knows about the
+// instance gcp-lb-tags runs on. GCE_METADATA_HOST points it at another
+// server, such as a local stand-in for testing.
+package metadata
+
+import (
+	"bytes"
+	"fmt"
+	"path"
+	"strings"
+	"text/template"
+
+	gcemd "cloud.google.com/go/compute/metadata"
+)
+
+// Instance is the instance gcp-lb-tags runs on.
+type Instance struct {
+	Project string
+	// Network is the network of the first network interface.
+	Network string
+	Zone    string
+	Region  string
+	Name    string
+	Tags    []string
+	// Attributes are the custom metadata of the instance.
+	Attributes map[string]string
+}
+
+// Load reads the instance from the metadata server.
+func Load() (*Instance, error) {
+	i := &Instance{Attributes: map[string]string{}}
+	var err error
+	if i.Project, err = gcemd.ProjectID(); err != nil {
+		return nil, fmt.Errorf("reading the project from the metadata server: %s", err)
+	}
+	network, err := gcemd.Get("instance/network-interfaces/0/network")
+	if err != nil {
+		return nil, fmt.Errorf("reading the network from the metadata server: %s", err)
+	}
+	i.Network = path.Base(network)
+	if i.Zone, err = gcemd.Zone(); err != nil {
+		return nil, fmt.Errorf("reading the zone from the metadata server: %s", err)
+	}
+	i.Region = Region(i.Zone)
+	if i.Name, err = gcemd.Get("instance/name"); err != nil {
+		return nil, fmt.Errorf("reading the instance name from the metadata server: %s", err)
+	}
+	if i.Tags, err = gcemd.InstanceTags(); err != nil {
+		return nil, fmt.Errorf("reading the instance tags from the metadata server: %s", err)
+	}
+	keys, err := gcemd.InstanceAttributes()
+	if err != nil {
+		return nil, fmt.Errorf("reading the instance attributes from the metadata server: %s", err)
+	}
+	for _, k := range keys {
+		if i.Attributes[k], err = gcemd.InstanceAttributeValue(k); err != nil {
+			return nil, fmt.Errorf("reading the instance attribute %s from the metadata server: %s", k, err)
+		}
+	}
+	return i, nil
+}
+
+// Region returns the region of a zone such as us-central1-a.
+func Region(zone string) string {
+	if i := strings.LastIndex(zone, "-"); i > 0 {
+		return zone[:i]
+	}
+	return zone
+}
+
+// Render expands text as a template of the instance. Besides the fields of
+// Instance, such as {{.Project}}, the template can call tagSuffix, returning
+// the rest of the first tag starting with a prefix, and attribute, returning
+// the value of a custom metadata key:
+//
+//	pks-{{tagSuffix `p-bosh-service-instance-`}}
+//
+// Text without a template is returned as is.
+func (i *Instance) Render(text string) (string, error) {
+	if !strings.Contains(text, "{{") {
+		return text, nil
+	}
+	t, err := template.New(text).Funcs(template.FuncMap{
+		"tagSuffix": i.tagSuffix,
+		"attribute": i.attribute,
+	}).Parse(text)
+	if err != nil {
+		return "", err
+	}
+	var b bytes.Buffer
+	if err := t.Execute(&b, i); err != nil {
+		return "", err
+	}
+	return b.String(), nil
+}
+
+// RenderAll renders every entry of texts.
+func (i *Instance) RenderAll(texts []string) ([]string, error) {
+	out := []string{}
+	for _, text := range texts {
+		r, err := i.Render(text)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, r)
+	}
+	return out, nil
+}
+
+func (i *Instance) tagSuffix(prefix string) (string, error) {
+	for _, t := range i.Tags {
+		if strings.HasPrefix(t, prefix) {
+			return strings.TrimPrefix(t, prefix), nil
+		}
+	}
+	return "", fmt.Errorf("instance %s has no tag starting with %q", i.Name, prefix)
+}
+
+func (i *Instance) attribute(key string) (string, error) {
+	v, ok := i.Attributes[key]
+	if !ok {
+		return "", fmt.Errorf("instance %s has no metadata key %q", i.Name, key)
+	}
+	return v, nil
+}
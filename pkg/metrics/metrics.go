@@ -0,0 +1,124 @@
+package metrics
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// metric is a named family of values, one per combination of label values.
+type metric struct {
+	name   string
+	help   string
+	kind   string
+	labels []string
+
+	mu     sync.Mutex
+	values map[string]float64
+}
+
+// Counter is a value that only goes up.
+type Counter struct{ *metric }
+
+// Gauge is a value that can go up and down.
+type Gauge struct{ *metric }
+
+var (
+	registryMu sync.Mutex
+	registry   []*metric
+)
+
+func register(name, help, kind string, labels []string) *metric {
+	m := &metric{name: name, help: help, kind: kind, labels: labels, values: map[string]float64{}}
+	registryMu.Lock()
+	registry = append(registry, m)
+	registryMu.Unlock()
+	return m
+}
+
+// NewCounter registers a counter with the given label names.
+func NewCounter(name, help string, labels ...string) *Counter {
+	return &Counter{register(name, help, "counter", labels)}
+}
+
+// NewGauge registers a gauge with the given label names.
+func NewGauge(name, help string, labels ...string) *Gauge {
+	return &Gauge{register(name, help, "gauge", labels)}
+}
+
+// key renders label values in the exposition format, e.g. {lb="a",reason="b"}.
+func (m *metric) key(values []string) string {
+	if len(m.labels) == 0 {
+		return ""
+	}
+	pairs := make([]string, len(m.labels))
+	for i, l := range m.labels {
+		v := ""
+		if i < len(values) {
+			v = values[i]
+		}
+		v = strings.Replace(v, `\`, `\\`, -1)
+		v = strings.Replace(v, `"`, `\"`, -1)
+		pairs[i] = fmt.Sprintf(`%s="%s"`, l, v)
+	}
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+// Add increases the counter for the given label values.
+func (c *Counter) Add(n float64, values ...string) {
+	c.mu.Lock()
+	c.values[c.key(values)] += n
+	c.mu.Unlock()
+}
+
+// Inc increases the counter for the given label values by one.
+func (c *Counter) Inc(values ...string) {
+	c.Add(1, values...)
+}
+
+// Set sets the gauge for the given label values.
+func (g *Gauge) Set(n float64, values ...string) {
+	g.mu.Lock()
+	g.values[g.key(values)] = n
+	g.mu.Unlock()
+}
+
+func (m *metric) write(w io.Writer) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", m.name, m.help, m.name, m.kind)
+	keys := []string{}
+	for k := range m.values {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		fmt.Fprintf(w, "%s%s %g\n", m.name, k, m.values[k])
+	}
+}
+
+// Handler serves every registered metric in the Prometheus text format.
+func Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+		registryMu.Lock()
+		defer registryMu.Unlock()
+		for _, m := range registry {
+			m.write(w)
+		}
+	})
+}
+
+// Serve exposes the metrics on addr at /metrics in the background.
+func Serve(addr string) {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", Handler())
+	go func() {
+		if err := http.ListenAndServe(addr, mux); err != nil {
+			fmt.Printf("metrics server on %s stopped: %s\n", addr, err)
+		}
+	}()
+}
@@ -0,0 +1,224 @@
+package probe
+
+import (
+	"crypto/tls"
+	"fmt"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// Supported probe modes.
+const (
+	ModeTCP   = "tcp"
+	ModeTLS   = "tls"
+	ModeHTTP  = "http"
+	ModeHTTPS = "https"
+)
+
+// Config describes how and how often members are probed.
+type Config struct {
+	// Mode is one of tcp, tls, http or https, empty disables probing.
+	Mode string `yaml:"mode,omitempty"`
+	// Port is probed on each member's internal IP, defaults to the LB port.
+	Port string `yaml:"port,omitempty"`
+	// Path is requested by the http and https modes.
+	Path     string        `yaml:"path,omitempty"`
+	Timeout  time.Duration `yaml:"timeout,omitempty"`
+	Interval time.Duration `yaml:"interval,omitempty"`
+	// HealthyThreshold consecutive passes admit a member.
+	HealthyThreshold int `yaml:"healthyThreshold,omitempty"`
+	// UnhealthyThreshold consecutive failures evict a member.
+	UnhealthyThreshold int `yaml:"unhealthyThreshold,omitempty"`
+}
+
+type target struct {
+	healthy   bool
+	successes int
+	failures  int
+	lastErr   error
+}
+
+// Prober probes a set of addresses in the background and remembers whether
+// each one currently passes.
+type Prober struct {
+	cfg    Config
+	client *http.Client
+
+	mu      sync.Mutex
+	targets map[string]*target
+
+	stop chan struct{}
+	once sync.Once
+}
+
+// New returns a prober for cfg, call Start to probe in the background.
+func New(cfg Config) *Prober {
+	if cfg.Timeout <= 0 {
+		cfg.Timeout = 2 * time.Second
+	}
+	if cfg.Interval <= 0 {
+		cfg.Interval = 10 * time.Second
+	}
+	if cfg.HealthyThreshold <= 0 {
+		cfg.HealthyThreshold = 1
+	}
+	if cfg.UnhealthyThreshold <= 0 {
+		cfg.UnhealthyThreshold = 1
+	}
+	if cfg.Path == "" {
+		cfg.Path = "/"
+	}
+	return &Prober{
+		cfg: cfg,
+		client: &http.Client{
+			Timeout: cfg.Timeout,
+			Transport: &http.Transport{
+				// members are dialled by internal IP so certificates can't match
+				TLSClientConfig: &tls.Config{InsecureSkipVerify: true},
+			},
+			CheckRedirect: func(req *http.Request, via []*http.Request) error {
+				return http.ErrUseLastResponse
+			},
+		},
+		targets: map[string]*target{},
+		stop:    make(chan struct{}),
+	}
+}
+
+// Start probes every target each Interval until Stop is called.
+func (p *Prober) Start() {
+	go func() {
+		t := time.NewTicker(p.cfg.Interval)
+		defer t.Stop()
+		for {
+			select {
+			case <-p.stop:
+				return
+			case <-t.C:
+				p.probeAll(p.addresses())
+			}
+		}
+	}()
+}
+
+// Stop ends background probing.
+func (p *Prober) Stop() {
+	p.once.Do(func() { close(p.stop) })
+}
+
+// SetTargets replaces the set of probed addresses. Addresses that are new
+// are probed straight away so they can be admitted without waiting for the
+// next interval. New addresses also in healthy start out passing, such as
+// the current members after a restart, and only stop once they fail
+// UnhealthyThreshold probes.
+func (p *Prober) SetTargets(addrs, healthy []string) {
+	fresh := []string{}
+	want := map[string]bool{}
+	passing := map[string]bool{}
+	for _, a := range healthy {
+		passing[a] = true
+	}
+	p.mu.Lock()
+	for _, a := range addrs {
+		want[a] = true
+		if _, ok := p.targets[a]; !ok {
+			p.targets[a] = &target{healthy: passing[a]}
+			fresh = append(fresh, a)
+		}
+	}
+	for a := range p.targets {
+		if !want[a] {
+			delete(p.targets, a)
+		}
+	}
+	p.mu.Unlock()
+	p.probeAll(fresh)
+}
+
+// Healthy reports whether addr currently passes, with the last probe error.
+func (p *Prober) Healthy(addr string) (bool, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	t, ok := p.targets[addr]
+	if !ok {
+		return false, fmt.Errorf("%s is not probed", addr)
+	}
+	return t.healthy, t.lastErr
+}
+
+func (p *Prober) addresses() []string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	addrs := []string{}
+	for a := range p.targets {
+		addrs = append(addrs, a)
+	}
+	return addrs
+}
+
+func (p *Prober) probeAll(addrs []string) {
+	var wg sync.WaitGroup
+	for _, a := range addrs {
+		wg.Add(1)
+		go func(addr string) {
+			defer wg.Done()
+			p.record(addr, p.Probe(addr))
+		}(a)
+	}
+	wg.Wait()
+}
+
+func (p *Prober) record(addr string, err error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	t, ok := p.targets[addr]
+	if !ok {
+		return
+	}
+	t.lastErr = err
+	if err == nil {
+		t.successes++
+		t.failures = 0
+		if t.successes >= p.cfg.HealthyThreshold {
+			t.healthy = true
+		}
+	} else {
+		t.failures++
+		t.successes = 0
+		if t.failures >= p.cfg.UnhealthyThreshold {
+			t.healthy = false
+		}
+	}
+}
+
+// Probe checks addr once.
+func (p *Prober) Probe(addr string) error {
+	switch p.cfg.Mode {
+	case ModeTCP:
+		conn, err := net.DialTimeout("tcp", addr, p.cfg.Timeout)
+		if err != nil {
+			return err
+		}
+		return conn.Close()
+	case ModeTLS:
+		dialer := &net.Dialer{Timeout: p.cfg.Timeout}
+		conn, err := tls.DialWithDialer(dialer, "tcp", addr, &tls.Config{InsecureSkipVerify: true})
+		if err != nil {
+			return err
+		}
+		return conn.Close()
+	case ModeHTTP, ModeHTTPS:
+		res, err := p.client.Get(p.cfg.Mode + "://" + addr + p.cfg.Path)
+		if err != nil {
+			return err
+		}
+		res.Body.Close()
+		if res.StatusCode < 200 || res.StatusCode >= 400 {
+			return fmt.Errorf("GET %s returned %s", p.cfg.Path, res.Status)
+		}
+		return nil
+	}
+	return fmt.Errorf("unknown probe mode %q", p.cfg.Mode)
+}
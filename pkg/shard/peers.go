@@ -0,0 +1,138 @@
+package shard
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/paulczar/gcp-lb-tags/pkg/kube"
+)
+
+// Peer is a live replica and the load balancers it claims.
+type Peer struct {
+	Identity string
+	Claims   []string
+}
+
+// Peers is how the replicas find each other.
+type Peers interface {
+	// Publish renews the membership of this replica at renewed for lease,
+	// claiming the load balancers in claims.
+	Publish(ctx context.Context, claims []string, renewed time.Time, lease time.Duration) error
+	// List returns the live replicas, this one included once published.
+	List(ctx context.Context) ([]*Peer, error)
+	// Leave ends the membership of this replica.
+	Leave(ctx context.Context) error
+	// String describes where the replicas are listed.
+	String() string
+}
+
+// StaticPeers is a fixed list of replicas, each expected to run. They don't
+// publish claims, the list never changes while they run, so the load
+// balancers of a replica that is down wait for it to come back.
+type StaticPeers struct {
+	Members []string
+}
+
+// Publish does nothing, the members are fixed.
+func (p *StaticPeers) Publish(ctx context.Context, claims []string, renewed time.Time, lease time.Duration) error {
+	return nil
+}
+
+// List returns every member.
+func (p *StaticPeers) List(ctx context.Context) ([]*Peer, error) {
+	peers := []*Peer{}
+	for _, m := range p.Members {
+		peers = append(peers, &Peer{Identity: m})
+	}
+	return peers, nil
+}
+
+// Leave does nothing, the members are fixed.
+func (p *StaticPeers) Leave(ctx context.Context) error {
+	return nil
+}
+
+func (p *StaticPeers) String() string {
+	return "static members " + strings.Join(p.Members, ", ")
+}
+
+// Lease label and annotation of KubernetesPeers.
+const (
+	groupLabel       = "gcp-lb-tags/shard-group"
+	claimsAnnotation = "gcp-lb-tags/claims"
+)
+
+// KubernetesPeers lists the replicas through a coordination.k8s.io/v1
+// Lease per replica, named after its group and identity and labelled with
+// its group. A replica is live until its Lease expires, and the Lease
+// carries its claims in an annotation.
+type KubernetesPeers struct {
+	Group    string
+	Identity string
+	client   *kube.Client
+}
+
+// NewKubernetesPeers returns the peers of group in namespace, or in the
+// pod's own namespace when namespace is empty.
+func NewKubernetesPeers(namespace, group, identity string) (*KubernetesPeers, error) {
+	client, err := kube.InCluster(namespace)
+	if err != nil {
+		return nil, err
+	}
+	return &KubernetesPeers{Group: group, Identity: identity, client: client}, nil
+}
+
+func (p *KubernetesPeers) name() string {
+	return p.Group + "-" + p.Identity
+}
+
+// Publish creates or renews the Lease of this replica.
+func (p *KubernetesPeers) Publish(ctx context.Context, claims []string, renewed time.Time, lease time.Duration) error {
+	current, err := p.client.GetLease(ctx, p.name())
+	if err != nil {
+		return err
+	}
+	l := kube.NewLease(p.name(), p.Identity, renewed, lease)
+	l.Metadata.Labels = map[string]string{groupLabel: p.Group}
+	l.Metadata.Annotations = map[string]string{claimsAnnotation: strings.Join(claims, ",")}
+	if current != nil {
+		l.Metadata.ResourceVersion = current.Metadata.ResourceVersion
+	}
+	return p.client.PutLease(ctx, l)
+}
+
+// List returns the replicas of the group whose Lease hasn't expired.
+func (p *KubernetesPeers) List(ctx context.Context) ([]*Peer, error) {
+	leases, err := p.client.ListLeases(ctx, groupLabel+"="+p.Group)
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	peers := []*Peer{}
+	for _, l := range leases {
+		holder, renewed, lease := l.Holder()
+		if holder == "" || now.After(renewed.Add(lease)) {
+			continue
+		}
+		peer := &Peer{Identity: holder}
+		if c := l.Metadata.Annotations[claimsAnnotation]; c != "" {
+			peer.Claims = strings.Split(c, ",")
+		}
+		peers = append(peers, peer)
+	}
+	sort.Slice(peers, func(i, j int) bool { return peers[i].Identity < peers[j].Identity })
+	return peers, nil
+}
+
+// Leave deletes the Lease of this replica, so the others take over its
+// load balancers straight away.
+func (p *KubernetesPeers) Leave(ctx context.Context) error {
+	return p.client.DeleteLease(ctx, p.name())
+}
+
+func (p *KubernetesPeers) String() string {
+	return fmt.Sprintf("Leases %s/%s-*", p.client.Namespace, p.Group)
+}
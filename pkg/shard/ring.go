@@ -0,0 +1,62 @@
+package shard
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"sort"
+	"strconv"
+)
+
+// pointsPerMember is how many points each member has on the ring, enough
+// for the load balancers to spread evenly over a handful of replicas.
+const pointsPerMember = 128
+
+// Ring assigns keys to members by consistent hashing. Every member has
+// pointsPerMember points on a ring of 32-bit hashes and a key belongs to the
+// member of the first point at or after the key's hash, so a member joining
+// or leaving only moves the keys of the points it gains or loses.
+type Ring struct {
+	points []uint32
+	owners map[uint32]string
+}
+
+// NewRing returns the ring of members.
+func NewRing(members []string) *Ring {
+	sorted := append([]string{}, members...)
+	// on the rare point two members share, the first in order wins on
+	// every replica alike
+	sort.Strings(sorted)
+	r := &Ring{owners: map[uint32]string{}}
+	for _, m := range sorted {
+		for i := 0; i < pointsPerMember; i++ {
+			p := hash(m + "#" + strconv.Itoa(i))
+			if _, ok := r.owners[p]; ok {
+				continue
+			}
+			r.owners[p] = m
+			r.points = append(r.points, p)
+		}
+	}
+	sort.Slice(r.points, func(i, j int) bool { return r.points[i] < r.points[j] })
+	return r
+}
+
+// Owner returns the member key belongs to, empty when the ring has none.
+func (r *Ring) Owner(key string) string {
+	if len(r.points) == 0 {
+		return ""
+	}
+	h := hash(key)
+	i := sort.Search(len(r.points), func(i int) bool { return r.points[i] >= h })
+	if i == len(r.points) {
+		i = 0
+	}
+	return r.owners[r.points[i]]
+}
+
+// hash spreads names differing in a character or two, such as lb-1 and
+// lb-2, all over the ring, which a plain FNV hash doesn't.
+func hash(s string) uint32 {
+	sum := sha256.Sum256([]byte(s))
+	return binary.BigEndian.Uint32(sum[:4])
+}
@@ -0,0 +1,92 @@
+package shard
+
+import (
+	"fmt"
+	"testing"
+)
+
+func keys(n int) []string {
+	k := []string{}
+	for i := 0; i < n; i++ {
+		k = append(k, fmt.Sprintf("lb-%d", i))
+	}
+	return k
+}
+
+func TestRingEmpty(t *testing.T) {
+	if got := NewRing(nil).Owner("lb-0"); got != "" {
+		t.Errorf("Owner() on an empty ring = %q, want none", got)
+	}
+}
+
+func TestRingOrderIndependent(t *testing.T) {
+	a := NewRing([]string{"r0", "r1", "r2"})
+	b := NewRing([]string{"r2", "r0", "r1"})
+	for _, k := range keys(500) {
+		if a.Owner(k) != b.Owner(k) {
+			t.Fatalf("Owner(%q) depends on the order of the members: %q and %q", k, a.Owner(k), b.Owner(k))
+		}
+	}
+}
+
+func TestRingSpread(t *testing.T) {
+	members := []string{"r0", "r1", "r2"}
+	r := NewRing(members)
+	counts := map[string]int{}
+	for _, k := range keys(3000) {
+		counts[r.Owner(k)]++
+	}
+	for _, m := range members {
+		// an even share is 1000
+		if counts[m] < 700 || counts[m] > 1300 {
+			t.Errorf("%s owns %d of 3000 keys, want about 1000", m, counts[m])
+		}
+	}
+}
+
+func TestRingStability(t *testing.T) {
+	tests := []struct {
+		name   string
+		before []string
+		after  []string
+	}{
+		{"member joins", []string{"r0", "r1", "r2"}, []string{"r0", "r1", "r2", "r3"}},
+		{"member leaves", []string{"r0", "r1", "r2", "r3"}, []string{"r0", "r1", "r3"}},
+		{"one of two leaves", []string{"r0", "r1"}, []string{"r1"}},
+	}
+	for _, tt := range tests {
+		before, after := NewRing(tt.before), NewRing(tt.after)
+		moved := 0
+		for _, k := range keys(2000) {
+			was, is := before.Owner(k), after.Owner(k)
+			if was == is {
+				continue
+			}
+			moved++
+			// keys only move to a member that joined, or away from one
+			// that left
+			joined := !contains(tt.before, is)
+			left := !contains(tt.after, was)
+			if !joined && !left {
+				t.Errorf("%s: %s moved from %s to %s, neither of which joined or left", tt.name, k, was, is)
+			}
+		}
+		// about one key in max(len(before), len(after)) moves
+		n := len(tt.before)
+		if len(tt.after) > n {
+			n = len(tt.after)
+		}
+		if limit := 2000 * 3 / (2 * n); len(tt.after) > 1 && moved > limit {
+			t.Errorf("%s: %d of 2000 keys moved, want at most %d", tt.name, moved, limit)
+		}
+	}
+}
+
+func contains(members []string, m string) bool {
+	for _, x := range members {
+		if x == m {
+			return true
+		}
+	}
+	return false
+}
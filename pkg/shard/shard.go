@@ -0,0 +1,288 @@
+// Package shard spreads the load balancers of a config file over several
+// replicas of gcp-lb-tags. The replicas find each other through Peers and
+// each reconciles the load balancers a consistent hash of the live replicas
+// gives it. A replica claims a load balancer before reconciling it and only
+// starts once no other replica claims it, so while ownership moves, after a
+// replica came or went, a load balancer is handed over rather than
+// reconciled twice.
+package shard
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/paulczar/gcp-lb-tags/pkg/metrics"
+)
+
+var (
+	peersGauge = metrics.NewGauge("gcp_lb_tags_shard_peers",
+		"Live replicas sharing the load balancers, as this replica last saw them.")
+	claimsGauge = metrics.NewGauge("gcp_lb_tags_shard_claims",
+		"Load balancers this replica claims.")
+)
+
+// Sharder decides which load balancers this replica reconciles.
+type Sharder struct {
+	Peers    Peers
+	Identity string
+	// Lease is how long the membership and claims of a replica last
+	// without being renewed.
+	Lease time.Duration
+	// Renew is how often they are renewed.
+	Renew time.Duration
+	// Grace is how long the loop of a load balancer may take to stop. A
+	// replica that hasn't renewed by Grace before its lease runs out gives
+	// up every load balancer, and a renewal still going on then is
+	// abandoned.
+	Grace time.Duration
+
+	mu      sync.Mutex
+	ring    *Ring
+	members string
+	lost    bool
+	// renewed is the renewal time last written, as the other replicas see
+	// it, and expiry gives up every load balancer Grace before it runs out
+	renewed time.Time
+	expiry  *time.Timer
+	updates chan struct{}
+
+	// publish serializes publishing, so claims are never published out of
+	// order
+	publish sync.Mutex
+	claims  map[string]bool
+	left    bool
+}
+
+// Start joins the replicas and keeps renewing the membership every Renew
+// until ctx is done.
+func (s *Sharder) Start(ctx context.Context) error {
+	s.claims = map[string]bool{}
+	s.updates = make(chan struct{}, 1)
+	jctx, cancel := context.WithTimeout(ctx, s.Renew)
+	err := s.renew(jctx)
+	cancel()
+	if err != nil {
+		return fmt.Errorf("joining the replicas on %s: %s", s.Peers, err)
+	}
+	go func() {
+		ticker := time.NewTicker(s.Renew)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+			rctx, cancel := s.bounded(ctx)
+			err := s.renew(rctx)
+			cancel()
+			if err != nil && ctx.Err() == nil {
+				fmt.Printf("====> Failed to renew the membership on %s: %s\n", s.Peers, err)
+			}
+		}
+	}()
+	return nil
+}
+
+// bounded returns ctx with the deadline of calls publishing under the
+// current lease, Grace before it runs out. Once the lease is lost a call
+// only has Renew, to join again.
+func (s *Sharder) bounded(ctx context.Context) (context.Context, context.CancelFunc) {
+	s.mu.Lock()
+	lost, deadline := s.lost, s.renewed.Add(s.Lease-s.Grace)
+	s.mu.Unlock()
+	if lost {
+		return context.WithTimeout(ctx, s.Renew)
+	}
+	return context.WithDeadline(ctx, deadline)
+}
+
+// expire gives up every load balancer once the lease is Grace from running
+// out without a renewal, even while a renewal is still going on.
+func (s *Sharder) expire() {
+	s.mu.Lock()
+	late := !s.lost && time.Since(s.renewed) >= s.Lease-s.Grace
+	if late {
+		s.lost = true
+	}
+	s.mu.Unlock()
+	if late {
+		fmt.Printf("====> Failed to renew the membership on %s in time, giving up every load balancer\n", s.Peers)
+		s.notify()
+	}
+}
+
+// now returns the time to publish, as precisely as the Peers keep it.
+func now() time.Time {
+	return time.Now().Truncate(time.Microsecond)
+}
+
+// Updates receives after every renewal, when the load balancers this
+// replica owns may have changed.
+func (s *Sharder) Updates() <-chan struct{} {
+	return s.updates
+}
+
+func (s *Sharder) notify() {
+	select {
+	case s.updates <- struct{}{}:
+	default:
+	}
+}
+
+// renew publishes the claims and rebuilds the ring from the live replicas.
+func (s *Sharder) renew(ctx context.Context) error {
+	s.publish.Lock()
+	defer s.publish.Unlock()
+	if s.left {
+		return nil
+	}
+	renewed := now()
+	if err := s.Peers.Publish(ctx, s.claimed(), renewed, s.Lease); err != nil {
+		return err
+	}
+	peers, err := s.Peers.List(ctx)
+	if err != nil {
+		return err
+	}
+	ids := []string{}
+	for _, p := range peers {
+		ids = append(ids, p.Identity)
+	}
+	sort.Strings(ids)
+	members := strings.Join(ids, ", ")
+	peersGauge.Set(float64(len(ids)))
+
+	s.mu.Lock()
+	s.renewed = renewed
+	if s.expiry == nil {
+		s.expiry = time.AfterFunc(time.Until(renewed.Add(s.Lease-s.Grace)), s.expire)
+	} else {
+		s.expiry.Reset(time.Until(renewed.Add(s.Lease - s.Grace)))
+	}
+	changed := members != s.members || s.lost
+	s.ring, s.members, s.lost = NewRing(ids), members, false
+	s.mu.Unlock()
+	if changed {
+		fmt.Printf("====> Sharing the load balancers with %d replicas: %s\n", len(ids), members)
+	}
+	s.notify()
+	return nil
+}
+
+// claimed returns the sorted claims, with s.publish held.
+func (s *Sharder) claimed() []string {
+	names := []string{}
+	for n := range s.claims {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+	claimsGauge.Set(float64(len(names)))
+	return names
+}
+
+// Owns tells whether the load balancer name belongs to this replica.
+func (s *Sharder) Owns(name string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return !s.lost && s.ring != nil && s.ring.Owner(name) == s.Identity
+}
+
+// Acquire claims the load balancers in names and returns the ones no other
+// replica claims, which this replica may start reconciling. The others are
+// still being handed over and are tried again later.
+func (s *Sharder) Acquire(ctx context.Context, names []string) []string {
+	s.publish.Lock()
+	defer s.publish.Unlock()
+	if s.left || len(names) == 0 {
+		return nil
+	}
+	ctx, cancel := s.bounded(ctx)
+	defer cancel()
+	for _, n := range names {
+		s.claims[n] = true
+	}
+	// claim first and look at the others' claims after, so of two replicas
+	// claiming at once at least one sees the other
+	var peers []*Peer
+	err := s.Peers.Publish(ctx, s.claimed(), now(), s.Lease)
+	if err == nil {
+		peers, err = s.Peers.List(ctx)
+	}
+	if err != nil {
+		fmt.Printf("====> Failed to claim %d load balancers on %s: %s\n", len(names), s.Peers, err)
+		for _, n := range names {
+			delete(s.claims, n)
+		}
+		s.claimed()
+		return nil
+	}
+	taken := map[string]bool{}
+	for _, p := range peers {
+		if p.Identity == s.Identity {
+			continue
+		}
+		for _, c := range p.Claims {
+			taken[c] = true
+		}
+	}
+	acquired, waiting := []string{}, []string{}
+	for _, n := range names {
+		if taken[n] {
+			delete(s.claims, n)
+			waiting = append(waiting, n)
+			continue
+		}
+		acquired = append(acquired, n)
+	}
+	if len(waiting) > 0 {
+		fmt.Printf("====> Waiting for other replicas to hand over %s\n", strings.Join(waiting, ", "))
+		// a stale claim is corrected by the next renewal at the latest
+		s.Peers.Publish(ctx, s.claimed(), now(), s.Lease)
+	}
+	return acquired
+}
+
+// Release gives up the claims on names, once their loops have stopped, so
+// their new owners can start them straight away.
+func (s *Sharder) Release(names []string) {
+	if len(names) == 0 {
+		return
+	}
+	s.publish.Lock()
+	defer s.publish.Unlock()
+	for _, n := range names {
+		delete(s.claims, n)
+	}
+	if s.left {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), s.Renew)
+	defer cancel()
+	if err := s.Peers.Publish(ctx, s.claimed(), now(), s.Lease); err != nil {
+		// the next renewal publishes them
+		fmt.Printf("====> Failed to release %d load balancers on %s: %s\n", len(names), s.Peers, err)
+	}
+}
+
+// Leave ends the membership once every loop has stopped, so the other
+// replicas take over straight away rather than once it expires.
+func (s *Sharder) Leave() {
+	s.publish.Lock()
+	defer s.publish.Unlock()
+	s.left = true
+	s.mu.Lock()
+	if s.expiry != nil {
+		s.expiry.Stop()
+	}
+	s.mu.Unlock()
+	ctx, cancel := context.WithTimeout(context.Background(), s.Renew)
+	defer cancel()
+	if err := s.Peers.Leave(ctx); err != nil {
+		fmt.Printf("====> Failed to leave %s, the membership expires in %s: %s\n", s.Peers, s.Lease, err)
+	}
+}
@@ -0,0 +1,220 @@
+package spec
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"fmt"
+	"path"
+	"strings"
+	"text/template"
+
+	"github.com/paulczar/gcp-lb-tags/pkg/cloud"
+	"github.com/paulczar/gcp-lb-tags/pkg/validate"
+)
+
+// Discovery makes a load balancer a template, stamped out once for every
+// group of selected instances sharing the value of a label. The name, address
+// and firewall tags of the template are rendered per group with the value as
+// {{.group}}, and the group's label is added to the selector.
+type Discovery struct {
+	// GroupLabel is the label key instances are grouped by, such as deployment.
+	GroupLabel string `yaml:"groupLabel"`
+	// GroupPattern is a glob the value of GroupLabel must match, such as
+	// service-instance-*. Every value matches when it is empty.
+	GroupPattern string `yaml:"groupPattern,omitempty"`
+}
+
+// Discoverer returns the values of lb.Discover.GroupLabel among the instances
+// lb's selector matches.
+type Discoverer func(lb *LoadBalancer) ([]string, error)
+
+// templateFuncs are available to the templates of a discovery load balancer,
+// so pks-{{trimPrefix .group "service-instance-"}} names the load balancer of
+// the deployment service-instance-1234 pks-1234.
+var templateFuncs = template.FuncMap{
+	"trimPrefix": func(s, prefix string) string { return strings.TrimPrefix(s, prefix) },
+	"trimSuffix": func(s, suffix string) string { return strings.TrimSuffix(s, suffix) },
+	"replace":    func(s, old, new string) string { return strings.Replace(s, old, new, -1) },
+	"lower":      strings.ToLower,
+}
+
+// sampleGroup renders the templates of a discovery load balancer when it is
+// validated, before any group is known.
+const sampleGroup = "group"
+
+// Expand returns f with every discovery load balancer replaced by one load
+// balancer per group discover finds. A group whose load balancer would be
+// invalid, or named like another one, is left out and reported in the
+// returned error, which is a validate.Errors; the other groups are still
+// returned.
+func (f *File) Expand(discover Discoverer, defaults *cloud.Config) (*File, error) {
+	out := &File{APIVersion: f.APIVersion}
+	errs := validate.Errors{}
+	seen := map[string]bool{}
+	for _, lb := range f.LoadBalancers {
+		if lb.Discover == nil {
+			out.LoadBalancers = append(out.LoadBalancers, lb)
+			seen[lb.Name] = true
+		}
+	}
+	for i, lb := range f.LoadBalancers {
+		if lb.Discover == nil {
+			continue
+		}
+		prefix := fmt.Sprintf("loadBalancers[%d]", i)
+		values, err := discover(lb)
+		if err != nil {
+			return nil, fmt.Errorf("discovering the groups of %s: %s", prefix, err)
+		}
+		for _, v := range values {
+			if !lb.Discover.matches(v) {
+				continue
+			}
+			gprefix := fmt.Sprintf("%s(%s=%s).", prefix, lb.Discover.GroupLabel, v)
+			g, err := lb.render(v)
+			if err != nil {
+				errs.Add(strings.TrimSuffix(gprefix, "."), "%s", err)
+				continue
+			}
+			gerrs := validate.Errors{}
+			if seen[g.Name] {
+				gerrs.Add(gprefix+"name", "%q is already used by another load balancer", g.Name)
+			}
+			g.validate(&gerrs, gprefix, defaults)
+			if len(gerrs) > 0 {
+				errs = append(errs, gerrs...)
+				continue
+			}
+			seen[g.Name] = true
+			out.LoadBalancers = append(out.LoadBalancers, g)
+		}
+	}
+	return out, errs.Err()
+}
+
+// Leftovers returns the load balancers of the discovery templates of f, with
+// a cleanup period, whose groups stamped still finds but ef, f expanded, no
+// longer has, such as those of deleted clusters. They are rendered again so
+// their cleanup can remove them, even after a restart.
+func (f *File) Leftovers(stamped Discoverer, ef *File) ([]*LoadBalancer, error) {
+	current := map[string]bool{}
+	for _, lb := range ef.LoadBalancers {
+		current[lb.Name] = true
+	}
+	leftovers := []*LoadBalancer{}
+	for i, lb := range f.LoadBalancers {
+		if lb.Discover == nil || lb.Cleanup.After <= 0 {
+			continue
+		}
+		groups, err := stamped(lb)
+		if err != nil {
+			return nil, fmt.Errorf("looking for the leftovers of loadBalancers[%d]: %s", i, err)
+		}
+		for _, v := range groups {
+			if !lb.Discover.matches(v) {
+				continue
+			}
+			g, err := lb.render(v)
+			if err != nil || current[g.Name] {
+				continue
+			}
+			current[g.Name] = true
+			leftovers = append(leftovers, g)
+		}
+	}
+	return leftovers, nil
+}
+
+// TemplateID identifies the discovery template lb is, or was rendered from,
+// in a label; it is empty for any other load balancer.
+func (lb *LoadBalancer) TemplateID() string {
+	t := lb.Template
+	if lb.Discover != nil {
+		t = lb.Name
+	}
+	if t == "" {
+		return ""
+	}
+	return fmt.Sprintf("%x", sha256.Sum256([]byte(t)))[:12]
+}
+
+// Discovers tells whether any load balancer of f is a discovery template.
+func (f *File) Discovers() bool {
+	for _, lb := range f.LoadBalancers {
+		if lb.Discover != nil {
+			return true
+		}
+	}
+	return false
+}
+
+func (d *Discovery) matches(value string) bool {
+	if d.GroupPattern == "" {
+		return true
+	}
+	ok, _ := path.Match(d.GroupPattern, value)
+	return ok
+}
+
+// render returns the load balancer of group.
+func (lb *LoadBalancer) render(group string) (*LoadBalancer, error) {
+	g := *lb
+	g.Discover = nil
+	g.Group = group
+	g.Template = lb.Name
+	data := map[string]string{"group": group}
+	var err error
+	if g.Name, err = renderTemplate("name", lb.Name, data); err != nil {
+		return nil, err
+	}
+	if g.Address, err = renderTemplate("address", lb.Address, data); err != nil {
+		return nil, err
+	}
+	g.Firewall.Tags = nil
+	for _, t := range lb.Firewall.Tags {
+		tag, err := renderTemplate("firewall.tags", t, data)
+		if err != nil {
+			return nil, err
+		}
+		g.Firewall.Tags = append(g.Firewall.Tags, tag)
+	}
+	g.Selector.Labels = map[string]string{lb.Discover.GroupLabel: group}
+	for k, v := range lb.Selector.Labels {
+		g.Selector.Labels[k] = v
+	}
+	return &g, nil
+}
+
+func renderTemplate(name, text string, data map[string]string) (string, error) {
+	t, err := template.New(name).Funcs(templateFuncs).Option("missingkey=error").Parse(text)
+	if err != nil {
+		return "", err
+	}
+	var b bytes.Buffer
+	if err := t.Execute(&b, data); err != nil {
+		return "", err
+	}
+	return b.String(), nil
+}
+
+// validateDiscovery checks the discovery settings of lb and returns the
+// load balancer its templates render for a sample group, for the rest of the
+// checks. It returns nil when the templates don't render.
+func (lb *LoadBalancer) validateDiscovery(errs *validate.Errors, prefix string) *LoadBalancer {
+	d := lb.Discover
+	errs.LabelKeyValue(prefix+"discover.groupLabel", d.GroupLabel, "")
+	if d.GroupPattern != "" {
+		if _, err := path.Match(d.GroupPattern, ""); err != nil {
+			errs.Add(prefix+"discover.groupPattern", "%q is not a valid glob: %s", d.GroupPattern, err)
+		}
+	}
+	if _, ok := lb.Selector.Labels[d.GroupLabel]; ok {
+		errs.Add(prefix+"selector.labels."+d.GroupLabel, "is the group label, it is set per group")
+	}
+	g, err := lb.render(sampleGroup)
+	if err != nil {
+		errs.Add(strings.TrimSuffix(prefix, "."), "%s", err)
+		return nil
+	}
+	return g
+}
@@ -0,0 +1,249 @@
+package spec
+
+import (
+	"fmt"
+	"io/ioutil"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/paulczar/gcp-lb-tags/pkg/cloud"
+	"github.com/paulczar/gcp-lb-tags/pkg/probe"
+	yaml "gopkg.in/yaml.v2"
+)
+
+// APIVersion is the version of the config file schema this build reads.
+const APIVersion = "gcp-lb-tags/v1"
+
+// File is a config file declaring any number of load balancers. It is
+// written in YAML or JSON.
+type File struct {
+	APIVersion    string          `yaml:"apiVersion"`
+	LoadBalancers []*LoadBalancer `yaml:"loadBalancers"`
+}
+
+// LoadBalancer declares one load balancer.
+type LoadBalancer struct {
+	Name    string `yaml:"name"`
+	Project string `yaml:"project,omitempty"`
+	Region  string `yaml:"region,omitempty"`
+	Network string `yaml:"network,omitempty"`
+	// Mode is tcp (the default) or udp.
+	Mode string `yaml:"mode,omitempty"`
+	// Ports are the ports or port ranges load balanced. The forwarding
+	// rule takes the smallest range covering all of them, the firewall
+	// only admits the ones listed.
+	Ports []string `yaml:"ports"`
+	// Address names the external IP address, the load balancer name when empty.
+	Address     string      `yaml:"address,omitempty"`
+	Zones       []string    `yaml:"zones,omitempty"`
+	Selector    Selector    `yaml:"selector"`
+	Firewall    Firewall    `yaml:"firewall,omitempty"`
+	HealthCheck HealthCheck `yaml:"healthCheck,omitempty"`
+
+	HealthGate cloud.HealthGate   `yaml:"healthGate,omitempty"`
+	Probe      probe.Config       `yaml:"probe,omitempty"`
+	Hysteresis cloud.Hysteresis   `yaml:"hysteresis,omitempty"`
+	Safety     cloud.SafetyLimits `yaml:"safety,omitempty"`
+	Drain      cloud.Drain        `yaml:"drain,omitempty"`
+	Backoff    cloud.Backoff      `yaml:"backoff,omitempty"`
+	Cleanup    cloud.Cleanup      `yaml:"cleanup,omitempty"`
+
+	// Discover makes the load balancer a template for every group of
+	// instances it finds, see Expand.
+	Discover *Discovery `yaml:"discover,omitempty"`
+	// Group is the group a discovered load balancer was rendered for, and
+	// Template the name of the load balancer it was rendered from.
+	Group    string `yaml:"-"`
+	Template string `yaml:"-"`
+}
+
+// Selector picks the instances of a load balancer.
+type Selector struct {
+	// Labels an instance must all carry.
+	Labels map[string]string `yaml:"labels"`
+}
+
+// Firewall shapes the firewall rule admitting traffic to the instances.
+type Firewall struct {
+	// Tags are the network tags the rule targets.
+	Tags []string `yaml:"tags,omitempty"`
+	// SourceRanges are the CIDRs admitted, anywhere when empty.
+	SourceRanges []string `yaml:"sourceRanges,omitempty"`
+}
+
+// HealthCheck enables an HTTP health check on the target pool.
+type HealthCheck struct {
+	Port string `yaml:"port,omitempty"`
+	Path string `yaml:"path,omitempty"`
+}
+
+// Load reads the config file at path.
+func Load(path string) (*File, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return Parse(data)
+}
+
+// Parse reads a config file. JSON is read as the subset of YAML it is.
+func Parse(data []byte) (*File, error) {
+	f := &File{}
+	if err := yaml.UnmarshalStrict(data, f); err != nil {
+		return nil, err
+	}
+	if f.APIVersion != APIVersion {
+		return nil, fmt.Errorf("unsupported apiVersion %q, expected %q", f.APIVersion, APIVersion)
+	}
+	return f, nil
+}
+
+// Config returns the config of the load balancer, taking the project,
+// region and network from defaults when they aren't set.
+func (lb *LoadBalancer) Config(defaults *cloud.Config) *cloud.Config {
+	cfg := &cloud.Config{
+		Name:            lb.Name,
+		ProjectID:       lb.Project,
+		Region:          lb.Region,
+		Network:         lb.Network,
+		Mode:            lb.Mode,
+		Address:         lb.Address,
+		Zones:           lb.Zones,
+		Tags:            lb.Firewall.Tags,
+		SourceRanges:    lb.Firewall.SourceRanges,
+		HealthCheckPort: lb.HealthCheck.Port,
+		HealthCheckPath: lb.HealthCheck.Path,
+		HealthGate:      lb.HealthGate,
+		Probe:           lb.Probe,
+		Hysteresis:      lb.Hysteresis,
+		Safety:          lb.Safety,
+		Drain:           lb.Drain,
+		Backoff:         lb.Backoff,
+		Cleanup:         lb.Cleanup,
+		Template:        lb.TemplateID(),
+		Group:           lb.Group,
+	}
+	if defaults != nil {
+		if cfg.ProjectID == "" {
+			cfg.ProjectID = defaults.ProjectID
+		}
+		if cfg.Region == "" {
+			cfg.Region = defaults.Region
+		}
+		if cfg.Network == "" {
+			cfg.Network = defaults.Network
+		}
+		cfg.Adopt = defaults.Adopt
+		cfg.State = defaults.State
+	}
+	if cfg.Mode == "" {
+		cfg.Mode = cloud.ModeTCP
+	}
+	if cfg.Address == "" {
+		cfg.Address = cfg.Name
+	}
+	if cfg.HealthCheckPath == "" {
+		cfg.HealthCheckPath = "/"
+	}
+	if cfg.HealthGate.RecheckCycles == 0 {
+		cfg.HealthGate.RecheckCycles = 5
+	}
+	if cfg.Probe.HealthyThreshold == 0 {
+		cfg.Probe.HealthyThreshold = 2
+	}
+	if cfg.Probe.UnhealthyThreshold == 0 {
+		cfg.Probe.UnhealthyThreshold = 3
+	}
+	if cfg.Backoff.Initial == 0 {
+		cfg.Backoff.Initial = 5 * time.Second
+	}
+	if cfg.Drain.Label == "" {
+		cfg.Drain.Label = "lb-drain:true"
+	}
+
+	for k, v := range lb.Selector.Labels {
+		cfg.Labels = append(cfg.Labels, k+":"+v)
+	}
+	sort.Strings(cfg.Labels)
+
+	cfg.Port = portRange(lb.Ports)
+	if len(lb.Ports) > 1 {
+		cfg.Ports = lb.Ports
+	}
+	return cfg
+}
+
+// FromConfig returns the load balancer declaring cfg, as import writes it out.
+func FromConfig(cfg *cloud.Config) *LoadBalancer {
+	lb := &LoadBalancer{
+		Name:        cfg.Name,
+		Project:     cfg.ProjectID,
+		Region:      cfg.Region,
+		Network:     cfg.Network,
+		Ports:       cfg.Ports,
+		Zones:       cfg.Zones,
+		Selector:    Selector{Labels: map[string]string{}},
+		Firewall:    Firewall{Tags: cfg.Tags, SourceRanges: cfg.SourceRanges},
+		HealthCheck: HealthCheck{Port: cfg.HealthCheckPort, Path: cfg.HealthCheckPath},
+		HealthGate:  cfg.HealthGate,
+		Probe:       cfg.Probe,
+		Hysteresis:  cfg.Hysteresis,
+		Safety:      cfg.Safety,
+		Drain:       cfg.Drain,
+		Backoff:     cfg.Backoff,
+		Cleanup:     cfg.Cleanup,
+	}
+	if cfg.Mode != cloud.ModeTCP {
+		lb.Mode = cfg.Mode
+	}
+	if len(lb.Ports) == 0 {
+		lb.Ports = []string{cfg.Port}
+	}
+	if cfg.Address != cfg.Name {
+		lb.Address = cfg.Address
+	}
+	for _, l := range cfg.Labels {
+		kv := strings.SplitN(l, ":", 2)
+		if len(kv) == 2 {
+			lb.Selector.Labels[kv[0]] = kv[1]
+		}
+	}
+	return lb
+}
+
+// portRange returns the smallest port range covering ports, or the only
+// entry when there is one.
+func portRange(ports []string) string {
+	if len(ports) == 1 {
+		return ports[0]
+	}
+	lo, hi := 0, 0
+	for _, p := range ports {
+		r := strings.SplitN(p, "-", 2)
+		plo, err := strconv.Atoi(r[0])
+		if err != nil {
+			continue
+		}
+		phi := plo
+		if len(r) == 2 {
+			if phi, err = strconv.Atoi(r[1]); err != nil {
+				continue
+			}
+		}
+		if lo == 0 || plo < lo {
+			lo = plo
+		}
+		if phi > hi {
+			hi = phi
+		}
+	}
+	if lo == 0 {
+		return ""
+	}
+	if lo == hi {
+		return strconv.Itoa(lo)
+	}
+	return fmt.Sprintf("%d-%d", lo, hi)
+}
@@ -0,0 +1,91 @@
+package spec
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/paulczar/gcp-lb-tags/pkg/cloud"
+	"github.com/paulczar/gcp-lb-tags/pkg/validate"
+)
+
+// specFields maps the Config fields reported by cloud.Config.ValidateInto
+// to where they are set in a config file.
+var specFields = map[string]string{
+	"port":            "ports",
+	"labels":          "selector.labels",
+	"tags":            "firewall.tags",
+	"sourceRanges":    "firewall.sourceRanges",
+	"healthCheckPort": "healthCheck.port",
+	"healthCheckPath": "healthCheck.path",
+}
+
+// Validate checks every load balancer in f, with the project, region and
+// network taken from defaults where they aren't set, and returns all the
+// problems found as validate.Errors.
+func (f *File) Validate(defaults *cloud.Config) error {
+	errs := validate.Errors{}
+	if len(f.LoadBalancers) == 0 {
+		errs.Add("loadBalancers", "at least one load balancer is required")
+	}
+	seen := map[string]int{}
+	for i, lb := range f.LoadBalancers {
+		prefix := fmt.Sprintf("loadBalancers[%d].", i)
+		if j, ok := seen[lb.Name]; ok && lb.Name != "" {
+			errs.Add(prefix+"name", "%q is already used by loadBalancers[%d]", lb.Name, j)
+		} else {
+			seen[lb.Name] = i
+		}
+		if lb.Discover != nil {
+			// the templates are checked with a sample group, the
+			// groups found are checked again when they are expanded
+			if g := lb.validateDiscovery(&errs, prefix); g != nil {
+				g.validate(&errs, prefix, defaults)
+			}
+			continue
+		}
+		lb.validate(&errs, prefix, defaults)
+	}
+	return errs.Err()
+}
+
+func (lb *LoadBalancer) validate(errs *validate.Errors, prefix string, defaults *cloud.Config) {
+	if len(lb.Ports) == 0 {
+		errs.Add(prefix+"ports", "at least one port is required")
+	}
+	for i, p := range lb.Ports {
+		errs.PortRange(fmt.Sprintf("%sports[%d]", prefix, i), p)
+	}
+	keys := []string{}
+	for k := range lb.Selector.Labels {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		errs.LabelKeyValue(prefix+"selector.labels."+k, k, lb.Selector.Labels[k])
+	}
+
+	cfg := lb.Config(defaults)
+	cfg.ValidateInto(errs, func(field string) string {
+		switch {
+		case field == "port":
+			// the forwarding rule's range is derived from ports, which
+			// are checked one by one above
+			return ""
+		case strings.HasPrefix(field, "labels["):
+			// checked above against the selector's keys
+			return ""
+		case strings.HasPrefix(field, "ports["):
+			// checked above
+			return ""
+		}
+		name, index := field, ""
+		if i := strings.Index(field, "["); i >= 0 {
+			name, index = field[:i], field[i:]
+		}
+		if mapped, ok := specFields[name]; ok {
+			name = mapped
+		}
+		return prefix + name + index
+	})
+}
@@ -0,0 +1,274 @@
+package spec
+
+import (
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/paulczar/gcp-lb-tags/pkg/cloud"
+	"github.com/paulczar/gcp-lb-tags/pkg/validate"
+)
+
+// paths returns the sorted paths of the fields err reports.
+func paths(t *testing.T, err error) []string {
+	t.Helper()
+	if err == nil {
+		return []string{}
+	}
+	errs, ok := err.(validate.Errors)
+	if !ok {
+		t.Fatalf("error %v is a %T, want validate.Errors", err, err)
+	}
+	p := []string{}
+	for _, e := range errs {
+		p = append(p, e.Path)
+	}
+	sort.Strings(p)
+	return p
+}
+
+const validLB = `
+- name: pks-demo
+  ports: ["8443"]
+  selector:
+    labels:
+      job: master
+`
+
+func TestValidate(t *testing.T) {
+	defaults := &cloud.Config{ProjectID: "project", Region: "us-central1", Network: "default"}
+	tests := []struct {
+		name string
+		lbs  string
+		want []string
+	}{
+		{"valid", validLB, []string{}},
+		{"no load balancers", `[]`, []string{"loadBalancers"}},
+		{
+			name: "duplicate names",
+			lbs:  validLB + validLB,
+			want: []string{"loadBalancers[1].name"},
+		},
+		{
+			name: "bad ports",
+			lbs: `
+- name: pks-demo
+  ports: ["8443", "0", "9000-8000"]
+  selector:
+    labels:
+      job: master
+`,
+			want: []string{"loadBalancers[0].ports[1]", "loadBalancers[0].ports[2]"},
+		},
+		{
+			name: "no ports or labels",
+			lbs: `
+- name: pks-demo
+  selector: {}
+`,
+			want: []string{"loadBalancers[0].ports", "loadBalancers[0].selector.labels"},
+		},
+		{
+			name: "bad fields",
+			lbs: `
+- name: Demo
+  region: us-central1
+  mode: sctp
+  ports: ["8443"]
+  zones: [europe-west1-b]
+  selector:
+    labels:
+      Job: master
+  firewall:
+    tags: [Masters]
+    sourceRanges: [10.0.0.0]
+  healthCheck:
+    port: "http"
+    path: healthz
+`,
+			want: []string{
+				"loadBalancers[0].firewall.sourceRanges[0]",
+				"loadBalancers[0].firewall.tags[0]",
+				"loadBalancers[0].healthCheck.path",
+				"loadBalancers[0].healthCheck.port",
+				"loadBalancers[0].mode",
+				"loadBalancers[0].name",
+				"loadBalancers[0].selector.labels.Job",
+				"loadBalancers[0].zones[0]",
+			},
+		},
+		{
+			name: "health check firewall rule name too long",
+			lbs: `
+- name: pks-` + strings.Repeat("a", 58) + `
+  ports: ["8443"]
+  selector:
+    labels:
+      job: master
+  healthCheck:
+    port: "8080"
+`,
+			want: []string{"loadBalancers[0].name"},
+		},
+		{
+			name: "udp probe without port",
+			lbs: `
+- name: dns
+  mode: udp
+  ports: ["53"]
+  selector:
+    labels:
+      job: dns
+  probe:
+    mode: tcp
+`,
+			want: []string{"loadBalancers[0].probe.port"},
+		},
+		{
+			name: "bad max remove",
+			lbs: `
+- name: pks-demo
+  ports: ["8443"]
+  selector:
+    labels:
+      job: master
+  safety:
+    maxRemove: lots
+`,
+			want: []string{"loadBalancers[0].safety.maxRemove"},
+		},
+		{
+			name: "discovery",
+			lbs: `
+- name: 'pks-{{trimPrefix .group "service-instance-"}}'
+  ports: ["8443"]
+  selector:
+    labels:
+      job: master
+  discover:
+    groupLabel: deployment
+    groupPattern: service-instance-*
+`,
+			want: []string{},
+		},
+		{
+			name: "discovery selecting its group label",
+			lbs: `
+- name: 'pks-{{.group}}'
+  ports: ["8443"]
+  selector:
+    labels:
+      job: master
+      deployment: one
+  discover:
+    groupLabel: deployment
+    groupPattern: "["
+`,
+			want: []string{"loadBalancers[0].discover.groupPattern", "loadBalancers[0].selector.labels.deployment"},
+		},
+		{
+			name: "discovery template not rendering",
+			lbs: `
+- name: 'pks-{{.group'
+  ports: ["8443"]
+  selector:
+    labels:
+      job: master
+  discover:
+    groupLabel: deployment
+`,
+			want: []string{"loadBalancers[0]"},
+		},
+	}
+	for _, tt := range tests {
+		f, err := Parse([]byte("apiVersion: " + APIVersion + "\nloadBalancers: " + tt.lbs))
+		if err != nil {
+			t.Errorf("%s: Parse() error = %v", tt.name, err)
+			continue
+		}
+		if got := paths(t, f.Validate(defaults)); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: Validate() reports %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		name    string
+		data    string
+		wantErr string
+	}{
+		{"yaml", "apiVersion: " + APIVersion + "\nloadBalancers: " + validLB, ""},
+		{"json", `{"apiVersion": "` + APIVersion + `", "loadBalancers": [{"name": "pks-demo", "ports": ["8443"]}]}`, ""},
+		{"other version", "apiVersion: gcp-lb-tags/v2\nloadBalancers: []", "unsupported apiVersion"},
+		{"unknown field", "apiVersion: " + APIVersion + "\nloadBalancers:\n- name: a\n  port: 80", "field port not found"},
+	}
+	for _, tt := range tests {
+		_, err := Parse([]byte(tt.data))
+		switch {
+		case tt.wantErr == "" && err != nil:
+			t.Errorf("%s: Parse() error = %v", tt.name, err)
+		case tt.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErr)):
+			t.Errorf("%s: Parse() error = %v, want %q", tt.name, err, tt.wantErr)
+		}
+	}
+}
+
+func TestPortRange(t *testing.T) {
+	tests := []struct {
+		ports []string
+		want  string
+	}{
+		{[]string{"8443"}, "8443"},
+		{[]string{"8000-8100"}, "8000-8100"},
+		{[]string{"80", "443"}, "80-443"},
+		{[]string{"443", "80", "8000-8100"}, "80-8100"},
+		{[]string{"80", "80"}, "80"},
+		{[]string{"x", "443"}, "443"},
+		{[]string{"x", "y"}, ""},
+	}
+	for _, tt := range tests {
+		if got := portRange(tt.ports); got != tt.want {
+			t.Errorf("portRange(%v) = %q, want %q", tt.ports, got, tt.want)
+		}
+	}
+}
+
+func TestConfigRoundTrip(t *testing.T) {
+	lb := &LoadBalancer{
+		Name:        "pks-demo",
+		Project:     "project",
+		Region:      "us-central1",
+		Network:     "default",
+		Mode:        cloud.ModeUDP,
+		Ports:       []string{"53", "5353"},
+		Address:     "pks-vip",
+		Selector:    Selector{Labels: map[string]string{"job": "master", "deployment": "one"}},
+		Firewall:    Firewall{Tags: []string{"master"}, SourceRanges: []string{"10.0.0.0/8"}},
+		HealthCheck: HealthCheck{Port: "8080", Path: "/healthz"},
+	}
+	cfg := lb.Config(nil)
+	if cfg.Port != "53-5353" {
+		t.Errorf("Config().Port = %q, want 53-5353", cfg.Port)
+	}
+	if want := []string{"deployment:one", "job:master"}; !reflect.DeepEqual(cfg.Labels, want) {
+		t.Errorf("Config().Labels = %v, want %v", cfg.Labels, want)
+	}
+	back := FromConfig(cfg)
+	for _, f := range []struct {
+		name      string
+		got, want interface{}
+	}{
+		{"mode", back.Mode, lb.Mode},
+		{"ports", back.Ports, lb.Ports},
+		{"address", back.Address, lb.Address},
+		{"selector", back.Selector, lb.Selector},
+		{"firewall", back.Firewall, lb.Firewall},
+		{"health check", back.HealthCheck, lb.HealthCheck},
+	} {
+		if !reflect.DeepEqual(f.got, f.want) {
+			t.Errorf("FromConfig(Config()) %s = %v, want %v", f.name, f.got, f.want)
+		}
+	}
+}
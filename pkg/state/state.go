@@ -0,0 +1,130 @@
+package state
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// File is the state kept between reconciles, keyed by load balancer name.
+// It is saved to a local JSON file when it has a path, so it survives
+// restarts, and only kept in memory otherwise.
+type File struct {
+	path string
+
+	mu            sync.Mutex
+	LoadBalancers map[string]*LoadBalancer `json:"loadBalancers"`
+}
+
+// LoadBalancer is the state of one load balancer.
+type LoadBalancer struct {
+	// Members is the membership history of each instance, keyed by self link.
+	Members map[string]*Member `json:"members,omitempty"`
+	// Draining holds the members waiting out their drain grace period.
+	Draining map[string]*Drain `json:"draining,omitempty"`
+	// Operations holds the GCE operations started but not yet seen to
+	// finish, keyed by operation name.
+	Operations map[string]*Operation `json:"operations,omitempty"`
+	// EmptySince is when the selector last started matching no instance,
+	// nil while it matches some.
+	EmptySince *time.Time `json:"emptySince,omitempty"`
+}
+
+// Member records how long an instance has consistently been wanted, or
+// not wanted, in the target pool.
+type Member struct {
+	Wanted bool `json:"wanted"`
+	// Count is the number of consecutive reconciles Wanted has held.
+	Count int       `json:"count"`
+	Since time.Time `json:"since"`
+}
+
+// Drain records when a member started draining.
+type Drain struct {
+	Since time.Time `json:"since"`
+	// Signalled is set once the member was told to fail its health check.
+	Signalled bool `json:"signalled,omitempty"`
+}
+
+// Operation is a pending GCE operation.
+type Operation struct {
+	SelfLink string    `json:"selfLink"`
+	Type     string    `json:"type"`
+	Target   string    `json:"target"`
+	Since    time.Time `json:"since"`
+}
+
+// Open loads the state file at path, an empty path keeps state in memory.
+func Open(path string) (*File, error) {
+	f := &File{path: path, LoadBalancers: map[string]*LoadBalancer{}}
+	if path == "" {
+		return f, nil
+	}
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return f, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err = json.Unmarshal(data, f); err != nil {
+		return nil, err
+	}
+	if f.LoadBalancers == nil {
+		f.LoadBalancers = map[string]*LoadBalancer{}
+	}
+	return f, nil
+}
+
+// Update calls fn with the state of the named load balancer while holding
+// the file's lock.
+func (f *File) Update(name string, fn func(lb *LoadBalancer)) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	lb, ok := f.LoadBalancers[name]
+	if !ok {
+		lb = &LoadBalancer{}
+		f.LoadBalancers[name] = lb
+	}
+	if lb.Members == nil {
+		lb.Members = map[string]*Member{}
+	}
+	if lb.Draining == nil {
+		lb.Draining = map[string]*Drain{}
+	}
+	if lb.Operations == nil {
+		lb.Operations = map[string]*Operation{}
+	}
+	fn(lb)
+}
+
+// Save writes the state to disk, it is a no-op for in-memory state.
+func (f *File) Save() error {
+	if f.path == "" {
+		return nil
+	}
+	f.mu.Lock()
+	data, err := json.MarshalIndent(f, "", "  ")
+	f.mu.Unlock()
+	if err != nil {
+		return err
+	}
+	// write then rename so a crash never leaves a truncated file behind
+	tmp, err := ioutil.TempFile(filepath.Dir(f.path), filepath.Base(f.path)+".")
+	if err != nil {
+		return err
+	}
+	if _, err = tmp.Write(data); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err = tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return os.Rename(tmp.Name(), f.path)
+}
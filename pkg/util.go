@@ -1,7 +1,9 @@
 package util
 
 import (
+	"fmt"
 	"log"
+	"strings"
 
 	"github.com/spf13/cobra"
 )
@@ -14,3 +16,17 @@ func GetFlagStringSlice(cmd *cobra.Command, flag string) []string {
 	}
 	return s
 }
+
+// CheckRequiredFlags returns an error naming every flag in names that wasn't set on the command line.
+func CheckRequiredFlags(cmd *cobra.Command, names ...string) error {
+	missing := []string{}
+	for _, n := range names {
+		if f := cmd.Flags().Lookup(n); f != nil && !f.Changed {
+			missing = append(missing, n)
+		}
+	}
+	if len(missing) > 0 {
+		return fmt.Errorf(`required flag(s) "%s" not set`, strings.Join(missing, `", "`))
+	}
+	return nil
+}
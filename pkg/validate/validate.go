@@ -0,0 +1,142 @@
+package validate
+
+import (
+	"fmt"
+	"net"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// MaxNameLength is the longest GCE resource name.
+const MaxNameLength = 63
+
+var (
+	// rfc1035 is the form of GCE resource names.
+	rfc1035    = regexp.MustCompile(`^[a-z]([-a-z0-9]*[a-z0-9])?$`)
+	labelKey   = regexp.MustCompile(`^[a-z][-_a-z0-9]{0,62}$`)
+	labelValue = regexp.MustCompile(`^[-_a-z0-9]{0,63}$`)
+)
+
+// FieldError is a problem with one field of a load balancer definition.
+type FieldError struct {
+	Path    string `json:"path"`
+	Message string `json:"message"`
+}
+
+func (e *FieldError) Error() string {
+	return e.Path + ": " + e.Message
+}
+
+// Errors collects every problem found in a definition, so they can all be
+// reported together.
+type Errors []*FieldError
+
+func (e Errors) Error() string {
+	msgs := []string{}
+	for _, fe := range e {
+		msgs = append(msgs, fe.Error())
+	}
+	return fmt.Sprintf("%d invalid field(s):\n  %s", len(e), strings.Join(msgs, "\n  "))
+}
+
+// Add records a problem with the field at path. Problems with an empty path
+// are dropped, so a caller renaming fields can skip the ones it checks itself.
+func (e *Errors) Add(path, format string, args ...interface{}) {
+	if path == "" {
+		return
+	}
+	*e = append(*e, &FieldError{Path: path, Message: fmt.Sprintf(format, args...)})
+}
+
+// Err returns e as an error, or nil when nothing was found.
+func (e Errors) Err() error {
+	if len(e) == 0 {
+		return nil
+	}
+	return e
+}
+
+// Name checks a GCE resource name, such as the load balancer name each of
+// its resources is named after.
+func (e *Errors) Name(path, name string) {
+	switch {
+	case name == "":
+		e.Add(path, "is required")
+	case len(name) > MaxNameLength:
+		e.Add(path, "%q is %d characters, GCE names are at most %d", name, len(name), MaxNameLength)
+	case !rfc1035.MatchString(name):
+		e.Add(path, "%q must start with a lowercase letter and contain only lowercase letters, digits and dashes, not ending in a dash (RFC1035)", name)
+	}
+}
+
+// PortRange checks a port such as "8443" or a range such as "8000-8100".
+func (e *Errors) PortRange(path, r string) {
+	if r == "" {
+		e.Add(path, "is required")
+		return
+	}
+	p := strings.SplitN(r, "-", 2)
+	lo, err := strconv.Atoi(p[0])
+	if err != nil || lo < 1 || lo > 65535 {
+		e.Add(path, "%q is not a port between 1 and 65535 or a range of them", r)
+		return
+	}
+	if len(p) == 2 {
+		hi, err := strconv.Atoi(p[1])
+		if err != nil || hi < 1 || hi > 65535 {
+			e.Add(path, "%q is not a port between 1 and 65535 or a range of them", r)
+			return
+		}
+		if hi < lo {
+			e.Add(path, "%q ends before it starts", r)
+		}
+	}
+}
+
+// Port checks a single port.
+func (e *Errors) Port(path, p string) {
+	n, err := strconv.Atoi(p)
+	if err != nil || n < 1 || n > 65535 {
+		e.Add(path, "%q is not a port between 1 and 65535", p)
+	}
+}
+
+// CIDR checks an IPv4 range such as 10.0.0.0/8.
+func (e *Errors) CIDR(path, cidr string) {
+	ip, _, err := net.ParseCIDR(cidr)
+	if err != nil || ip.To4() == nil {
+		e.Add(path, "%q is not an IPv4 CIDR range", cidr)
+	}
+}
+
+// Label checks a label selector written key:value.
+func (e *Errors) Label(path, l string) {
+	s := strings.SplitN(l, ":", 2)
+	if len(s) != 2 {
+		e.Add(path, "%q must be written key:value", l)
+		return
+	}
+	e.LabelKeyValue(path, s[0], s[1])
+}
+
+// LabelKeyValue checks the syntax of a GCE label key and value.
+func (e *Errors) LabelKeyValue(path, key, value string) {
+	if !labelKey.MatchString(key) {
+		e.Add(path, "label key %q must start with a lowercase letter and contain at most 63 lowercase letters, digits, dashes and underscores", key)
+	}
+	if !labelValue.MatchString(value) {
+		e.Add(path, "label value %q may contain at most 63 lowercase letters, digits, dashes and underscores", value)
+	}
+}
+
+// Zone checks that zone is in region. A zone may also be given as just its
+// suffix, such as "a", which is appended to the region.
+func (e *Errors) Zone(path, zone, region string) {
+	if len(zone) == 1 && zone >= "a" && zone <= "z" {
+		return
+	}
+	if !strings.HasPrefix(zone, region+"-") || len(zone) != len(region)+2 {
+		e.Add(path, "zone %q is not in region %s", zone, region)
+	}
+}
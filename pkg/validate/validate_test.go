@@ -0,0 +1,77 @@
+package validate
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestErrors(t *testing.T) {
+	tests := []struct {
+		name  string
+		check func(e *Errors)
+		// want is the message expected, empty when the value is valid
+		want string
+	}{
+		{"name", func(e *Errors) { e.Name("name", "pks-demo") }, ""},
+		{"empty name", func(e *Errors) { e.Name("name", "") }, "is required"},
+		{"long name", func(e *Errors) { e.Name("name", "a"+strings.Repeat("b", MaxNameLength)) }, "GCE names are at most 63"},
+		{"uppercase name", func(e *Errors) { e.Name("name", "Demo") }, "RFC1035"},
+		{"name ending in a dash", func(e *Errors) { e.Name("name", "demo-") }, "RFC1035"},
+
+		{"port", func(e *Errors) { e.PortRange("port", "8443") }, ""},
+		{"port range", func(e *Errors) { e.PortRange("port", "8000-8100") }, ""},
+		{"single port range", func(e *Errors) { e.PortRange("port", "80-80") }, ""},
+		{"empty port", func(e *Errors) { e.PortRange("port", "") }, "is required"},
+		{"port zero", func(e *Errors) { e.PortRange("port", "0") }, "not a port"},
+		{"port too high", func(e *Errors) { e.PortRange("port", "65536") }, "not a port"},
+		{"port name", func(e *Errors) { e.PortRange("port", "https") }, "not a port"},
+		{"range end too high", func(e *Errors) { e.PortRange("port", "80-70000") }, "not a port"},
+		{"open range", func(e *Errors) { e.PortRange("port", "80-") }, "not a port"},
+		{"backwards range", func(e *Errors) { e.PortRange("port", "8100-8000") }, "ends before it starts"},
+
+		{"single port", func(e *Errors) { e.Port("port", "80") }, ""},
+		{"single port given a range", func(e *Errors) { e.Port("port", "80-81") }, "not a port"},
+
+		{"cidr", func(e *Errors) { e.CIDR("cidr", "10.0.0.0/8") }, ""},
+		{"address without mask", func(e *Errors) { e.CIDR("cidr", "10.0.0.1") }, "not an IPv4 CIDR"},
+		{"ipv6 cidr", func(e *Errors) { e.CIDR("cidr", "2001:db8::/32") }, "not an IPv4 CIDR"},
+
+		{"label", func(e *Errors) { e.Label("label", "job:master") }, ""},
+		{"label with empty value", func(e *Errors) { e.Label("label", "job:") }, ""},
+		{"label without colon", func(e *Errors) { e.Label("label", "job") }, "key:value"},
+		{"label with uppercase key", func(e *Errors) { e.Label("label", "Job:master") }, "label key"},
+		{"label with uppercase value", func(e *Errors) { e.Label("label", "job:Master") }, "label value"},
+
+		{"zone", func(e *Errors) { e.Zone("zone", "us-central1-a", "us-central1") }, ""},
+		{"zone suffix", func(e *Errors) { e.Zone("zone", "b", "us-central1") }, ""},
+		{"zone in another region", func(e *Errors) { e.Zone("zone", "europe-west1-b", "us-central1") }, "is not in region"},
+		{"region as zone", func(e *Errors) { e.Zone("zone", "us-central1", "us-central1") }, "is not in region"},
+	}
+	for _, tt := range tests {
+		errs := Errors{}
+		tt.check(&errs)
+		switch {
+		case tt.want == "" && len(errs) > 0:
+			t.Errorf("%s: unexpected error %v", tt.name, errs)
+		case tt.want != "" && len(errs) != 1:
+			t.Errorf("%s: got %d errors (%v), want one", tt.name, len(errs), errs)
+		case tt.want != "" && !strings.Contains(errs[0].Message, tt.want):
+			t.Errorf("%s: error %q, want it to contain %q", tt.name, errs[0].Message, tt.want)
+		}
+	}
+}
+
+func TestErrorsAdd(t *testing.T) {
+	errs := Errors{}
+	if errs.Err() != nil {
+		t.Errorf("Err() of no errors = %v, want nil", errs.Err())
+	}
+	errs.Add("", "skipped")
+	errs.Add("ports[0]", "%q is bad", "x")
+	if len(errs) != 1 {
+		t.Fatalf("got %d errors, want the one with a path", len(errs))
+	}
+	if got, want := errs.Err().Error(), "1 invalid field(s):\n  ports[0]: \"x\" is bad"; got != want {
+		t.Errorf("Error() = %q, want %q", got, want)
+	}
+}
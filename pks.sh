@@ -1,23 +1,18 @@
 #!/bin/bash
 
-URL="http://metadata.google.internal/computeMetadata/v1/"
-HEADER='Metadata-Flavor: Google'
+# --from-metadata fills in the project, network and region of this instance,
+# and the PKS cluster UUID is rendered from its p-bosh-service-instance-<uuid> tag
+UUID='{{tagSuffix `p-bosh-service-instance-`}}'
 
-PROJECT_ID=$(curl -sH "$HEADER" $URL/project/project-id)
-
-FQ_NETWORK=$(curl -sH "$HEADER" $URL/instance/network-interfaces/0/network)
-NETWORK=$(basename "$FQ_NETWORK")
-UUID=$(curl -sH "$HEADER" "$URL/instance/tags?alt=text" | grep p-bosh-service-instance | sed 's/^p-bosh-service-instance-//')
-
-FQ_ZONE=$(curl -sH "$HEADER" $URL/instance/zone)
-REGION=$(basename "$FQ_ZONE" | sed 's/-.$//')
-
-echo "network: $NETWORK"
-echo "project: $PROJECT_ID"
-echo "uuid: $UUID"
-echo "region: $REGION"
-
-/app/gcp-lb-tags create --loop --name pks-$UUID \
-   --project $PROJECT_ID --network $NETWORK \
-   --region $REGION --port 8443 --tags=service-instance-$UUID-master \
-   --labels="deployment:service-instance-$UUID" --labels="job:master"
\ No newline at end of file
+# both replicas of the deployment run, only the one holding the leader Lease
+# reconciles
+# the state file on the pod's state volume survives container restarts, so
+# operations left pending by a crash are waited for before anything new
+# --adopt takes over the load balancer an earlier release built without an
+# ownership marker; every resource is named after this cluster's UUID, and
+# once the first loop has stamped them the flag changes nothing
+# exec so that the pod's SIGTERM reaches gcp-lb-tags and it can stop cleanly
+exec /app/gcp-lb-tags create --loop --leader-elect kubernetes --from-metadata --name "pks-$UUID" \
+   --state-file /state/gcp-lb-tags.json --adopt \
+   --port 8443 --tags="service-instance-$UUID-master" \
+   --labels="deployment:service-instance-$UUID" --labels="job:master"